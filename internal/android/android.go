@@ -0,0 +1,178 @@
+// Android target: ABIs, API level, NDK toolchain and APK assembly.
+//
+// Build phase of a package runs once per ABI with $abi variable and
+// environment for cross compilation (GOOS, GOARCH, CC, ...). It must put
+// shared libraries to '$out/lib/$abi', after that they are packed to a debug
+// APK together with generated AndroidManifest.xml
+package android
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"raypm/internal/errs"
+	"raypm/internal/pkglua"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultAPILevel = 29
+	MinAPILevel     = 24 // v1 signature uses SHA-256, older Androids don't know it
+
+	// Name of the dependency with Android NDK
+	NdkPackage = "ndk"
+)
+
+type ABI struct {
+	Name   string
+	GOARCH string
+	GOARM  string
+	Triple string // Prefix of clang in NDK
+}
+
+var ABIs = []ABI{
+	{Name: "arm64-v8a", GOARCH: "arm64", Triple: "aarch64-linux-android"},
+	{Name: "armeabi-v7a", GOARCH: "arm", GOARM: "7", Triple: "armv7a-linux-androideabi"},
+	{Name: "x86", GOARCH: "386", Triple: "i686-linux-android"},
+	{Name: "x86_64", GOARCH: "amd64", Triple: "x86_64-linux-android"},
+}
+
+// ABI for GOARCH, used when package doesn't list 'abis'
+func ABIForArch(goarch string) (abi ABI, ok bool) {
+	i := slices.IndexFunc(ABIs, func(a ABI) bool { return a.GOARCH == goarch })
+	if i < 0 {
+		return
+	}
+
+	return ABIs[i], true
+}
+
+func FindABI(name string) (abi ABI, ok bool) {
+	i := slices.IndexFunc(ABIs, func(a ABI) bool { return a.Name == name })
+	if i < 0 {
+		return
+	}
+
+	return ABIs[i], true
+}
+
+type Target struct {
+	Name     string // Package's name
+	Version  string
+	AppID    string // Java package, like 'com.raylib.snake'
+	LibName  string // lib<LibName>.so is loaded by NativeActivity
+	APILevel int
+	ABIs     []ABI
+	Ndk      string // Root of NDK (directory of 'ndk' package in the store)
+}
+
+// Creates the target from package's fields:
+//   - abis (default: arm64-v8a)
+//   - api_level (default: DefaultAPILevel)
+//   - app_id (default: com.raypm.<name>)
+//   - lib_name (default: <name>)
+func NewTarget(name, version string, spec pkglua.TargetSpec, ndk string) (t *Target, err error) {
+	t = &Target{
+		Name:     name,
+		Version:  version,
+		AppID:    "com.raypm." + strings.ReplaceAll(name, "-", "_"),
+		LibName:  name,
+		APILevel: DefaultAPILevel,
+		Ndk:      ndk,
+	}
+
+	if v := spec.Value("app_id"); v != "" {
+		t.AppID = v
+	}
+
+	if v := spec.Value("lib_name"); v != "" {
+		t.LibName = v
+	}
+
+	if v := spec.Value("api_level"); v != "" {
+		if t.APILevel, err = strconv.Atoi(v); err != nil {
+			err = errs.Wrap(errs.Lua, "WrongAPILevel", err, "'%s'", v)
+			return
+		}
+	}
+
+	if t.APILevel < MinAPILevel {
+		err = errs.New(errs.Lua, "APILevelIsTooLow", "%d, minimal is %d", t.APILevel, MinAPILevel)
+		return
+	}
+
+	abis := spec["abis"]
+	if len(abis) == 0 {
+		abis = []string{ABIs[0].Name}
+	}
+
+	for _, item := range abis {
+		abi, ok := FindABI(item)
+		if !ok {
+			err = errs.New(errs.Lua, "UnknownABI", "'%s'", item)
+			return
+		}
+		t.ABIs = append(t.ABIs, abi)
+	}
+
+	return
+}
+
+// Directory with clang of NDK for current host
+func (t *Target) ToolchainBin() string {
+	host := runtime.GOOS + "-x86_64"
+	if runtime.GOOS == "darwin" {
+		host = "darwin-x86_64" // NDK ships universal binaries under this name
+	}
+
+	return path.Join(t.Ndk, "toolchains", "llvm", "prebuilt", host, "bin")
+}
+
+// Environment for building the ABI with Go and C
+func (t *Target) Env(abi ABI) (env map[string]string) {
+	bin := t.ToolchainBin()
+	clang := fmt.Sprintf("%s%d-clang", abi.Triple, t.APILevel)
+	if runtime.GOOS == "windows" {
+		clang += ".cmd"
+	}
+
+	env = map[string]string{
+		"GOOS":        "android",
+		"GOARCH":      abi.GOARCH,
+		"CGO_ENABLED": "1",
+		"CC":          filepath.Join(bin, clang),
+		"CXX":         filepath.Join(bin, clang+"++"),
+		"ANDROID_ABI": abi.Name,
+		"ANDROID_API": strconv.Itoa(t.APILevel),
+	}
+
+	if abi.GOARM != "" {
+		env["GOARM"] = abi.GOARM
+	}
+
+	if t.Ndk != "" {
+		env["ANDROID_NDK_HOME"] = t.Ndk
+	}
+
+	return
+}
+
+// Checks, that NDK has clang for every ABI
+func (t *Target) CheckNdk() (err error) {
+	if t.Ndk == "" {
+		return errs.New(errs.Resolution, "NdkNotFound", "add '%s' to dependencies", NdkPackage)
+	}
+
+	for _, abi := range t.ABIs {
+		cc := t.Env(abi)["CC"]
+		if _, err = os.Stat(cc); err != nil {
+			return errs.Wrap(errs.Resolution, "NdkCompilerNotFound", err, "'%s'", cc)
+		}
+	}
+
+	return
+}
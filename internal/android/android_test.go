@@ -0,0 +1,256 @@
+package android
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/binary"
+	"io"
+	"os"
+	"path"
+	log "raypm/pkg/slog"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+func TestNewTarget(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		target, err := NewTarget("snake-game", "1.0", map[string][]string{}, "")
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		if target.AppID != "com.raypm.snake_game" {
+			t.Errorf("Expect 'com.raypm.snake_game', got '%s'", target.AppID)
+		}
+
+		if len(target.ABIs) != 1 || target.ABIs[0].Name != "arm64-v8a" {
+			t.Errorf("Expect arm64-v8a by default, got %v", target.ABIs)
+		}
+	})
+
+	t.Run("fields", func(t *testing.T) {
+		target, err := NewTarget("snake", "1.0", map[string][]string{
+			"abis":      {"armeabi-v7a", "x86_64"},
+			"api_level": {"33"},
+			"lib_name":  {"main"},
+		}, "/store/a-ndk-27")
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		env := target.Env(target.ABIs[0])
+		if env["GOARCH"] != "arm" || env["GOARM"] != "7" || env["GOOS"] != "android" {
+			t.Errorf("Wrong env for armeabi-v7a: %v", env)
+		}
+
+		if !strings.HasSuffix(env["CC"], "armv7a-linux-androideabi33-clang") ||
+			!strings.HasPrefix(env["CC"], "/store/a-ndk-27/toolchains/llvm/prebuilt/") {
+			t.Errorf("Wrong compiler: '%s'", env["CC"])
+		}
+
+		if err = target.CheckNdk(); err == nil {
+			t.Error("Expect error for missing NDK")
+		}
+	})
+
+	t.Run("wrong fields", func(t *testing.T) {
+		for _, spec := range []map[string][]string{
+			{"abis": {"mips"}},
+			{"api_level": {"twenty"}},
+			{"api_level": {"21"}},
+		} {
+			if _, err := NewTarget("snake", "1.0", spec, ""); err == nil {
+				t.Errorf("Expect error for %v", spec)
+			}
+		}
+	})
+}
+
+// Reads names of elements and string values of attributes from binary XML
+func decodeXml(t *testing.T, data []byte) (elems []string, values []string) {
+	if binary.LittleEndian.Uint16(data) != chunkXml {
+		t.Fatal("Not a binary XML")
+	}
+
+	var pool []string
+	for pos := 8; pos < len(data); {
+		typ := binary.LittleEndian.Uint16(data[pos:])
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		c := data[pos : pos+size]
+
+		switch typ {
+		case chunkStringPool:
+			count := int(binary.LittleEndian.Uint32(c[8:]))
+			start := int(binary.LittleEndian.Uint32(c[20:]))
+			for i := range count {
+				off := start + int(binary.LittleEndian.Uint32(c[28+4*i:]))
+				n := int(binary.LittleEndian.Uint16(c[off:]))
+				units := make([]uint16, n)
+				for j := range units {
+					units[j] = binary.LittleEndian.Uint16(c[off+2+2*j:])
+				}
+				pool = append(pool, string(utf16.Decode(units)))
+			}
+		case chunkElemStart:
+			elems = append(elems, pool[binary.LittleEndian.Uint32(c[20:])])
+			count := int(binary.LittleEndian.Uint16(c[28:]))
+			for i := range count {
+				attr := c[36+20*i:]
+				if attr[15] == typeString {
+					values = append(values, pool[binary.LittleEndian.Uint32(attr[16:])])
+				}
+			}
+		}
+
+		pos += size
+	}
+
+	return
+}
+
+func TestManifest(t *testing.T) {
+	target, _ := NewTarget("snake", "1.2", map[string][]string{"app_id": {"com.raylib.snake"}}, "")
+	elems, values := decodeXml(t, encodeXml(target.manifest()))
+
+	wantElems := []string{"manifest", "uses-sdk", "uses-feature", "application",
+		"activity", "meta-data", "intent-filter", "action", "category"}
+	if !slices.Equal(elems, wantElems) {
+		t.Errorf("Expect %v, got %v", wantElems, elems)
+	}
+
+	for _, item := range []string{"com.raylib.snake", "1.2", "android.app.NativeActivity", "snake"} {
+		if !slices.Contains(values, item) {
+			t.Errorf("Expect '%s' in %v", item, values)
+		}
+	}
+}
+
+func TestBuildAPK(t *testing.T) {
+	log.Init(false)
+
+	dir, err := os.MkdirTemp(os.TempDir(), "android_test_*")
+	if err != nil {
+		t.Errorf("Cannot create temp directory: %s\n", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	target, _ := NewTarget("snake", "1.0", map[string][]string{"abis": {"arm64-v8a", "x86"}}, "")
+
+	key, err := LoadDebugKey(path.Join(dir, "debug.pem"))
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	t.Run("missing library", func(t *testing.T) {
+		if _, err := target.BuildAPK(dir, key); err == nil {
+			t.Error("Expect error without native libraries")
+		}
+	})
+
+	for _, abi := range []string{"arm64-v8a", "x86"} {
+		os.MkdirAll(path.Join(dir, "lib", abi), 0754)
+		os.WriteFile(path.Join(dir, "lib", abi, "libsnake.so"), []byte("ELF "+abi), 0644)
+	}
+
+	apk, err := target.BuildAPK(dir, key)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	data, _ := os.ReadFile(apk)
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	files := make(map[string]*zip.File)
+	for _, item := range r.File {
+		files[item.Name] = item
+	}
+
+	t.Run("entries", func(t *testing.T) {
+		for _, item := range []string{"AndroidManifest.xml", "lib/arm64-v8a/libsnake.so",
+			"lib/x86/libsnake.so", "META-INF/MANIFEST.MF", "META-INF/CERT.SF", "META-INF/CERT.RSA"} {
+			if files[item] == nil {
+				t.Errorf("'%s' is not in APK", item)
+			}
+		}
+
+		lib := files["lib/x86/libsnake.so"]
+		if offset, _ := lib.DataOffset(); offset%alignLibs != 0 {
+			t.Errorf("Library is not aligned: %d", offset)
+		}
+	})
+
+	t.Run("v1 signature", func(t *testing.T) {
+		read := func(name string) []byte {
+			rc, _ := files[name].Open()
+			defer rc.Close()
+			b, _ := io.ReadAll(rc)
+			return b
+		}
+
+		var info contentInfo
+		if _, err := asn1.Unmarshal(read("META-INF/CERT.RSA"), &info); err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		sum := sha256.Sum256(read("META-INF/CERT.SF"))
+		signature := info.Content.SignerInfos[0].EncryptedDigest
+		if err := rsa.VerifyPKCS1v15(&key.Private.PublicKey, crypto.SHA256, sum[:], signature); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("v2 signature", func(t *testing.T) {
+		eocd := data[len(data)-22:]
+		cdOffset := int(binary.LittleEndian.Uint32(eocd[16:]))
+		magic := string(data[cdOffset-16 : cdOffset])
+		if magic != signingBlockMagic {
+			t.Errorf("Expect signing block before central directory, got '%s'", magic)
+			t.FailNow()
+		}
+
+		size := int(binary.LittleEndian.Uint64(data[cdOffset-24:]))
+		start := cdOffset - size - 8
+
+		// Digest is counted as if there was no signing block
+		z := &zipSections{
+			Entries:    data[:start],
+			CentralDir: data[cdOffset : len(data)-22],
+			Eocd:       slices.Clone(eocd),
+		}
+		setCdOffset(z.Eocd, start)
+
+		// Pair length, ID, then lengths of signers, signer, signed data,
+		// digests, digest, algorithm and length of digest itself
+		value := data[start+8+8+4:]
+		if !bytes.Equal(value[28:28+sha256.Size], v2Digest(z)) {
+			t.Error("Wrong v2 digest")
+		}
+	})
+
+	t.Run("same key", func(t *testing.T) {
+		again, err := LoadDebugKey(path.Join(dir, "debug.pem"))
+		if err != nil {
+			t.Error(err)
+		}
+
+		if !again.Cert.Equal(key.Cert) {
+			t.Error("Expect the same certificate after reading the key")
+		}
+	})
+}
@@ -0,0 +1,194 @@
+package android
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path"
+	"raypm/internal/errs"
+	log "raypm/pkg/slog"
+	"strings"
+)
+
+// Zip is written by hand instead of archive/zip, because APK needs exact
+// control over offsets: stored entries are aligned like zipalign does and
+// v2 signature is inserted before central directory
+const (
+	alignDefault = 4
+	alignLibs    = 4096 // Shared libraries could be mapped directly from APK
+
+	zipStore   = 0
+	zipDeflate = 8
+
+	// 1981-01-01 00:00, so the same input gives the same APK
+	dosDate = 1<<9 | 1<<5 | 1
+	dosTime = 0
+)
+
+type apkEntry struct {
+	Name   string
+	Data   []byte
+	Method uint16
+}
+
+type zipRecord struct {
+	apkEntry
+	Crc        uint32
+	Compressed []byte
+	Offset     uint32
+}
+
+// Bytes of zip split the way v2 signing needs them
+type zipSections struct {
+	Entries    []byte // Local headers with data
+	CentralDir []byte
+	Eocd       []byte
+}
+
+func writeZip(entries []apkEntry) (z *zipSections, err error) {
+	var (
+		body    bytes.Buffer
+		records []zipRecord
+	)
+
+	for _, item := range entries {
+		rec := zipRecord{apkEntry: item, Crc: crc32.ChecksumIEEE(item.Data)}
+
+		if item.Method == zipDeflate {
+			var buf bytes.Buffer
+			fw, _ := flate.NewWriter(&buf, flate.BestCompression)
+			fw.Write(item.Data)
+			fw.Close()
+			rec.Compressed = buf.Bytes()
+		} else {
+			rec.Compressed = item.Data
+		}
+
+		// Pads extra field, so data of stored entry starts at aligned offset
+		var extra []byte
+		if item.Method == zipStore {
+			align := alignDefault
+			if strings.HasSuffix(item.Name, ".so") {
+				align = alignLibs
+			}
+
+			dataStart := body.Len() + 30 + len(item.Name)
+			extra = make([]byte, (align-dataStart%align)%align)
+		}
+
+		rec.Offset = uint32(body.Len())
+		put32(&body, 0x04034b50)
+		put16(&body, 20, 0, item.Method, dosTime, dosDate)
+		put32(&body, rec.Crc, uint32(len(rec.Compressed)), uint32(len(item.Data)))
+		put16(&body, uint16(len(item.Name)), uint16(len(extra)))
+		body.WriteString(item.Name)
+		body.Write(extra)
+		body.Write(rec.Compressed)
+
+		records = append(records, rec)
+	}
+
+	if body.Len() > 0xffffffff || len(records) > 0xffff {
+		err = errs.New(errs.Phase, "ApkIsTooBig", "%d bytes in %d files", body.Len(), len(records))
+		return
+	}
+
+	var cd bytes.Buffer
+	for _, item := range records {
+		put32(&cd, 0x02014b50)
+		put16(&cd, 20, 20, 0, item.Method, dosTime, dosDate)
+		put32(&cd, item.Crc, uint32(len(item.Compressed)), uint32(len(item.Data)))
+		put16(&cd, uint16(len(item.Name)), 0, 0, 0, 0)
+		put32(&cd, 0, item.Offset)
+		cd.WriteString(item.Name)
+	}
+
+	z = &zipSections{Entries: body.Bytes(), CentralDir: cd.Bytes()}
+	z.Eocd = eocd(len(records), len(z.CentralDir), len(z.Entries))
+	return
+}
+
+func eocd(count, cdSize, cdOffset int) []byte {
+	var w bytes.Buffer
+	put32(&w, 0x06054b50)
+	put16(&w, 0, 0, uint16(count), uint16(count))
+	put32(&w, uint32(cdSize), uint32(cdOffset))
+	put16(&w, 0)
+	return w.Bytes()
+}
+
+// Offset of central directory is the only field changed by v2 signing
+func setCdOffset(eocd []byte, offset int) {
+	binary.LittleEndian.PutUint32(eocd[16:], uint32(offset))
+}
+
+// Collects 'lib/<abi>/*.so' from 'out'. Library loaded by NativeActivity must
+// be there for every ABI
+func (t *Target) nativeLibs(out string) (entries []apkEntry, err error) {
+	for _, abi := range t.ABIs {
+		dir := path.Join(out, "lib", abi.Name)
+		main := "lib" + t.LibName + ".so"
+
+		if _, err = os.Stat(path.Join(dir, main)); err != nil {
+			err = errs.Wrap(errs.Phase, "NativeLibNotFound", err, "build phase didn't produce '%s'", path.Join(dir, main))
+			return
+		}
+
+		var files []os.DirEntry
+		if files, err = os.ReadDir(dir); err != nil {
+			return
+		}
+
+		for _, item := range files {
+			if item.IsDir() || !strings.HasSuffix(item.Name(), ".so") {
+				continue
+			}
+
+			var data []byte
+			if data, err = os.ReadFile(path.Join(dir, item.Name())); err != nil {
+				return
+			}
+
+			entries = append(entries, apkEntry{
+				Name:   "lib/" + abi.Name + "/" + item.Name(),
+				Data:   data,
+				Method: zipStore,
+			})
+		}
+	}
+
+	return
+}
+
+// Packs native libraries from '$out/lib/<abi>' to '$out/<name>.apk' and
+// signs it with the key
+func (t *Target) BuildAPK(out string, key *Key) (apkPath string, err error) {
+	entries := []apkEntry{{
+		Name:   "AndroidManifest.xml",
+		Data:   encodeXml(t.manifest()),
+		Method: zipDeflate,
+	}}
+
+	libs, err := t.nativeLibs(out)
+	if err != nil {
+		return
+	}
+	entries = append(entries, libs...)
+
+	data, err := sign(entries, key)
+	if err != nil {
+		err = errs.Wrap(errs.Phase, "CannotSignApk", err, "")
+		return
+	}
+
+	apkPath = path.Join(out, t.Name+".apk")
+	if err = os.WriteFile(apkPath, data, 0644); err != nil {
+		err = errs.Wrap(errs.Phase, "CannotWriteApk", err, "'%s'", apkPath)
+		return
+	}
+
+	log.Info("APK: %s", apkPath)
+	return
+}
@@ -0,0 +1,286 @@
+package android
+
+import (
+	"bytes"
+	"encoding/binary"
+	"unicode/utf16"
+)
+
+// Chunk types of binary XML, see ResourceTypes.h in AOSP
+const (
+	chunkStringPool = 0x0001
+	chunkXml        = 0x0003
+	chunkNsStart    = 0x0100
+	chunkNsEnd      = 0x0101
+	chunkElemStart  = 0x0102
+	chunkElemEnd    = 0x0103
+	chunkResMap     = 0x0180
+
+	typeString  = 0x03
+	typeIntDec  = 0x10
+	typeIntHex  = 0x11
+	typeBoolean = 0x12
+
+	noIndex = 0xffffffff
+)
+
+const (
+	androidNs     = "http://schemas.android.com/apk/res/android"
+	androidPrefix = "android"
+)
+
+// IDs of attributes from android.R.attr
+var attrIDs = map[string]uint32{
+	"label":            0x01010001,
+	"icon":             0x01010002,
+	"name":             0x01010003,
+	"hasCode":          0x0101000c,
+	"debuggable":       0x0101000f,
+	"exported":         0x01010010,
+	"configChanges":    0x0101001f,
+	"value":            0x01010024,
+	"minSdkVersion":    0x0101020c,
+	"versionCode":      0x0101021b,
+	"versionName":      0x0101021c,
+	"targetSdkVersion": 0x01010270,
+	"glEsVersion":      0x01010281,
+	"required":         0x0101028e,
+}
+
+type xmlAttr struct {
+	Name    string
+	Android bool // In android namespace and has resource ID
+	Type    uint8
+	Data    uint32
+	Str     string // Value for typeString
+}
+
+type xmlElem struct {
+	Name     string
+	Attrs    []xmlAttr
+	Children []*xmlElem
+}
+
+func strAttr(name, value string) xmlAttr {
+	return xmlAttr{Name: name, Android: true, Type: typeString, Str: value}
+}
+
+func intAttr(name string, value int) xmlAttr {
+	return xmlAttr{Name: name, Android: true, Type: typeIntDec, Data: uint32(value)}
+}
+
+func boolAttr(name string, value bool) xmlAttr {
+	attr := xmlAttr{Name: name, Android: true, Type: typeBoolean}
+	if value {
+		attr.Data = 0xffffffff
+	}
+	return attr
+}
+
+// AndroidManifest.xml for NativeActivity without Java code
+func (t *Target) manifest() *xmlElem {
+	label := t.Name
+
+	return &xmlElem{
+		Name: "manifest",
+		Attrs: []xmlAttr{
+			{Name: "package", Type: typeString, Str: t.AppID},
+			intAttr("versionCode", 1),
+			strAttr("versionName", t.Version),
+		},
+		Children: []*xmlElem{
+			{Name: "uses-sdk", Attrs: []xmlAttr{
+				intAttr("minSdkVersion", t.APILevel),
+				intAttr("targetSdkVersion", t.APILevel),
+			}},
+			{Name: "uses-feature", Attrs: []xmlAttr{
+				{Name: "glEsVersion", Android: true, Type: typeIntHex, Data: 0x00020000},
+				boolAttr("required", true),
+			}},
+			{Name: "application", Attrs: []xmlAttr{
+				strAttr("label", label),
+				boolAttr("hasCode", false),
+				boolAttr("debuggable", true),
+			}, Children: []*xmlElem{
+				{Name: "activity", Attrs: []xmlAttr{
+					strAttr("label", label),
+					strAttr("name", "android.app.NativeActivity"),
+					boolAttr("exported", true),
+					// orientation|keyboardHidden|screenSize: raylib handles them itself
+					{Name: "configChanges", Android: true, Type: typeIntHex, Data: 0x4a0},
+				}, Children: []*xmlElem{
+					{Name: "meta-data", Attrs: []xmlAttr{
+						strAttr("name", "android.app.lib_name"),
+						strAttr("value", t.LibName),
+					}},
+					{Name: "intent-filter", Children: []*xmlElem{
+						{Name: "action", Attrs: []xmlAttr{
+							strAttr("name", "android.intent.action.MAIN"),
+						}},
+						{Name: "category", Attrs: []xmlAttr{
+							strAttr("name", "android.intent.category.LAUNCHER"),
+						}},
+					}},
+				}},
+			}},
+		},
+	}
+}
+
+// Encodes the element to binary XML, which is the only form of manifest,
+// that Android reads from APK
+func encodeXml(root *xmlElem) []byte {
+	// Names of attributes with resource IDs must go first in string pool,
+	// because resource map is indexed by string index
+	var (
+		pool    []string
+		indexes = make(map[string]uint32)
+		resIDs  []uint32
+	)
+
+	add := func(s string) uint32 {
+		if i, ok := indexes[s]; ok {
+			return i
+		}
+		indexes[s] = uint32(len(pool))
+		pool = append(pool, s)
+		return indexes[s]
+	}
+
+	var walk func(e *xmlElem, f func(e *xmlElem))
+	walk = func(e *xmlElem, f func(e *xmlElem)) {
+		f(e)
+		for _, item := range e.Children {
+			walk(item, f)
+		}
+	}
+
+	walk(root, func(e *xmlElem) {
+		for _, item := range e.Attrs {
+			if _, ok := indexes[item.Name]; item.Android && !ok {
+				add(item.Name)
+				resIDs = append(resIDs, attrIDs[item.Name])
+			}
+		}
+	})
+
+	nsPrefix, nsUri := add(androidPrefix), add(androidNs)
+	walk(root, func(e *xmlElem) {
+		add(e.Name)
+		for _, item := range e.Attrs {
+			add(item.Name)
+			if item.Type == typeString {
+				add(item.Str)
+			}
+		}
+	})
+
+	var body bytes.Buffer
+	body.Write(stringPool(pool))
+
+	resMap := chunk(chunkResMap, 8, func(w *bytes.Buffer) {
+		for _, item := range resIDs {
+			put32(w, item)
+		}
+	})
+	body.Write(resMap)
+
+	body.Write(chunk(chunkNsStart, 16, func(w *bytes.Buffer) {
+		put32(w, 1, noIndex, nsPrefix, nsUri)
+	}))
+
+	var encode func(e *xmlElem)
+	encode = func(e *xmlElem) {
+		body.Write(chunk(chunkElemStart, 16, func(w *bytes.Buffer) {
+			put32(w, 1, noIndex, noIndex, indexes[e.Name])
+			put16(w, 20, 20, uint16(len(e.Attrs)), 0, 0, 0)
+
+			for _, item := range e.Attrs {
+				ns, raw, data := uint32(noIndex), uint32(noIndex), item.Data
+				if item.Android {
+					ns = nsUri
+				}
+
+				if item.Type == typeString {
+					raw = indexes[item.Str]
+					data = raw
+				}
+
+				put32(w, ns, indexes[item.Name], raw)
+				put16(w, 8)
+				w.WriteByte(0)
+				w.WriteByte(item.Type)
+				put32(w, data)
+			}
+		}))
+
+		for _, item := range e.Children {
+			encode(item)
+		}
+
+		body.Write(chunk(chunkElemEnd, 16, func(w *bytes.Buffer) {
+			put32(w, 1, noIndex, noIndex, indexes[e.Name])
+		}))
+	}
+	encode(root)
+
+	body.Write(chunk(chunkNsEnd, 16, func(w *bytes.Buffer) {
+		put32(w, 1, noIndex, nsPrefix, nsUri)
+	}))
+
+	return chunk(chunkXml, 8, func(w *bytes.Buffer) {
+		w.Write(body.Bytes())
+	})
+}
+
+// UTF-16 string pool without styles
+func stringPool(pool []string) []byte {
+	var data bytes.Buffer
+	offsets := make([]uint32, 0, len(pool))
+
+	for _, item := range pool {
+		offsets = append(offsets, uint32(data.Len()))
+		units := utf16.Encode([]rune(item))
+		put16(&data, uint16(len(units)))
+		put16(&data, units...)
+		put16(&data, 0)
+	}
+
+	for data.Len()%4 != 0 {
+		data.WriteByte(0)
+	}
+
+	const headerSize = 28
+	stringsStart := uint32(headerSize + 4*len(pool))
+
+	return chunk(chunkStringPool, headerSize, func(w *bytes.Buffer) {
+		put32(w, uint32(len(pool)), 0, 0, stringsStart, 0)
+		put32(w, offsets...)
+		w.Write(data.Bytes())
+	})
+}
+
+// Writes chunk header (type, header size, full size) before the content.
+// Content includes the rest of header
+func chunk(typ uint16, headerSize uint16, content func(w *bytes.Buffer)) []byte {
+	var w bytes.Buffer
+	content(&w)
+
+	out := make([]byte, 8, 8+w.Len())
+	binary.LittleEndian.PutUint16(out[0:], typ)
+	binary.LittleEndian.PutUint16(out[2:], headerSize)
+	binary.LittleEndian.PutUint32(out[4:], uint32(8+w.Len()))
+	return append(out, w.Bytes()...)
+}
+
+func put16(w *bytes.Buffer, values ...uint16) {
+	for _, item := range values {
+		w.Write(binary.LittleEndian.AppendUint16(nil, item))
+	}
+}
+
+func put32(w *bytes.Buffer, values ...uint32) {
+	for _, item := range values {
+		w.Write(binary.LittleEndian.AppendUint32(nil, item))
+	}
+}
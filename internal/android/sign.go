@@ -0,0 +1,355 @@
+package android
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path"
+	"raypm/internal/errs"
+	log "raypm/pkg/slog"
+	"slices"
+	"time"
+)
+
+// Debug key, like '~/.android/debug.keystore', but in PEM, because Go can't
+// read Java keystores
+type Key struct {
+	Private *rsa.PrivateKey
+	Cert    *x509.Certificate
+}
+
+func DebugKeyPath() (pth string, err error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return
+	}
+
+	pth = path.Join(home, ".android", "raypm_debug.pem")
+	return
+}
+
+// Reads the key from 'pth', or generates a new one there. APKs signed with
+// different keys can't update each other, so the key is kept between builds
+func LoadDebugKey(pth string) (key *Key, err error) {
+	data, err := os.ReadFile(pth)
+	if os.IsNotExist(err) {
+		log.Info("Generating debug key '%s'", pth)
+		return newDebugKey(pth)
+	} else if err != nil {
+		err = errs.Wrap(errs.Phase, "CannotReadDebugKey", err, "'%s'", pth)
+		return
+	}
+
+	key = &Key{}
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		switch block.Type {
+		case "RSA PRIVATE KEY":
+			key.Private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "CERTIFICATE":
+			key.Cert, err = x509.ParseCertificate(block.Bytes)
+		}
+
+		if err != nil {
+			err = errs.Wrap(errs.Phase, "WrongDebugKey", err, "'%s'", pth)
+			return
+		}
+	}
+
+	if key.Private == nil || key.Cert == nil {
+		err = errs.New(errs.Phase, "WrongDebugKey", "'%s' must have RSA key and certificate", pth)
+	}
+
+	return
+}
+
+func newDebugKey(pth string) (key *Key, err error) {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return
+	}
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		return
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:   "Android Debug",
+			Organization: []string{"Android"},
+			Country:      []string{"US"},
+		},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter:  time.Now().AddDate(30, 0, 0),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &private.PublicKey, private)
+	if err != nil {
+		return
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return
+	}
+
+	var buf bytes.Buffer
+	pem.Encode(&buf, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(private)})
+	pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	if err = os.MkdirAll(path.Dir(pth), 0700); err != nil {
+		return
+	}
+
+	if err = os.WriteFile(pth, buf.Bytes(), 0600); err != nil {
+		return
+	}
+
+	key = &Key{Private: private, Cert: cert}
+	return
+}
+
+// Signs with v1 (JAR) scheme for old tools and v2 scheme, which is required
+// since Android 11 for apps targeting it
+func sign(entries []apkEntry, key *Key) (data []byte, err error) {
+	mf, sf := jarManifests(entries)
+
+	rsaBlock, err := pkcs7(sf, key)
+	if err != nil {
+		return
+	}
+
+	entries = append(slices.Clone(entries),
+		apkEntry{Name: "META-INF/MANIFEST.MF", Data: mf, Method: zipDeflate},
+		apkEntry{Name: "META-INF/CERT.SF", Data: sf, Method: zipDeflate},
+		apkEntry{Name: "META-INF/CERT.RSA", Data: rsaBlock, Method: zipDeflate},
+	)
+
+	z, err := writeZip(entries)
+	if err != nil {
+		return
+	}
+
+	block, err := v2Block(z, key)
+	if err != nil {
+		return
+	}
+
+	setCdOffset(z.Eocd, len(z.Entries)+len(block))
+	data = slices.Concat(z.Entries, block, z.CentralDir, z.Eocd)
+	return
+}
+
+// MANIFEST.MF with digests of entries and CERT.SF with digests of its sections
+func jarManifests(entries []apkEntry) (mf, sf []byte) {
+	var mfBuf, sfBuf bytes.Buffer
+	mfBuf.WriteString("Manifest-Version: 1.0\r\nCreated-By: raypm\r\n\r\n")
+
+	var sections [][]byte
+	for _, item := range entries {
+		var section bytes.Buffer
+		writeHeader(&section, "Name", item.Name)
+		writeHeader(&section, "SHA-256-Digest", digest(item.Data))
+		section.WriteString("\r\n")
+
+		sections = append(sections, section.Bytes())
+		mfBuf.Write(section.Bytes())
+	}
+	mf = mfBuf.Bytes()
+
+	sfBuf.WriteString("Signature-Version: 1.0\r\nCreated-By: raypm\r\n")
+	writeHeader(&sfBuf, "SHA-256-Digest-Manifest", digest(mf))
+	// Tells verifiers, that stripping v2 signature is an attack
+	sfBuf.WriteString("X-Android-APK-Signed: 2\r\n\r\n")
+
+	for i, item := range entries {
+		writeHeader(&sfBuf, "Name", item.Name)
+		writeHeader(&sfBuf, "SHA-256-Digest", digest(sections[i]))
+		sfBuf.WriteString("\r\n")
+	}
+	sf = sfBuf.Bytes()
+
+	return
+}
+
+// Lines of JAR manifest are limited to 72 bytes, the rest goes to
+// continuation lines starting with space
+func writeHeader(w *bytes.Buffer, name, value string) {
+	line := name + ": " + value
+	limit := 72
+
+	for len(line) > limit {
+		w.WriteString(line[:limit] + "\r\n ")
+		line = line[limit:]
+		limit = 71
+	}
+	w.WriteString(line + "\r\n")
+}
+
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidSHA256        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidRSAEncryption = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+)
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     signedData `asn1:"explicit,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      struct{ ContentType asn1.ObjectIdentifier }
+	Certificates     asn1.RawValue
+	SignerInfos      []signerInfo `asn1:"set"`
+}
+
+type signerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     issuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+}
+
+type issuerAndSerial struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+// Detached PKCS#7 signature of CERT.SF (CERT.RSA)
+func pkcs7(sf []byte, key *Key) (der []byte, err error) {
+	sum := sha256.Sum256(sf)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key.Private, crypto.SHA256, sum[:])
+	if err != nil {
+		return
+	}
+
+	sha256Alg := pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}
+	info := contentInfo{
+		ContentType: oidSignedData,
+		Content: signedData{
+			Version:          1,
+			DigestAlgorithms: []pkix.AlgorithmIdentifier{sha256Alg},
+			Certificates: asn1.RawValue{
+				Class:      asn1.ClassContextSpecific,
+				Tag:        0,
+				IsCompound: true,
+				Bytes:      key.Cert.Raw,
+			},
+			SignerInfos: []signerInfo{{
+				Version: 1,
+				IssuerAndSerialNumber: issuerAndSerial{
+					Issuer:       asn1.RawValue{FullBytes: key.Cert.RawIssuer},
+					SerialNumber: key.Cert.SerialNumber,
+				},
+				DigestAlgorithm: sha256Alg,
+				DigestEncryptionAlgorithm: pkix.AlgorithmIdentifier{
+					Algorithm:  oidRSAEncryption,
+					Parameters: asn1.NullRawValue,
+				},
+				EncryptedDigest: signature,
+			}},
+		},
+	}
+	info.Content.ContentInfo.ContentType = oidData
+
+	return asn1.Marshal(info)
+}
+
+// APK Signature Scheme v2, see source.android.com/docs/security/features/apksigning/v2
+const (
+	v2BlockID         = 0x7109871a
+	rsaPkcs1SHA256    = 0x0103
+	v2ChunkSize       = 1 << 20
+	signingBlockMagic = "APK Sig Block 42"
+)
+
+// Digest of zip contents: every section is split to 1MB chunks, digests of
+// chunks are digested again
+func v2Digest(z *zipSections) []byte {
+	var chunks [][]byte
+	for _, section := range [][]byte{z.Entries, z.CentralDir, z.Eocd} {
+		for i := 0; i < len(section); i += v2ChunkSize {
+			chunks = append(chunks, section[i:min(i+v2ChunkSize, len(section))])
+		}
+	}
+
+	top := sha256.New()
+	top.Write([]byte{0x5a})
+	top.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(chunks))))
+
+	for _, item := range chunks {
+		h := sha256.New()
+		h.Write([]byte{0xa5})
+		h.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(item))))
+		h.Write(item)
+		top.Write(h.Sum(nil))
+	}
+
+	return top.Sum(nil)
+}
+
+// APK Signing Block, which goes between entries and central directory
+func v2Block(z *zipSections, key *Key) (block []byte, err error) {
+	signed := slices.Concat(
+		prefixed(prefixed(slices.Concat(
+			binary.LittleEndian.AppendUint32(nil, rsaPkcs1SHA256),
+			prefixed(v2Digest(z)),
+		))),
+		prefixed(prefixed(key.Cert.Raw)),
+		prefixed(nil), // Additional attributes
+	)
+
+	sum := sha256.Sum256(signed)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key.Private, crypto.SHA256, sum[:])
+	if err != nil {
+		return
+	}
+
+	public, err := x509.MarshalPKIXPublicKey(&key.Private.PublicKey)
+	if err != nil {
+		return
+	}
+
+	signer := slices.Concat(
+		prefixed(signed),
+		prefixed(prefixed(slices.Concat(
+			binary.LittleEndian.AppendUint32(nil, rsaPkcs1SHA256),
+			prefixed(signature),
+		))),
+		prefixed(public),
+	)
+	value := prefixed(prefixed(signer))
+
+	pair := slices.Concat(binary.LittleEndian.AppendUint32(nil, v2BlockID), value)
+	pairs := slices.Concat(binary.LittleEndian.AppendUint64(nil, uint64(len(pair))), pair)
+
+	// Size doesn't count the first size field itself
+	size := binary.LittleEndian.AppendUint64(nil, uint64(len(pairs)+8+len(signingBlockMagic)))
+	block = slices.Concat(size, pairs, size, []byte(signingBlockMagic))
+	return
+}
+
+// Value with uint32 length before it
+func prefixed(data []byte) []byte {
+	return append(binary.LittleEndian.AppendUint32(nil, uint32(len(data))), data...)
+}
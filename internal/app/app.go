@@ -6,8 +6,13 @@ import (
 	"io/fs"
 	"os"
 	"path"
+	"path/filepath"
+	"raypm/internal/errs"
+	"raypm/internal/triple"
+	"raypm/internal/web"
 	log "raypm/pkg/slog"
-	"runtime"
+	"slices"
+	"strings"
 )
 
 type Operation uint8
@@ -20,14 +25,44 @@ const (
 	InstallPkg
 	RemovePkg
 	BuildPkg
+	Rollback
+	ListGenerations
+	PrintEnv
+	SpawnShell
+	ExecCmd
+	PkgConfig
+	Serve
+	ShowLog
+	Lint
+	CollectGarbage
 )
 
+// Commands are written before flags: 'raypm <command> [flags] [args]'
+var commands = []struct {
+	Name      string
+	Operation Operation
+	Usage     string
+}{
+	{"env", PrintEnv, "Print environment of installed package and its dependencies: 'env [package]'"},
+	{"exec", ExecCmd, "Run a command in environment of the package: 'exec [package] -- <command>'"},
+	{"gc", CollectGarbage, "Remove generations except current one and packages, that are not installed and not used by it"},
+	{"generations", ListGenerations, "List generations of installed packages"},
+	{"lint", Lint, "Check package definitions: 'lint [package.lua or directory...]', package.lua in current directory by default"},
+	{"log", ShowLog, "Print logs of package's phases: 'log [package] [phase]', package.lua in current directory by default"},
+	{"pkg-config", PkgConfig, "Print flags of installed packages: 'pkg-config [--target=<os>] --cflags --libs <packages>'"},
+	{"rollback", Rollback, "Switch to previous generation, or to the given one: 'rollback <number>'"},
+	{"serve", Serve, "Serve build of web target over HTTP: 'serve [-addr host:port] [directory]'"},
+	{"shell", SpawnShell, "Start a shell with environment of the package: 'shell [package]'"},
+}
+
 type Settings struct {
-	RaypmPath  string
-	PathToPkgs string
-	LockPath   string
-	DbJson     string
-	Build      Build
+	RaypmPath       string
+	PathToPkgs      string
+	LockPath        string
+	DbJson          string
+	GenerationsPath string
+	LogsPath        string
+	Build           Build
 }
 
 type Build struct {
@@ -48,41 +83,207 @@ type Options struct {
 	PackageTarget string
 	OutputPath    string
 	CustomPkgs    string
+	Shell         string
+	Addr          string
+	Jobs          int
+	Report        string
+	Color         string
+	LogFormat     string
+
+	Command  string
+	Args     []string // Arguments after flags
+	ExecArgs []string // Command for 'exec', it's after '--' or it's 'Args'
 }
 
 func NewOptions() (o *Options, err error) {
+	flag.Usage = usage
+	return newOptions(flag.CommandLine, os.Args)
+}
+
+// Registers flags in 'fs' and parses 'argv' (program's name goes first)
+func newOptions(fs *flag.FlagSet, argv []string) (o *Options, err error) {
 	o = &Options{}
 
-	flag.BoolVar(&o.ListPkgs, "list", false, "List all available packages")
-	flag.BoolVar(&o.Debug, "d", false, "Print debug logs")
-	flag.BoolVar(&o.SyncPkgs, "sync", false, "Get latest package's database")
-	flag.BoolVar(&o.BuildPackage, "build", false, "Build a package")
-	flag.StringVar(&o.FetchPkgInfo, "info", "", "Show information about package")
-	flag.StringVar(&o.InstallPkg, "install", "", "Install a package")
-	flag.StringVar(&o.RemovePkg, "remove", "", "Remove a package")
-	flag.StringVar(&o.PackageTarget, "target", "", "Set target OS")
-	flag.StringVar(&o.OutputPath, "o", "", "Set custom output path(for -build and -install)")
-	flag.StringVar(&o.CustomPkgs, "pkgs", "", "Set custom pkgs path")
-	flag.StringVar(&o.CleanStorage,
+	fs.BoolVar(&o.ListPkgs, "list", false, "List all available packages")
+	fs.BoolVar(&o.Debug, "d", false, "Print debug logs")
+	fs.BoolVar(&o.SyncPkgs, "sync", false, "Get latest package's database")
+	fs.BoolVar(&o.BuildPackage, "build", false, "Build a package")
+	fs.StringVar(&o.FetchPkgInfo, "info", "", "Show information about package")
+	fs.StringVar(&o.InstallPkg, "install", "", "Install a package")
+	fs.StringVar(&o.RemovePkg, "remove", "", "Remove a package")
+	fs.StringVar(&o.PackageTarget, "target", "", "Set target: os[/arch[/abi]], like windows/386 or linux/arm64/musl")
+	fs.StringVar(&o.OutputPath, "o", "", "Set custom output path(for -build and -install)")
+	fs.StringVar(&o.CustomPkgs, "pkgs", "", "Set custom pkgs path")
+	fs.StringVar(&o.Shell, "shell", "", "Shell syntax for 'env': sh, fish, powershell")
+	fs.StringVar(&o.Addr, "addr", web.DefaultAddr, "Address for 'serve'")
+	fs.IntVar(&o.Jobs, "j", 0, "Number of parallel jobs (default: number of CPUs)")
+	fs.StringVar(&o.Report, "report", "terminal", "Report of installation: terminal, json, silent")
+	fs.StringVar(&o.Color, "color", "auto", "Colored output: auto, always, never (auto respects NO_COLOR)")
+	fs.StringVar(&o.LogFormat, "log-format", "text", "Format of log messages: text, json")
+	fs.StringVar(&o.CleanStorage,
 		"clean",
 		"",
 		"Cleaning raypm's storage. Available options: 'cache', 'all'",
 	)
-	flag.Parse()
 
-	if flag.NFlag() == 0 {
-		log.Warnln("There's nothing to do. Type 'raypm -h'")
-		err = fmt.Errorf("NoOperations")
+	args := argv[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		o.Command = args[0]
+		args = args[1:]
+	}
+
+	// raypm could be symlinked as 'pkg-config' to use it in PKG_CONFIG
+	if strings.TrimSuffix(filepath.Base(argv[0]), ".exe") == "pkg-config" {
+		o.Command = "pkg-config"
+		args = argv[1:]
+	}
+
+	// pkg-config has own arguments, like '--cflags'
+	if o.Command == "pkg-config" {
+		o.Args = args
+		return
+	}
+
+	// Command of 'exec' has own flags and could have '--' too, so it's cut
+	// before flags are parsed: flag package drops the first '--'
+	if o.Command == "exec" {
+		if i := slices.Index(args, "--"); i >= 0 {
+			o.ExecArgs = args[i+1:]
+			args = args[:i]
+		}
 	}
 
-	if _, err = os.Stat(o.CustomPkgs); err != nil {
+	if err = fs.Parse(args); err != nil {
+		err = errs.Wrap(errs.Usage, "WrongFlag", err, "")
+		return
+	}
+	o.Args = fs.Args()
 
+	if fs.NFlag() == 0 && o.Command == "" {
+		err = errs.New(errs.Usage, "NoOperations", "there's nothing to do, type 'raypm -h'")
+		return
+	}
+
+	if o.CustomPkgs != "" {
+		if _, err = os.Stat(o.CustomPkgs); err != nil {
+			err = errs.Wrap(errs.Usage, "WrongPkgsPath", err, "custom pkgs path '%s' does not exist", o.CustomPkgs)
+		}
 	}
 
 	return
 }
 
+func usage() {
+	out := flag.CommandLine.Output()
+
+	fmt.Fprintf(out, "Usage: raypm [command] [flags] [args]\n\nCommands:\n")
+	for _, item := range commands {
+		fmt.Fprintf(out, "  %s\n    \t%s\n", item.Name, item.Usage)
+	}
+
+	fmt.Fprintf(out, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
 func (o *Options) SetProgramTask() (programTask Operation, selectedPackage string, err error) {
+	operations := 0
+
+	if o.Command != "" {
+		found := false
+		for _, item := range commands {
+			if item.Name == o.Command {
+				programTask = item.Operation
+				found = true
+				operations++
+				break
+			}
+		}
+
+		if !found {
+			err = errs.New(errs.Usage, "UnknownCommand", "'%s', type 'raypm -h' to see them", o.Command)
+			return
+		}
+	}
+
+	if o.ListPkgs {
+		programTask = ListPackages
+		operations++
+	}
+
+	if o.FetchPkgInfo != "" {
+		programTask = FetchPkgInfo
+		selectedPackage = o.FetchPkgInfo
+		operations++
+	}
+
+	if o.InstallPkg != "" {
+		programTask = InstallPkg
+		selectedPackage = o.InstallPkg
+		operations++
+	}
+
+	if o.SyncPkgs {
+		programTask = SyncPkgs
+		operations++
+	}
+
+	if o.RemovePkg != "" {
+		programTask = RemovePkg
+		selectedPackage = o.RemovePkg
+		operations++
+	}
+
+	if o.CleanStorage != "" {
+		programTask = Clean
+		operations++
+	}
+
+	if o.BuildPackage {
+		programTask = BuildPkg
+		operations++
+	}
+
+	// Without package name these commands use package.lua in current
+	// directory
+	if programTask == PrintEnv || programTask == SpawnShell || programTask == ShowLog {
+		if len(o.Args) > 0 {
+			selectedPackage = o.Args[0]
+		}
+	}
+
+	// Without '--' all arguments are the command
+	if programTask == ExecCmd {
+		if o.ExecArgs == nil {
+			o.ExecArgs = o.Args
+		} else if len(o.Args) > 1 {
+			err = errs.New(errs.Usage, "WrongArguments", "usage: raypm exec [flags] [package] -- <command>")
+			return
+		} else if len(o.Args) == 1 {
+			selectedPackage = o.Args[0]
+		}
+
+		if len(o.ExecArgs) == 0 {
+			err = errs.New(errs.Usage, "NoCommand", "usage: raypm exec [flags] [package] -- <command>")
+			return
+		}
+	}
+
+	if operations > 1 {
+		err = errs.New(errs.Usage, "TooManyOperations", "choose only one, type 'raypm -h' to see them")
+		return
+	}
+
+	if o.OutputPath != "" {
+		if programTask != InstallPkg && programTask != BuildPkg {
+			err = errs.New(errs.Usage, "OutputPathIsNotAllowed", "'-o' can be used only with '-install' or '-build'")
+			return
+		}
+
+		if o.OutputPath, err = filepath.Abs(o.OutputPath); err != nil {
+			err = errs.Wrap(errs.Usage, "WrongOutputPath", err, "'%s'", o.OutputPath)
+			return
+		}
+	}
 
 	return
 }
@@ -93,26 +294,28 @@ func InitApp(opts *Options, raypmPath, target string) (*Settings, error) {
 		PathToPkgs: path.Join(raypmPath, "pkgs"),
 		LockPath:   path.Join(raypmPath, "lock"),
 		DbJson:     path.Join(raypmPath, "db.json"),
+
+		GenerationsPath: path.Join(raypmPath, "generations"),
+		LogsPath:        path.Join(raypmPath, "logs"),
 	}
 
 	if opts.CustomPkgs != "" {
 		app.PathToPkgs = opts.CustomPkgs
 	}
 
-	if target == "linux" || target == "windows" || target == "android" {
-		app.Build = Build{
-			Target: target,
+	host := triple.Host()
+	t := host
+	if target != "" {
+		var err error
+		if t, err = triple.Parse(target); err != nil {
+			return nil, errs.Wrap(errs.Usage, "UndefinedSystem", err, "'%s'", target)
 		}
+	}
 
-		app.Build.Host = runtime.GOOS
-		app.Build.Cross = app.Build.Target != app.Build.Host
-	} else if target == "" {
-		app.Build.Target = runtime.GOOS
-		app.Build.Host = runtime.GOOS
-		app.Build.Cross = false
-	} else {
-		log.Error("Undefined system '%s'", target)
-		return nil, fmt.Errorf("UndefinedSystem")
+	app.Build = Build{
+		Target: t.String(),
+		Host:   host.String(),
+		Cross:  t != host,
 	}
 
 	return app, nil
@@ -121,7 +324,7 @@ func InitApp(opts *Options, raypmPath, target string) (*Settings, error) {
 // Need for experimenting with packages
 func (s *Settings) SetPkgs(pkgsPath string) (err error) {
 	if _, err = os.Stat(pkgsPath); err != nil {
-		log.Errorln(err)
+		err = errs.Wrap(errs.Usage, "WrongPkgsPath", err, "")
 		return
 	}
 
@@ -134,7 +337,7 @@ func (s *Settings) EnableAccess() {
 
 	log.Debugln("Chaging to", bits)
 
-	recRaypmAccess(s.RaypmPath, bits)
+	recRaypmAccess(s.RaypmPath, s.LogsPath, bits)
 }
 
 func (s *Settings) DisableAccess() {
@@ -142,10 +345,32 @@ func (s *Settings) DisableAccess() {
 
 	log.Debugln("Chaging to", bits)
 
-	recRaypmAccess(s.RaypmPath, bits)
+	recRaypmAccess(s.RaypmPath, s.LogsPath, bits)
 }
 
-func recRaypmAccess(item string, bits fs.FileMode) {
+// Opens log file of the run. Raypm's directory is read-only between runs,
+// so the directory of logs is created with access to it
+func (s *Settings) OpenLog() (pth string, err error) {
+	if _, err = os.Stat(s.LogsPath); os.IsNotExist(err) {
+		if info, serr := os.Stat(s.RaypmPath); serr == nil {
+			os.Chmod(s.RaypmPath, 0754)
+			defer os.Chmod(s.RaypmPath, info.Mode().Perm())
+		}
+
+		if err = os.MkdirAll(s.LogsPath, 0754); err != nil {
+			return
+		}
+	}
+
+	return log.OpenFile(s.LogsPath)
+}
+
+// Logs are written by every run, so their directory stays writable
+func recRaypmAccess(item, logs string, bits fs.FileMode) {
+	if item == logs {
+		return
+	}
+
 	os.Chmod(item, bits)
 
 	if item == "cache" {
@@ -155,7 +380,7 @@ func recRaypmAccess(item string, bits fs.FileMode) {
 	dirs, _ := os.ReadDir(item)
 
 	for _, entry := range dirs {
-		recRaypmAccess(path.Join(item, entry.Name()), bits)
+		recRaypmAccess(path.Join(item, entry.Name()), logs, bits)
 	}
 
 	return
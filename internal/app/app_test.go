@@ -0,0 +1,128 @@
+package app
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path"
+	"raypm/internal/errs"
+	log "raypm/pkg/slog"
+	"slices"
+	"testing"
+)
+
+func TestExecArgs(t *testing.T) {
+	for _, item := range []struct {
+		name    string
+		argv    []string
+		pkg     string
+		command []string
+		target  string
+		err     error
+	}{
+		{
+			name:    "command after '--'",
+			argv:    []string{"raypm", "exec", "--", "go", "build"},
+			command: []string{"go", "build"},
+		},
+		{
+			name:    "package before '--'",
+			argv:    []string{"raypm", "exec", "raylib", "--", "make"},
+			pkg:     "raylib",
+			command: []string{"make"},
+		},
+		{
+			name:    "command has own '--'",
+			argv:    []string{"raypm", "exec", "--", "npm", "run", "--", "--watch"},
+			command: []string{"npm", "run", "--", "--watch"},
+		},
+		{
+			name:    "package is not taken from command",
+			argv:    []string{"raypm", "exec", "--", "tool", "--", "x"},
+			command: []string{"tool", "--", "x"},
+		},
+		{
+			name:    "flags before '--'",
+			argv:    []string{"raypm", "exec", "-target", "windows", "snake", "--", "go", "build", "-o", "snake.exe"},
+			pkg:     "snake",
+			command: []string{"go", "build", "-o", "snake.exe"},
+			target:  "windows",
+		},
+		{
+			name:    "without '--'",
+			argv:    []string{"raypm", "exec", "go", "version"},
+			command: []string{"go", "version"},
+		},
+		{
+			name: "two packages",
+			argv: []string{"raypm", "exec", "raylib", "glfw", "--", "make"},
+			err:  errs.Usage,
+		},
+		{
+			name: "no command",
+			argv: []string{"raypm", "exec", "raylib", "--"},
+			err:  errs.Usage,
+		},
+	} {
+		t.Run(item.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("raypm", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+
+			o, err := newOptions(fs, item.argv)
+			if err != nil {
+				t.Error(err)
+				t.FailNow()
+			}
+
+			task, pkg, err := o.SetProgramTask()
+			if item.err != nil {
+				if !errors.Is(err, item.err) {
+					t.Errorf("Expect %v, got %v", item.err, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Error(err)
+				t.FailNow()
+			}
+
+			if task != ExecCmd || pkg != item.pkg || !slices.Equal(o.ExecArgs, item.command) {
+				t.Errorf("Expect package '%s' and %q, got '%s' and %q", item.pkg, item.command, pkg, o.ExecArgs)
+			}
+
+			if o.PackageTarget != item.target {
+				t.Errorf("Expect target '%s', got '%s'", item.target, o.PackageTarget)
+			}
+		})
+	}
+}
+
+func TestOpenLog(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "app_test_*")
+	if err != nil {
+		t.Errorf("Cannot create temp directory: %s\n", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	settings := &Settings{RaypmPath: dir, LogsPath: path.Join(dir, "logs")}
+	settings.DisableAccess()
+	defer settings.EnableAccess()
+
+	pth, err := settings.OpenLog()
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	defer log.Close()
+
+	if _, err := os.Stat(pth); err != nil {
+		t.Errorf("Expect log file: %s", err)
+	}
+
+	if info, err := os.Stat(dir); err != nil || info.Mode().Perm() != 0550 {
+		t.Errorf("Expect read-only directory of raypm, got %v (%v)", info.Mode().Perm(), err)
+	}
+}
@@ -0,0 +1,205 @@
+package darwin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"image"
+	_ "image/png"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"raypm/internal/errs"
+	"strings"
+)
+
+const iconName = "AppIcon"
+
+// Creates '$out/<name>.app' from '$out/<executable>', icon and assets are
+// taken from 'src'. Old bundle is replaced
+func (t *Target) Bundle(src, out string) (bundle string, err error) {
+	bundle = path.Join(out, t.Name+".app")
+	contents := path.Join(bundle, "Contents")
+	exe := path.Join(out, t.Executable)
+
+	if _, err = os.Stat(exe); err != nil {
+		err = errs.Wrap(errs.Phase, "ExecutableNotFound", err, "build phase didn't produce '%s'", exe)
+		return
+	}
+
+	if err = os.RemoveAll(bundle); err != nil {
+		return
+	}
+
+	for _, item := range []string{"MacOS", "Resources"} {
+		if err = os.MkdirAll(path.Join(contents, item), 0755); err != nil {
+			err = errs.Wrap(errs.Phase, "CannotCreateFolder", err, "'%s'", path.Join(contents, item))
+			return
+		}
+	}
+
+	bundleExe := path.Join(contents, "MacOS", t.Executable)
+	if err = os.Rename(exe, bundleExe); err != nil {
+		err = errs.Wrap(errs.Phase, "CannotMoveExecutable", err, "to the bundle")
+		return
+	}
+	os.Chmod(bundleExe, 0755)
+
+	if t.Icon != "" {
+		if err = writeIcon(path.Join(src, t.Icon), path.Join(contents, "Resources", iconName+".icns")); err != nil {
+			err = errs.Wrap(errs.Phase, "CannotAddIcon", err, "'%s'", t.Icon)
+			return
+		}
+	}
+
+	for _, item := range t.Assets {
+		item = path.Clean(item)
+		if err = copyTree(path.Join(src, item), path.Join(contents, "Resources", item)); err != nil {
+			err = errs.Wrap(errs.Phase, "CannotCopyAsset", err, "'%s'", item)
+			return
+		}
+	}
+
+	if err = os.WriteFile(path.Join(contents, "Info.plist"), t.InfoPlist(), 0644); err != nil {
+		return
+	}
+
+	err = os.WriteFile(path.Join(contents, "PkgInfo"), []byte("APPL????"), 0644)
+	return
+}
+
+// Info.plist in XML format
+func (t *Target) InfoPlist() []byte {
+	var w bytes.Buffer
+
+	w.WriteString(xml.Header)
+	w.WriteString(`<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">` + "\n")
+	w.WriteString(`<plist version="1.0">` + "\n<dict>\n")
+
+	entries := [][2]string{
+		{"CFBundleDevelopmentRegion", "en"},
+		{"CFBundleDisplayName", t.Name},
+		{"CFBundleExecutable", t.Executable},
+		{"CFBundleIdentifier", t.BundleID},
+		{"CFBundleInfoDictionaryVersion", "6.0"},
+		{"CFBundleName", t.Name},
+		{"CFBundlePackageType", "APPL"},
+		{"CFBundleShortVersionString", t.Version},
+		{"CFBundleVersion", t.Version},
+		{"LSMinimumSystemVersion", t.MinVersion},
+	}
+
+	if t.Icon != "" {
+		entries = append(entries, [2]string{"CFBundleIconFile", iconName})
+	}
+
+	for _, item := range entries {
+		w.WriteString("\t<key>" + escape(item[0]) + "</key>\n")
+		w.WriteString("\t<string>" + escape(item[1]) + "</string>\n")
+	}
+
+	w.WriteString("\t<key>NSHighResolutionCapable</key>\n\t<true/>\n")
+	w.WriteString("</dict>\n</plist>\n")
+
+	return w.Bytes()
+}
+
+func escape(s string) string {
+	var w strings.Builder
+	xml.EscapeText(&w, []byte(s))
+	return w.String()
+}
+
+// Types of ICNS entries with PNG inside, by width of the image
+var icnsTypes = map[int]string{
+	16:   "icp4",
+	32:   "icp5",
+	64:   "icp6",
+	128:  "ic07",
+	256:  "ic08",
+	512:  "ic09",
+	1024: "ic10",
+}
+
+// Copies .icns as is, or wraps square PNG to ICNS
+func writeIcon(src, dst string) (err error) {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return
+	}
+
+	if strings.HasSuffix(strings.ToLower(src), ".icns") {
+		return os.WriteFile(dst, data, 0644)
+	}
+
+	icns, err := pngToIcns(data)
+	if err != nil {
+		return
+	}
+
+	return os.WriteFile(dst, icns, 0644)
+}
+
+func pngToIcns(data []byte) (icns []byte, err error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+
+	if format != "png" {
+		err = errs.New(errs.Phase, "IconIsNotPng", "'%s'", format)
+		return
+	}
+
+	typ, ok := icnsTypes[cfg.Width]
+	if !ok || cfg.Width != cfg.Height {
+		err = errs.New(errs.Phase, "UnsupportedIconSize", "%dx%d", cfg.Width, cfg.Height)
+		return
+	}
+
+	// Header and the only entry: type and length (with own header) in big endian
+	icns = append([]byte("icns"), binary.BigEndian.AppendUint32(nil, uint32(16+len(data)))...)
+	icns = append(icns, typ...)
+	icns = binary.BigEndian.AppendUint32(icns, uint32(8+len(data)))
+	icns = append(icns, data...)
+	return
+}
+
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(pth string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, pth)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+
+		if err = os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+
+		in, err := os.Open(pth)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		out, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+
+		_, err = io.Copy(out, in)
+		return err
+	})
+}
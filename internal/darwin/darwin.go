@@ -0,0 +1,130 @@
+// macOS target: osxcross toolchain for cross compilation from Linux and
+// .app bundle assembly.
+//
+// Build phase must put the executable to '$out/<executable>', then it's moved
+// to '$out/<name>.app/Contents/MacOS' together with Info.plist, icon and
+// assets
+package darwin
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"raypm/internal/errs"
+	"raypm/internal/pkglua"
+	"runtime"
+	"strings"
+)
+
+const (
+	// Name of the dependency with osxcross
+	OsxcrossPackage = "osxcross"
+
+	DefaultMinVersion = "10.13"
+)
+
+// GOARCH -> prefix of osxcross' compiler wrappers
+var compilers = map[string]string{
+	"amd64": "o64",
+	"arm64": "oa64",
+}
+
+type Target struct {
+	Name       string
+	Version    string
+	BundleID   string
+	Executable string
+	Icon       string   // .png or .icns, relative to $src
+	Assets     []string // Go to Contents/Resources
+	MinVersion string   // LSMinimumSystemVersion
+	Arch       string   // GOARCH
+	Osxcross   string   // Root of osxcross (directory of 'osxcross' package in the store)
+}
+
+// Creates the target from package's fields:
+//   - bundle_id (default: com.raypm.<name>)
+//   - executable (default: <name>)
+//   - icon
+//   - assets
+//   - min_macos (default: DefaultMinVersion)
+//
+// 'arch' is GOARCH of the target
+func NewTarget(name, version, arch string, spec pkglua.TargetSpec, osxcross string) (t *Target, err error) {
+	t = &Target{
+		Name:       name,
+		Version:    version,
+		BundleID:   "com.raypm." + strings.ReplaceAll(name, "_", "-"),
+		Executable: name,
+		Icon:       spec.Value("icon"),
+		Assets:     spec["assets"],
+		MinVersion: DefaultMinVersion,
+		Arch:       arch,
+		Osxcross:   osxcross,
+	}
+
+	if v := spec.Value("bundle_id"); v != "" {
+		t.BundleID = v
+	}
+
+	if v := spec.Value("executable"); v != "" {
+		t.Executable = v
+	}
+
+	if v := spec.Value("min_macos"); v != "" {
+		t.MinVersion = v
+	}
+
+	if _, ok := compilers[t.Arch]; !ok {
+		err = errs.New(errs.Resolution, "UnsupportedArch", "'%s'", t.Arch)
+	}
+
+	return
+}
+
+// Cross compilation is needed, when the host is not macOS
+func (t *Target) Cross() bool {
+	return runtime.GOOS != "darwin"
+}
+
+func (t *Target) ToolchainBin() string {
+	return path.Join(t.Osxcross, "target", "bin")
+}
+
+// Environment for building with Go and C
+func (t *Target) Env() (env map[string]string) {
+	env = map[string]string{
+		"GOOS":                     "darwin",
+		"GOARCH":                   t.Arch,
+		"CGO_ENABLED":              "1",
+		"MACOSX_DEPLOYMENT_TARGET": t.MinVersion,
+		"CC":                       "clang",
+		"CXX":                      "clang++",
+	}
+
+	if t.Cross() {
+		prefix := compilers[t.Arch]
+		env["CC"] = filepath.Join(t.ToolchainBin(), prefix+"-clang")
+		env["CXX"] = filepath.Join(t.ToolchainBin(), prefix+"-clang++")
+		env["OSXCROSS_ROOT"] = t.Osxcross
+		env["PATH"] = t.ToolchainBin() + string(os.PathListSeparator) + os.Getenv("PATH")
+	}
+
+	return
+}
+
+// Checks, that osxcross has compiler for the arch
+func (t *Target) CheckToolchain() (err error) {
+	if !t.Cross() {
+		return
+	}
+
+	if t.Osxcross == "" {
+		return errs.New(errs.Resolution, "OsxcrossNotFound", "add '%s' to dependencies", OsxcrossPackage)
+	}
+
+	if _, err = os.Stat(t.Env()["CC"]); err != nil {
+		return errs.Wrap(errs.Resolution, "OsxcrossCompilerNotFound", err, "'%s'", t.Env()["CC"])
+	}
+
+	return
+}
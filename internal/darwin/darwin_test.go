@@ -0,0 +1,123 @@
+package darwin
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"os"
+	"path"
+	log "raypm/pkg/slog"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestNewTarget(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		target, err := NewTarget("snake", "1.0", "amd64", map[string][]string{}, "/store/a-osxcross-1")
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		env := target.Env()
+		if env["GOOS"] != "darwin" || env["GOARCH"] != "amd64" {
+			t.Errorf("Wrong env: %v", env)
+		}
+
+		if runtime.GOOS != "darwin" && env["CC"] != "/store/a-osxcross-1/target/bin/o64-clang" {
+			t.Errorf("Expect compiler of osxcross, got '%s'", env["CC"])
+		}
+	})
+
+	t.Run("arm64", func(t *testing.T) {
+		target, err := NewTarget("snake", "1.0", "arm64", map[string][]string{}, "/store/a-osxcross-1")
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		if runtime.GOOS != "darwin" && !strings.HasSuffix(target.Env()["CC"], "oa64-clang") {
+			t.Errorf("Expect 'oa64-clang', got '%s'", target.Env()["CC"])
+		}
+	})
+
+	t.Run("unknown arch", func(t *testing.T) {
+		if _, err := NewTarget("snake", "1.0", "ppc", map[string][]string{}, ""); err == nil {
+			t.Error("Expect error for 'ppc'")
+		}
+	})
+}
+
+func TestBundle(t *testing.T) {
+	log.Init(false)
+
+	dir, err := os.MkdirTemp(os.TempDir(), "darwin_test_*")
+	if err != nil {
+		t.Errorf("Cannot create temp directory: %s\n", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	out := path.Join(dir, "build")
+	os.MkdirAll(path.Join(dir, "resources"), 0754)
+	os.MkdirAll(out, 0754)
+	os.WriteFile(path.Join(dir, "resources", "map.txt"), []byte("####"), 0644)
+	os.WriteFile(path.Join(out, "snake"), []byte("Mach-O"), 0644)
+
+	var icon bytes.Buffer
+	png.Encode(&icon, image.NewRGBA(image.Rect(0, 0, 128, 128)))
+	os.WriteFile(path.Join(dir, "icon.png"), icon.Bytes(), 0644)
+
+	target, _ := NewTarget("snake", "1.0", "amd64", map[string][]string{
+		"icon":      {"icon.png"},
+		"assets":    {"resources"},
+		"bundle_id": {"com.raylib.snake"},
+	}, "")
+
+	bundle, err := target.Bundle(dir, out)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	contents := path.Join(bundle, "Contents")
+
+	t.Run("layout", func(t *testing.T) {
+		for _, item := range []string{"MacOS/snake", "Resources/AppIcon.icns", "Resources/resources/map.txt", "PkgInfo"} {
+			if _, err := os.Stat(path.Join(contents, item)); err != nil {
+				t.Error(err)
+			}
+		}
+
+		if info, err := os.Stat(path.Join(contents, "MacOS", "snake")); err == nil && info.Mode()&0111 == 0 {
+			t.Error("Executable is not executable")
+		}
+	})
+
+	t.Run("info.plist", func(t *testing.T) {
+		plist, _ := os.ReadFile(path.Join(contents, "Info.plist"))
+		for _, item := range []string{
+			"<key>CFBundleIdentifier</key>\n\t<string>com.raylib.snake</string>",
+			"<key>CFBundleIconFile</key>\n\t<string>AppIcon</string>",
+		} {
+			if !bytes.Contains(plist, []byte(item)) {
+				t.Errorf("Expect '%s' in Info.plist", item)
+			}
+		}
+	})
+
+	t.Run("icns", func(t *testing.T) {
+		icns, _ := os.ReadFile(path.Join(contents, "Resources", "AppIcon.icns"))
+		if len(icns) < 16 || string(icns[:4]) != "icns" || string(icns[8:12]) != "ic07" {
+			t.Error("Expect ICNS with 128x128 PNG")
+		}
+	})
+
+	t.Run("wrong icon size", func(t *testing.T) {
+		var img bytes.Buffer
+		png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 100, 100)))
+		if _, err := pngToIcns(img.Bytes()); err == nil {
+			t.Error("Expect error for 100x100 icon")
+		}
+	})
+}
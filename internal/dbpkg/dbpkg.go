@@ -6,17 +6,19 @@ package dbpkg
 
 import (
 	"encoding/json"
-	"fmt"
 	"maps"
 	"os"
 	"path"
-	log "raypm/pkg/slog"
+	"raypm/internal/errs"
 	"slices"
+	"sync"
 )
 
 type Relations struct {
 	DependsOn   []string `json:"depends_on"`
 	RequiredFor []string `json:"required_for"`
+	Out         string   `json:"out,omitempty"`    // Custom output path, empty if package is in the store
+	Target      string   `json:"target,omitempty"` // Triple, like 'linux/amd64/gnu'
 }
 
 func IsRelEqual(a, b Relations) bool {
@@ -25,7 +27,7 @@ func IsRelEqual(a, b Relations) bool {
 	bDep := b.DependsOn
 	bReq := b.RequiredFor
 
-	if len(aDep) != len(bDep) || len(aReq) != len(bReq) {
+	if len(aDep) != len(bDep) || len(aReq) != len(bReq) || a.Out != b.Out || a.Target != b.Target {
 		return false
 	}
 
@@ -50,40 +52,59 @@ func (a PkgsRel) IsEqual(b PkgsRel) bool {
 	return maps.EqualFunc(a, b, IsRelEqual)
 }
 
+// Package's name -> store entries ('<hash>-<name>-<version>')
+type NameIndex map[string][]string
+
+// Keys of Pkgs are store entries, so the same package could be installed for
+// several targets or in several versions. Methods could be called from
+// several goroutines, packages are installed in parallel
 type PkgDb struct {
 	Pkgs     PkgsRel
+	Index    NameIndex
 	PathToDb string
+	mu       sync.RWMutex
+}
+
+type dbFile struct {
+	Pkgs  PkgsRel   `json:"pkgs"`
+	Index NameIndex `json:"index"`
 }
 
 func NewDb(pathToDb string) *PkgDb {
 	return &PkgDb{
 		PathToDb: pathToDb,
 		Pkgs:     make(map[string]Relations),
+		Index:    make(NameIndex),
 	}
 }
 
 func Open(pathToDb string) (pd *PkgDb, err error) {
 
-	if _, err = os.Stat(pathToDb); err != nil {
-		log.Errorln(err)
+	pd = NewDb(pathToDb)
+
+	data, err := os.ReadFile(pd.PathToDb)
+	if err != nil {
+		err = errs.Wrap(errs.Database, "CannotOpenDatabase", err, "")
 		return
 	}
-	pd = &PkgDb{
-		Pkgs:     make(map[string]Relations, 0),
-		PathToDb: pathToDb,
+
+	content := dbFile{}
+	if err = json.Unmarshal(data, &content); err != nil {
+		err = errs.Wrap(errs.Database, "CannotDecodeDatabase", err, "'%s'", pathToDb)
+		return
 	}
 
-	fDb, err := os.Open(pd.PathToDb)
-	if err != nil {
-		log.Error("Failed to open database:")
-		log.Errorln(err)
+	if content.Pkgs != nil {
+		pd.Pkgs = content.Pkgs
+		if content.Index != nil {
+			pd.Index = content.Index
+		}
 		return
 	}
-	defer fDb.Close()
 
-	if err = json.NewDecoder(fDb).Decode(&pd.Pkgs); err != nil {
-		log.Errorln("Cannot decode database:")
-		log.Errorln(err)
+	// Old database is just a map of packages without index
+	if err = json.Unmarshal(data, &pd.Pkgs); err != nil {
+		err = errs.Wrap(errs.Database, "CannotDecodeDatabase", err, "'%s'", pathToDb)
 		return
 	}
 
@@ -94,39 +115,115 @@ func (pd *PkgDb) WriteData() (err error) {
 	dir := path.Dir(pd.PathToDb)
 	if _, err = os.Stat(dir); err != nil {
 		if err = os.MkdirAll(dir, 0754); err != nil {
-			log.Error("Failed to create '%s':", dir)
-			log.Errorln(err)
+			err = errs.Wrap(errs.Database, "CannotCreateFolder", err, "'%s'", dir)
 			return
 		}
 	}
 
 	fDb, err := os.Create(pd.PathToDb)
 	if err != nil {
-		log.Errorln("Cannot create database file:")
-		log.Errorln(err)
+		err = errs.Wrap(errs.Database, "CannotCreateDatabase", err, "'%s'", pd.PathToDb)
+		return
 	}
 	defer fDb.Close()
 
-	if err = json.NewEncoder(fDb).Encode(&pd.Pkgs); err != nil {
-		log.Errorln("Cannot encode to database file:")
-		log.Errorln(err)
+	pd.mu.RLock()
+	defer pd.mu.RUnlock()
+
+	content := dbFile{
+		Pkgs:  pd.Pkgs,
+		Index: pd.Index,
+	}
+
+	if err = json.NewEncoder(fDb).Encode(&content); err != nil {
+		err = errs.Wrap(errs.Database, "CannotEncodeDatabase", err, "'%s'", pd.PathToDb)
 	}
 	return
 }
 
 func (pd *PkgDb) IsExists(RelationsName string) bool {
+	pd.mu.RLock()
+	defer pd.mu.RUnlock()
+
 	_, ok := pd.Pkgs[RelationsName]
 
 	return ok
 }
 
 func (pd *PkgDb) Add(RelationsName string) {
+	pd.mu.Lock()
+	defer pd.mu.Unlock()
+
 	if _, ok := pd.Pkgs[RelationsName]; !ok {
 		pd.Pkgs[RelationsName] = Relations{}
 	}
 }
 
+// Remembers where the package was installed with '-o', so it can be removed
+// later
+func (pd *PkgDb) SetOut(RelationsName, out string) {
+	pd.mu.Lock()
+	defer pd.mu.Unlock()
+
+	if rel, ok := pd.Pkgs[RelationsName]; ok {
+		rel.Out = out
+		pd.Pkgs[RelationsName] = rel
+	}
+}
+
+// Returns custom output path of the package or empty string
+func (pd *PkgDb) GetOut(RelationsName string) string {
+	pd.mu.RLock()
+	defer pd.mu.RUnlock()
+
+	return pd.Pkgs[RelationsName].Out
+}
+
+func (pd *PkgDb) SetTarget(RelationsName, target string) {
+	pd.mu.Lock()
+	defer pd.mu.Unlock()
+
+	if rel, ok := pd.Pkgs[RelationsName]; ok {
+		rel.Target = target
+		pd.Pkgs[RelationsName] = rel
+	}
+}
+
+func (pd *PkgDb) AddIndex(name, entry string) {
+	pd.mu.Lock()
+	defer pd.mu.Unlock()
+
+	if !slices.Contains(pd.Index[name], entry) {
+		pd.Index[name] = append(pd.Index[name], entry)
+	}
+}
+
+// Returns all store entries of the package
+func (pd *PkgDb) Lookup(name string) []string {
+	pd.mu.RLock()
+	defer pd.mu.RUnlock()
+
+	return slices.Clone(pd.Index[name])
+}
+
+// Returns store entries of the package built for the target. Entries of old
+// databases have no target and are not returned
+func (pd *PkgDb) LookupTarget(name, target string) (entries []string) {
+	pd.mu.RLock()
+	defer pd.mu.RUnlock()
+
+	for _, item := range pd.Index[name] {
+		if pd.Pkgs[item].Target == target {
+			entries = append(entries, item)
+		}
+	}
+	return
+}
+
 func (pd *PkgDb) AddDep(RelationsName, depName string) {
+	pd.mu.Lock()
+	defer pd.mu.Unlock()
+
 	addingTo, okTo := pd.Pkgs[RelationsName]
 	dep, okDep := pd.Pkgs[depName]
 
@@ -140,17 +237,28 @@ func (pd *PkgDb) AddDep(RelationsName, depName string) {
 }
 
 func (pd *PkgDb) Del(RelationsName string) (err error) {
+	pd.mu.Lock()
+	defer pd.mu.Unlock()
+
 	if _, ok := pd.Pkgs[RelationsName]; ok {
 		req := pd.Pkgs[RelationsName]
 
 		if len(req.RequiredFor) > 0 {
-			err = fmt.Errorf("PackageIsRequiredByOther")
-			for _, item := range req.RequiredFor {
-				log.Error("Package '%s' depends on '%s'", item, RelationsName)
-			}
+			err = errs.New(errs.Database, "PackageIsRequiredByOther", "%v depend on '%s'", req.RequiredFor, RelationsName)
 		} else {
 			delete(pd.Pkgs, RelationsName)
 
+			for name, entries := range pd.Index {
+				if i := slices.Index(entries, RelationsName); i >= 0 {
+					entries = slices.Delete(entries, i, i+1)
+					if len(entries) == 0 {
+						delete(pd.Index, name)
+					} else {
+						pd.Index[name] = entries
+					}
+				}
+			}
+
 			for k, v := range pd.Pkgs {
 				ignoreInd := -1
 				left := make([]string, 0)
@@ -1,11 +1,14 @@
 package dbpkg
 
 import (
+	"errors"
 	"fmt"
 	"maps"
 	"os"
 	"path"
+	"raypm/internal/errs"
 	log "raypm/pkg/slog"
+	"sync"
 	"testing"
 )
 
@@ -147,6 +150,65 @@ func TestAddDelPackage(t *testing.T) {
 	})
 }
 
+func TestCustomOut(t *testing.T) {
+	log.Init(false)
+
+	t.Run("remember custom output path", func(t *testing.T) {
+		db := NewDb(path.Join(os.TempDir(), "db_out_test.json"))
+		db.Add("package")
+		db.Add("neco-arc")
+		db.SetOut("neco-arc", "/tmp/neco-arc")
+		db.AddDep("neco-arc", "package")
+
+		wantPkgs := PkgsRel{
+			"package": {
+				RequiredFor: []string{"neco-arc"},
+			},
+			"neco-arc": {
+				DependsOn: []string{"package"},
+				Out:       "/tmp/neco-arc",
+			},
+		}
+
+		if !wantPkgs.IsEqual(db.Pkgs) {
+			t.Error(mismatchMaps(&wantPkgs, &db.Pkgs))
+		}
+
+		if out := db.GetOut("package"); out != "" {
+			t.Errorf("Expect empty output path for 'package', got '%s'", out)
+		}
+	})
+}
+
+// Run with -race: packages are installed from several goroutines
+func TestConcurrentAccess(t *testing.T) {
+	db := NewDb(path.Join(os.TempDir(), "db_race_test.json"))
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			entry := fmt.Sprintf("hash-pkg%d-1", i)
+
+			for j := 0; j < 100; j++ {
+				db.IsExists(entry)
+				db.Lookup(fmt.Sprintf("pkg%d", j%8))
+				db.GetOut(entry)
+			}
+
+			db.Add(entry)
+			db.AddIndex(fmt.Sprintf("pkg%d", i), entry)
+			db.SetTarget(entry, "linux/amd64/gnu")
+		}()
+	}
+	wg.Wait()
+
+	if len(db.Pkgs) != 8 || len(db.Index) != 8 {
+		t.Errorf("Expect 8 packages, got %v", db.Pkgs)
+	}
+}
+
 func equalPkg(a, b Relations) bool {
 	aDep := a.DependsOn
 	aReq := a.RequiredFor
@@ -178,3 +240,27 @@ func mismatchMaps(expect, got *PkgsRel) string {
 		expect, got,
 	)
 }
+
+func TestWriteData(t *testing.T) {
+	log.Init(false)
+
+	tmpDir, err := os.MkdirTemp(os.TempDir(), "db_test_*")
+	if err != nil {
+		t.Errorf("Cannot create temp directory: %s\n", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(tmpDir)
+
+	t.Run("database in place of file", func(t *testing.T) {
+		file := path.Join(tmpDir, "file")
+		if err := os.WriteFile(file, nil, 0644); err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		db := NewDb(path.Join(file, "db.json"))
+		if err := db.WriteData(); !errors.Is(err, errs.Database) {
+			t.Errorf("Expect database error, got %v", err)
+		}
+	})
+}
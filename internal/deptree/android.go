@@ -0,0 +1,75 @@
+package deptree
+
+import (
+	"maps"
+	"os"
+	"path"
+	"raypm/internal/android"
+	log "raypm/pkg/slog"
+)
+
+// Android target of the root package. NDK is taken from the dependency
+// named 'ndk'
+func (dp *Tree) AndroidTarget() (t *android.Target, err error) {
+	return dp.Nodes.androidTarget()
+}
+
+func (dn *Node) androidTarget() (t *android.Target, err error) {
+	ndk := ""
+	for _, item := range dn.Depends {
+		if item.Name == android.NdkPackage {
+			ndk = item.Vars.Out
+		}
+	}
+
+	// Without 'abis' the arch of the target triple is built
+	spec := dn.Pkg.TargetSpec
+	if abi, ok := android.ABIForArch(dn.Data.Target.Arch); ok && len(spec["abis"]) == 0 {
+		spec = maps.Clone(spec)
+		spec["abis"] = []string{abi.Name}
+	}
+
+	return android.NewTarget(dn.Name, dn.Pkg.MData["version"], spec, ndk)
+}
+
+// Runs build phase for every ABI with $abi and environment of NDK, then packs
+// '$out/lib/<abi>' to debug APK
+func (dn *Node) buildAndroid() (err error) {
+	t, err := dn.androidTarget()
+	if err != nil {
+		return
+	}
+
+	if err = t.CheckNdk(); err != nil {
+		return
+	}
+
+	for _, abi := range t.ABIs {
+		log.Info("Building '%s' for %s", dn.Name, abi.Name)
+
+		if err = os.MkdirAll(path.Join(dn.Vars.Out, "lib", abi.Name), 0754); err != nil {
+			return
+		}
+
+		// '${setenv}' of previous ABI must not leak to the next one
+		dn.Vars.Abi = abi.Name
+		dn.Vars.Env = t.Env(abi)
+
+		if err = dn.runPhase("build"); err != nil {
+			return
+		}
+	}
+
+	keyPath, err := android.DebugKeyPath()
+	if err != nil {
+		return
+	}
+
+	key, err := android.LoadDebugKey(keyPath)
+	if err != nil {
+		return
+	}
+
+	_, err = t.BuildAPK(dn.Vars.Out, key)
+	return
+}
@@ -0,0 +1,50 @@
+package deptree
+
+import (
+	"maps"
+	"raypm/internal/darwin"
+	log "raypm/pkg/slog"
+)
+
+// macOS target of the root package. osxcross is taken from the dependency
+// named 'osxcross'
+func (dp *Tree) DarwinTarget() (t *darwin.Target, err error) {
+	return dp.Nodes.darwinTarget()
+}
+
+func (dn *Node) darwinTarget() (t *darwin.Target, err error) {
+	osxcross := ""
+	for _, item := range dn.Depends {
+		if item.Name == darwin.OsxcrossPackage {
+			osxcross = item.Vars.Out
+		}
+	}
+
+	return darwin.NewTarget(dn.Name, dn.Pkg.MData["version"], dn.Data.Target.Arch, dn.Pkg.TargetSpec, osxcross)
+}
+
+// Runs build phase with environment of osxcross, then makes .app bundle
+// from the executable
+func (dn *Node) buildDarwin() (err error) {
+	t, err := dn.darwinTarget()
+	if err != nil {
+		return
+	}
+
+	if err = t.CheckToolchain(); err != nil {
+		return
+	}
+
+	maps.Copy(dn.Vars.Env, t.Env())
+	if err = dn.runPhase("build"); err != nil {
+		return
+	}
+
+	bundle, err := t.Bundle(dn.Vars.Src, dn.Vars.Out)
+	if err != nil {
+		return
+	}
+
+	log.Info("Bundle: %s", bundle)
+	return
+}
@@ -1,40 +1,87 @@
 package deptree
 
 import (
-	"fmt"
 	"path"
 	"raypm/internal/dbpkg"
+	"raypm/internal/errs"
+	"raypm/internal/triple"
 	log "raypm/pkg/slog"
+	"runtime"
+	"time"
 )
 
 type PkgData struct {
 	BasePath string
 	PkgsPath string
-	Target   string
-	Host     string
+	Target   triple.Triple
+	Host     triple.Triple
+	// Directories of packages, that are used by generations. They stay in
+	// the store, when packages are removed, and are restored on install
+	Used map[string]bool
 }
 
 type Tree struct {
 	Nodes    *Node
 	Data     PkgData
 	DataBase *dbpkg.PkgDb
+	Jobs     int // Number of packages, that are fetched or built at the same time
 }
 
-func NewDepTree(raypmPath, packageName, host, target string, db *dbpkg.PkgDb) (depTree *Tree,
+// outputPath overrides $out of the package itself, dependencies are still
+// going to the store
+func NewDepTree(raypmPath, packageName, host, target, outputPath string, db *dbpkg.PkgDb) (depTree *Tree,
 	err error) {
+	if depTree, err = newTree(raypmPath, host, target, db); err != nil {
+		return
+	}
+
+	log.Debugln("Creating dependency tree")
+	if depTree.Nodes, err = NewNode(&depTree.Data, depTree.DataBase, packageName, outputPath); err != nil {
+		err = errs.Wrap(errs.Resolution, "DependencyTreeFailed", err, "'%s'", packageName)
+		return
+	}
+	depTree.Nodes.useStoredOut()
+
+	return
+}
+
+// Tree for package.lua, that is not in pkgs (like a project in current
+// directory). Its dependencies are taken from pkgs as usual
+func NewDepTreeFromFile(raypmPath, pkgFile, host, target string, db *dbpkg.PkgDb) (depTree *Tree,
+	err error) {
+	if depTree, err = newTree(raypmPath, host, target, db); err != nil {
+		return
+	}
+
+	log.Debug("Creating dependency tree for '%s'", pkgFile)
+	if depTree.Nodes, err = newNodeFromFile(&depTree.Data, depTree.DataBase, pkgFile, "", ""); err != nil {
+		err = errs.Wrap(errs.Resolution, "DependencyTreeFailed", err, "'%s'", pkgFile)
+		return
+	}
+	depTree.Nodes.useStoredOut()
+
+	return
+}
+
+// 'host' and 'target' are triples like 'linux/amd64/gnu', short forms are
+// completed with defaults
+func newTree(raypmPath, host, target string, db *dbpkg.PkgDb) (depTree *Tree, err error) {
 	depTree = &Tree{
 		Data: PkgData{
 			BasePath: raypmPath,
 			PkgsPath: path.Join(raypmPath, "pkgs"),
-			Target:   target,
-			Host:     host,
 		},
 		DataBase: db,
+		Jobs:     runtime.NumCPU(),
 	}
 
-	log.Debugln("Creating dependency tree")
-	if depTree.Nodes, err = NewNode(&depTree.Data, depTree.DataBase, packageName); err != nil {
-		err = fmt.Errorf("Failed to build dependency tree:\n%s\n", err)
+	if depTree.Data.Host, err = triple.Parse(host); err != nil {
+		err = errs.Wrap(errs.Usage, "WrongHost", err, "'%s'", host)
+		return
+	}
+
+	if depTree.Data.Target, err = triple.Parse(target); err != nil {
+		err = errs.Wrap(errs.Usage, "WrongTarget", err, "'%s'", target)
 	}
 
 	return
@@ -47,9 +94,53 @@ func (dp *Tree) ShowTree() {
 	dp.Nodes.ShowNode()
 }
 
+// Fetches all packages in parallel, then installs them, independent packages
+// are installed in parallel too
 func (dp *Tree) Install() (err error) {
-	if err = dp.Nodes.InstallNode(); err != nil {
-		log.Error("Package installation failed")
+	defer finishRun(time.Now(), &err)
+
+	nodes := dp.Nodes.plan(nil, map[string]bool{})
+	if len(nodes) == 0 {
+		_, err = dp.Nodes.installed()
+		return
+	}
+	reportPlan(nodes)
+
+	if err = fetchAll(nodes, dp.Jobs); err != nil {
+		err = errs.Wrap(errs.Phase, "InstallationFailed", err, "")
+		return
+	}
+
+	if err = installAll(nodes, dp.Jobs); err != nil {
+		err = errs.Wrap(errs.Phase, "InstallationFailed", err, "")
+		return
+	}
+	return
+}
+
+// Builds the root package, outputPath overrides its 'build_path'
+func (dp *Tree) Build(outputPath string) (err error) {
+	defer finishRun(time.Now(), &err)
+
+	var nodes []*Node
+	seen := map[string]bool{}
+	for _, item := range dp.Nodes.Depends {
+		nodes = item.plan(nodes, seen)
+	}
+	reportPlan(nodes)
+
+	if err = fetchAll(nodes, dp.Jobs); err != nil {
+		err = errs.Wrap(errs.Phase, "BuildFailed", err, "")
+		return
+	}
+
+	if err = installAll(nodes, dp.Jobs); err != nil {
+		err = errs.Wrap(errs.Phase, "BuildFailed", err, "")
+		return
+	}
+
+	if err = dp.Nodes.BuildNode(outputPath); err != nil {
+		err = errs.Wrap(errs.Phase, "BuildFailed", err, "")
 		return
 	}
 	return
@@ -57,9 +148,16 @@ func (dp *Tree) Install() (err error) {
 
 func (dp *Tree) Uninstall() (err error) {
 	if err = dp.Nodes.UninstallNode(); err != nil {
-		log.Errorln("Failed to delete package")
+		err = errs.Wrap(errs.Phase, "UninstallationFailed", err, "")
 		return
 	}
 
 	return
 }
+
+// Package's name -> its directory, for every package in the tree
+func (dp *Tree) Outputs() (outs map[string]string) {
+	outs = make(map[string]string)
+	dp.Nodes.outputs(outs)
+	return
+}
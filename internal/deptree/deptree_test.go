@@ -1,15 +1,28 @@
 package deptree
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
 	"raypm/internal/dbpkg"
+	"raypm/internal/errs"
+	"raypm/internal/pkgconfig"
+	"raypm/internal/task"
+	"raypm/internal/triple"
 	"raypm/pkg/progress"
+	"raypm/pkg/report"
 	log "raypm/pkg/slog"
+	"reflect"
 	"runtime"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestInstall(t *testing.T) {
@@ -19,6 +32,7 @@ func TestInstall(t *testing.T) {
 		db       *dbpkg.PkgDb
 	)
 	log.Init(false)
+	host := triple.Host().String()
 
 	tmpRaypm = path.Join(os.TempDir())
 	tmpRaypm, err = os.MkdirTemp(tmpRaypm, "install_test_*")
@@ -38,42 +52,45 @@ func TestInstall(t *testing.T) {
 
 	t.Run("install a package", func(t *testing.T) {
 		localTree, err := NewDepTree(
-			tmpRaypm, "testdep", runtime.GOOS, db,
+			tmpRaypm, "testdep", runtime.GOOS, runtime.GOOS, "", db,
 		)
 
 		if err != nil {
 			t.Errorf("Failed to resolve dependencies:\n%s\n", err)
+			t.FailNow()
 		}
+		e := entries(localTree)
 
 		if err = localTree.Install(); err != nil {
 			t.Error(err)
 		}
 
 		wantPkgs := dbpkg.PkgsRel{
-			"testdep": {
-				DependsOn: []string{
-					"another", "testpackage",
-				},
+			e["testdep"]: {
+				Target:    host,
+				DependsOn: sortedEntries(e["another"], e["testpackage"]),
 			},
 
-			"testpackage": {
+			e["testpackage"]: {
+				Target: host,
 				RequiredFor: []string{
-					"testdep",
+					e["testdep"],
 				},
 			},
 
-			"another": {
+			e["another"]: {
+				Target: host,
 				RequiredFor: []string{
-					"testdep",
+					e["testdep"],
 				},
 			},
 		}
 
 		storeBase := path.Join(tmpRaypm, "store")
 		wantFiles := []string{
-			path.Join(storeBase, "testdep"),
-			path.Join(storeBase, "another"),
-			path.Join(storeBase, "testpackage"),
+			path.Join(storeBase, e["testdep"]),
+			path.Join(storeBase, e["another"]),
+			path.Join(storeBase, e["testpackage"]),
 		}
 
 		if !wantPkgs.IsEqual(db.Pkgs) {
@@ -86,6 +103,41 @@ func TestInstall(t *testing.T) {
 			}
 		}
 	})
+
+	t.Run("pkg-config file of installed package", func(t *testing.T) {
+		if runtime.GOOS != "linux" {
+			return
+		}
+
+		tree, err := NewDepTree(tmpRaypm, "testdep", runtime.GOOS, runtime.GOOS, "", db)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		// .pc file is in the store entry, so it's switched with generations
+		out := path.Join(tmpRaypm, "store", entries(tree)["another"])
+		pc, err := pkgconfig.Read(path.Join(pkgconfig.Dir(out), "another.pc"))
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		wantLibs := []string{"-L" + out + "/lib", "-lanother", "-lm"}
+		if !slices.Equal(pc.Libs, wantLibs) {
+			t.Errorf("Expect %v, got %v", wantLibs, pc.Libs)
+		}
+
+		testdep := path.Join(tmpRaypm, "store", entries(tree)["testdep"])
+		if _, err = os.Stat(path.Join(pkgconfig.Dir(testdep), "testdep.pc")); err == nil {
+			t.Error("'testdep' doesn't give libraries, but has .pc file")
+		}
+
+		// Copied from '$dep/another' by install phase
+		if _, err = os.Stat(path.Join(testdep, "another.pc")); err != nil {
+			t.Error(err)
+		}
+	})
 }
 
 func TestUnistallPackages(t *testing.T) {
@@ -96,6 +148,7 @@ func TestUnistallPackages(t *testing.T) {
 		db       *dbpkg.PkgDb
 	)
 	log.Init(false)
+	host := triple.Host().String()
 
 	tmpRaypm = path.Join(os.TempDir())
 	tmpRaypm, err = os.MkdirTemp(tmpRaypm, "uninstall_test_*")
@@ -113,11 +166,12 @@ func TestUnistallPackages(t *testing.T) {
 	db = dbpkg.NewDb(dbPathJson)
 	defer db.WriteData()
 
-	depTree, err := NewDepTree(tmpRaypm, "testdep", runtime.GOOS, db)
+	depTree, err := NewDepTree(tmpRaypm, "testdep", runtime.GOOS, runtime.GOOS, "", db)
 	if err != nil {
 		t.Errorf("Failed to resolve dependencies:\n%s\n", err)
 		t.FailNow()
 	}
+	e := entries(depTree)
 
 	if err = depTree.Install(); err != nil {
 		t.FailNow()
@@ -127,32 +181,33 @@ func TestUnistallPackages(t *testing.T) {
 	t.Run("uninstall a package, that is dependency for other", func(t *testing.T) {
 		storeBase := path.Join(tmpRaypm, "store")
 		wantFiles := []string{
-			path.Join(storeBase, "testdep"),
-			path.Join(storeBase, "another"),
-			path.Join(storeBase, "testpackage"),
+			path.Join(storeBase, e["testdep"]),
+			path.Join(storeBase, e["another"]),
+			path.Join(storeBase, e["testpackage"]),
 		}
 
 		wantPkgs := dbpkg.PkgsRel{
-			"testdep": {
-				DependsOn: []string{
-					"another", "testpackage",
-				},
+			e["testdep"]: {
+				Target:    host,
+				DependsOn: sortedEntries(e["another"], e["testpackage"]),
 			},
 
-			"testpackage": {
+			e["testpackage"]: {
+				Target: host,
 				RequiredFor: []string{
-					"testdep",
+					e["testdep"],
 				},
 			},
 
-			"another": {
+			e["another"]: {
+				Target: host,
 				RequiredFor: []string{
-					"testdep",
+					e["testdep"],
 				},
 			},
 		}
 
-		localTree, err := NewDepTree(tmpRaypm, "another", runtime.GOOS, db)
+		localTree, err := NewDepTree(tmpRaypm, "another", runtime.GOOS, runtime.GOOS, "", db)
 		if err != nil {
 			t.Errorf("Failed to resolve dependencies: %s\n", err)
 		}
@@ -193,16 +248,16 @@ func TestUnistallPackages(t *testing.T) {
 	t.Run("uninstall one package", func(t *testing.T) {
 		storeBase := path.Join(tmpRaypm, "store")
 		wantFiles := []string{
-			path.Join(storeBase, "another"),
-			path.Join(storeBase, "testpackage"),
+			path.Join(storeBase, e["another"]),
+			path.Join(storeBase, e["testpackage"]),
 		}
 
 		wantPkgs := dbpkg.PkgsRel{
-			"testpackage": {},
-			"another":     {},
+			e["testpackage"]: {Target: host},
+			e["another"]:     {Target: host},
 		}
 
-		localTree, err := NewDepTree(tmpRaypm, "testdep", runtime.GOOS, db)
+		localTree, err := NewDepTree(tmpRaypm, "testdep", runtime.GOOS, runtime.GOOS, "", db)
 		if err != nil {
 			t.Errorf("Failed to resolve dependencies: %s\n", err)
 		}
@@ -224,6 +279,374 @@ func TestUnistallPackages(t *testing.T) {
 	})
 }
 
+func TestKeepUsedPackages(t *testing.T) {
+	log.Init(false)
+
+	tmpRaypm, err := os.MkdirTemp(os.TempDir(), "keep_test_*")
+	if err != nil {
+		t.Errorf("Cannot create temp directory: %s\n", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(tmpRaypm)
+
+	if err = copyTestPkgs(tmpRaypm, "pkgs"); err != nil {
+		t.Errorf("Failed to copy files:\n%s\n", err)
+		t.FailNow()
+	}
+
+	db := dbpkg.NewDb(path.Join(tmpRaypm, "db.json"))
+
+	tree, err := NewDepTree(tmpRaypm, "another", runtime.GOOS, runtime.GOOS, "", db)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	if err = tree.Install(); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	entry, out := tree.Nodes.Entry, tree.Nodes.Vars.Out
+	// Generation uses the package
+	tree.Data.Used = map[string]bool{out: true}
+
+	t.Run("removed package stays in the store", func(t *testing.T) {
+		if err := tree.Uninstall(); err != nil {
+			t.Error(err)
+		}
+
+		if db.IsExists(entry) {
+			t.Errorf("'%s' is still in the database", entry)
+		}
+
+		if _, err := os.Stat(out); err != nil {
+			t.Errorf("Expect kept '%s': %s", out, err)
+		}
+	})
+
+	t.Run("kept package is restored", func(t *testing.T) {
+		// Phases are not run again
+		marker := path.Join(out, "marker")
+		if err := os.WriteFile(marker, nil, 0644); err != nil {
+			t.Error(err)
+		}
+
+		if err := tree.Install(); err != nil {
+			t.Error(err)
+		}
+
+		if !db.IsExists(entry) {
+			t.Errorf("'%s' is not in the database", entry)
+		}
+
+		if _, err := os.Stat(marker); err != nil {
+			t.Errorf("Package was built again: %s", err)
+		}
+	})
+
+	t.Run("garbage collection", func(t *testing.T) {
+		if err := tree.Uninstall(); err != nil {
+			t.Error(err)
+		}
+
+		removed, err := CollectGarbage(tmpRaypm, db, tree.Data.Used)
+		if err != nil || len(removed) != 0 {
+			t.Errorf("Used package is removed: %v (%v)", removed, err)
+		}
+
+		removed, err = CollectGarbage(tmpRaypm, db, nil)
+		if err != nil || !slices.Equal(removed, []string{entry}) {
+			t.Errorf("Expect removed '%s', got %v (%v)", entry, removed, err)
+		}
+
+		if _, err := os.Stat(out); err == nil {
+			t.Errorf("'%s' is not removed", out)
+		}
+	})
+}
+
+func TestStoreEntries(t *testing.T) {
+	log.Init(false)
+
+	tmpRaypm, err := os.MkdirTemp(os.TempDir(), "store_test_*")
+	if err != nil {
+		t.Errorf("Cannot create temp directory: %s\n", err)
+		t.FailNow()
+	}
+
+	if err = copyTestPkgs(tmpRaypm, "pkgs"); err != nil {
+		t.Errorf("Failed to copy files:\n%s\n", err)
+		t.FailNow()
+	}
+
+	db := dbpkg.NewDb(path.Join(tmpRaypm, "db.json"))
+
+	t.Run("different targets have different entries", func(t *testing.T) {
+		native, err := NewDepTree(tmpRaypm, "testdep", "linux", "linux", "", db)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		cross, err := NewDepTree(tmpRaypm, "testdep", "linux", "windows", "", db)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		if native.Nodes.Entry == cross.Nodes.Entry {
+			t.Errorf("Got the same entry for both targets: '%s'", native.Nodes.Entry)
+		}
+
+		// Fetched files, sources and logs are not shared between targets
+		if native.Nodes.Vars.Cache == cross.Nodes.Vars.Cache {
+			t.Errorf("Got the same cache for both targets: '%s'", native.Nodes.Vars.Cache)
+		}
+
+		wantSuffix := "-testdep-1"
+		if !strings.HasSuffix(native.Nodes.Entry, wantSuffix) {
+			t.Errorf("Expect entry ending with '%s', got '%s'", wantSuffix, native.Nodes.Entry)
+		}
+	})
+
+	t.Run("same package has the same entry", func(t *testing.T) {
+		first, err := NewDepTree(tmpRaypm, "testdep", "linux", "linux", "", db)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		second, err := NewDepTree(tmpRaypm, "testdep", "linux", "linux", "", db)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		if first.Nodes.Entry != second.Nodes.Entry {
+			t.Errorf("Entries mismatch: '%s' != '%s'", first.Nodes.Entry, second.Nodes.Entry)
+		}
+	})
+
+	t.Run("custom output is only for the root package", func(t *testing.T) {
+		root, err := NewDepTree(tmpRaypm, "another", "linux", "linux", "", db)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		// 'another' was installed with '-o' before
+		out := path.Join(tmpRaypm, "custom")
+		db.Add(root.Nodes.Entry)
+		db.SetOut(root.Nodes.Entry, out)
+		defer db.Del(root.Nodes.Entry)
+
+		if root, err = NewDepTree(tmpRaypm, "another", "linux", "linux", "", db); err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		if root.Nodes.Vars.Out != out {
+			t.Errorf("Expect '%s', got '%s'", out, root.Nodes.Vars.Out)
+		}
+
+		tree, err := NewDepTree(tmpRaypm, "testdep", "linux", "linux", "", db)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		for _, item := range tree.Nodes.Depends {
+			if want := path.Join(tmpRaypm, "store", item.Entry); item.Vars.Out != want {
+				t.Errorf("Expect dependency in '%s', got '%s'", want, item.Vars.Out)
+			}
+		}
+	})
+}
+
+// Package's name -> store entry
+func entries(tree *Tree) map[string]string {
+	e := make(map[string]string)
+
+	var walk func(*Node)
+	walk = func(dn *Node) {
+		e[dn.Name] = dn.Entry
+		for _, item := range dn.Depends {
+			walk(item)
+		}
+	}
+	walk(tree.Nodes)
+
+	return e
+}
+
+func sortedEntries(items ...string) []string {
+	slices.Sort(items)
+	return items
+}
+
+func TestBuildAndroid(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		return
+	}
+	log.Init(false)
+
+	tmpRaypm, err := os.MkdirTemp(os.TempDir(), "build_test_*")
+	if err != nil {
+		t.Errorf("Cannot create temp directory: %s\n", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(tmpRaypm)
+
+	// Debug key is generated in home directory
+	t.Setenv("HOME", tmpRaypm)
+
+	if err = copyTestPkgs(tmpRaypm, "pkgs"); err != nil {
+		t.Errorf("Failed to copy files:\n%s\n", err)
+		t.FailNow()
+	}
+
+	db := dbpkg.NewDb(path.Join(tmpRaypm, "db.json"))
+	pkgFile := path.Join(tmpRaypm, "pkgs", "androidapp", "package.lua")
+
+	tree, err := NewDepTreeFromFile(tmpRaypm, pkgFile, "linux", "android", db)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	if err = tree.Build(""); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	build := path.Join(tmpRaypm, "pkgs", "androidapp", "build")
+
+	t.Run("library for every ABI", func(t *testing.T) {
+		for abi, goarch := range map[string]string{"arm64-v8a": "arm64", "x86_64": "amd64"} {
+			data, err := os.ReadFile(path.Join(build, "lib", abi, "libandroidapp.so"))
+			if err != nil {
+				t.Error(err)
+				continue
+			}
+
+			if strings.TrimSpace(string(data)) != goarch {
+				t.Errorf("Expect GOARCH '%s' for %s, got '%s'", goarch, abi, data)
+			}
+		}
+	})
+
+	t.Run("apk", func(t *testing.T) {
+		if _, err := os.Stat(path.Join(build, "androidapp.apk")); err != nil {
+			t.Error(err)
+		}
+
+		if !db.IsExists(entries(tree)["ndk"]) {
+			t.Error("Expect 'ndk' to be installed")
+		}
+	})
+}
+
+func TestBuildWeb(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		return
+	}
+	log.Init(false)
+
+	tmpRaypm, err := os.MkdirTemp(os.TempDir(), "build_test_*")
+	if err != nil {
+		t.Errorf("Cannot create temp directory: %s\n", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(tmpRaypm)
+
+	if err = copyTestPkgs(tmpRaypm, "pkgs"); err != nil {
+		t.Errorf("Failed to copy files:\n%s\n", err)
+		t.FailNow()
+	}
+
+	db := dbpkg.NewDb(path.Join(tmpRaypm, "db.json"))
+	project := path.Join(tmpRaypm, "pkgs", "webapp")
+
+	tree, err := NewDepTreeFromFile(tmpRaypm, path.Join(project, "package.lua"), "linux", "web", db)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	if err = tree.Build(""); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	t.Run("emcc from emsdk", func(t *testing.T) {
+		args, err := os.ReadFile(path.Join(project, "emcc_args.txt"))
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		want := "main.c -o " + path.Join(project, "build", "index.html")
+		if !strings.HasPrefix(string(args), want) {
+			t.Errorf("Expect '%s', got '%s'", want, args)
+		}
+	})
+
+	t.Run("shell and assets", func(t *testing.T) {
+		for _, item := range []string{"shell.html", "assets.json"} {
+			if _, err := os.Stat(path.Join(project, "build", item)); err != nil {
+				t.Error(err)
+			}
+		}
+	})
+}
+
+func TestBuildDarwin(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		return
+	}
+	log.Init(false)
+
+	tmpRaypm, err := os.MkdirTemp(os.TempDir(), "build_test_*")
+	if err != nil {
+		t.Errorf("Cannot create temp directory: %s\n", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(tmpRaypm)
+
+	if err = copyTestPkgs(tmpRaypm, "pkgs"); err != nil {
+		t.Errorf("Failed to copy files:\n%s\n", err)
+		t.FailNow()
+	}
+
+	db := dbpkg.NewDb(path.Join(tmpRaypm, "db.json"))
+	project := path.Join(tmpRaypm, "pkgs", "macapp")
+
+	tree, err := NewDepTreeFromFile(tmpRaypm, path.Join(project, "package.lua"), "linux", "darwin", db)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	if err = tree.Build(""); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	exe := path.Join(project, "build", "macapp.app", "Contents", "MacOS", "macapp")
+	data, err := os.ReadFile(exe)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	osxcross := path.Join(tmpRaypm, "store", entries(tree)["osxcross"])
+	want := "darwin " + path.Join(osxcross, "target", "bin", "o64-clang")
+	if strings.TrimSpace(string(data)) != want {
+		t.Errorf("Expect '%s', got '%s'", want, data)
+	}
+}
+
 func copyTestPkgs(dst, src string) (err error) {
 	var (
 		fInfo    os.FileInfo
@@ -283,3 +706,281 @@ func mismatchMaps(expect, got *dbpkg.PkgsRel) string {
 		expect, got,
 	)
 }
+
+func TestParallelInstall(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		return
+	}
+	log.Init(false)
+
+	tmpRaypm, err := os.MkdirTemp(os.TempDir(), "fetch_test_*")
+	if err != nil {
+		t.Errorf("Cannot create temp directory: %s\n", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(tmpRaypm)
+
+	if err = copyTestPkgs(tmpRaypm, "pkgs"); err != nil {
+		t.Errorf("Failed to copy files:\n%s\n", err)
+		t.FailNow()
+	}
+
+	var (
+		mu                    sync.Mutex
+		requests              = map[string]int{}
+		inFlight, maxInFlight int
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		mu.Unlock()
+
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte(r.URL.Path))
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	}))
+	defer server.Close()
+	t.Setenv("RAYPM_TEST_SERVER", server.URL)
+	t.Setenv("RAYPM_TEST_DIR", tmpRaypm) // Packages wait for each other while building
+
+	db := dbpkg.NewDb(path.Join(tmpRaypm, "db.json"))
+	tree, err := NewDepTree(tmpRaypm, "fetchroot", "linux", "linux", "", db)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	tree.Jobs = 4
+
+	events := &recorder{}
+	defer report.Set(report.Set(events))
+
+	if err = tree.Install(); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	if requests["/shared.txt"] != 1 || requests["/root.txt"] != 1 {
+		t.Errorf("Expect one request for every link, got %v", requests)
+	}
+
+	if maxInFlight < 2 {
+		t.Error("Expect parallel downloads")
+	}
+
+	e := entries(tree)
+	for _, item := range []string{"fetcha/shared.txt", "fetchb/shared.txt", "fetchroot/root.txt"} {
+		name, file := path.Split(item)
+		if _, err := os.Stat(path.Join(tmpRaypm, "store", e[path.Clean(name)], file)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	t.Run("events of installation", func(t *testing.T) {
+		kinds := map[report.Kind]int{}
+		phases := map[string]int{}
+		var installed []string
+
+		for _, item := range events.events {
+			kinds[item.Kind]++
+			switch item.Kind {
+			case report.PhaseStarted:
+				phases[item.Package+"/"+item.Phase]++
+			case report.PhaseFinished:
+				phases[item.Package+"/"+item.Phase]--
+			case report.PackageInstalled:
+				installed = append(installed, item.Package)
+			}
+		}
+
+		first := events.events[0]
+		if first.Kind != report.PlanResolved || !reflect.DeepEqual(first.Packages, []string{"fetcha", "fetchb", "fetchroot"}) {
+			t.Errorf("Expect plan first, got %#v", first)
+		}
+
+		// The same link is downloaded once
+		if kinds[report.FetchStarted] != 2 || kinds[report.FetchFinished] != 2 {
+			t.Errorf("Expect 2 downloads, got %v", kinds)
+		}
+
+		if kinds[report.PhaseStarted] == 0 || kinds[report.Error] != 0 {
+			t.Errorf("Wrong events: %v", kinds)
+		}
+
+		for phase, n := range phases {
+			if n != 0 {
+				t.Errorf("Phase '%s' is not finished", phase)
+			}
+		}
+
+		if len(installed) != 3 || installed[2] != "fetchroot" {
+			t.Errorf("Expect fetchroot installed after dependencies, got %v", installed)
+		}
+	})
+}
+
+// Run with -race: independent packages check and change the database at the
+// same time
+func TestInstallIndependent(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		return
+	}
+	log.Init(false)
+
+	tmpRaypm, err := os.MkdirTemp(os.TempDir(), "independent_test_*")
+	if err != nil {
+		t.Errorf("Cannot create temp directory: %s\n", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(tmpRaypm)
+
+	write := func(name, targets string) {
+		dir := path.Join(tmpRaypm, "pkgs", name)
+		data := fmt.Sprintf("Data = { name = %q, version = \"1\", targets = { linux = { %s } } }\n", name, targets)
+		if err := os.MkdirAll(dir, 0754); err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		if err := os.WriteFile(path.Join(dir, "package.lua"), []byte(data), 0644); err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+	}
+
+	var deps []string
+	for i := range 8 {
+		name := fmt.Sprintf("leaf%d", i)
+		write(name, `install_phase = "${mkdir $out/lib}"`)
+		deps = append(deps, fmt.Sprintf("%q", name))
+	}
+	write("root", "dependencies = { "+strings.Join(deps, ", ")+" }")
+
+	db := dbpkg.NewDb(path.Join(tmpRaypm, "db.json"))
+	tree, err := NewDepTree(tmpRaypm, "root", "linux", "linux", "", db)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	tree.Jobs = 8
+
+	if err = tree.Install(); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	if len(db.Pkgs) != 9 {
+		t.Errorf("Expect 9 installed packages, got %d", len(db.Pkgs))
+	}
+}
+
+// Keeps events, Emit calls it under lock
+type recorder struct {
+	events []report.Event
+}
+
+func (r *recorder) Report(e report.Event) {
+	r.events = append(r.events, e)
+}
+
+func TestPhaseLogs(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		return
+	}
+	log.Init(false)
+
+	tmpRaypm, err := os.MkdirTemp(os.TempDir(), "logs_test_*")
+	if err != nil {
+		t.Errorf("Cannot create temp directory: %s\n", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(tmpRaypm)
+
+	if err = copyTestPkgs(tmpRaypm, "pkgs"); err != nil {
+		t.Errorf("Failed to copy files:\n%s\n", err)
+		t.FailNow()
+	}
+
+	echo := task.Echo
+	task.Echo = false
+	defer func() { task.Echo = echo }()
+
+	events := &recorder{}
+	defer report.Set(report.Set(events))
+
+	db := dbpkg.NewDb(path.Join(tmpRaypm, "db.json"))
+	tree, err := NewDepTree(tmpRaypm, "failing", "linux", "linux", "", db)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	if err = tree.Install(); err == nil {
+		t.Error("Expect failed build")
+		t.FailNow()
+	}
+
+	t.Run("error is typed and reported", func(t *testing.T) {
+		if !errors.Is(err, errs.Phase) || errs.ExitCode(err) != errs.ExitPhase {
+			t.Errorf("Expect phase error, got %v", err)
+		}
+
+		// The error event is shown already
+		if !errs.IsReported(err) {
+			t.Errorf("Expect reported error: %v", err)
+		}
+	})
+
+	t.Run("output of commands is in logs", func(t *testing.T) {
+		logs, err := PhaseLogs(tmpRaypm, tree.Nodes.Entry)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		want := []string{
+			path.Join(LogsDir(tmpRaypm, tree.Nodes.Entry), "prepare.log"),
+			path.Join(LogsDir(tmpRaypm, tree.Nodes.Entry), "build.log"),
+		}
+		if !reflect.DeepEqual(logs, want) {
+			t.Errorf("Expect %v, got %v", want, logs)
+			t.FailNow()
+		}
+
+		data, err := os.ReadFile(logs[1])
+		if err != nil {
+			t.Error(err)
+		}
+
+		for _, item := range []string{"# sh -c 'echo compiling", "compiling\n", "broken\n", "# build phase failed after"} {
+			if !strings.Contains(string(data), item) {
+				t.Errorf("Expect '%s' in log:\n%s", item, data)
+			}
+		}
+	})
+
+	t.Run("run is finished with error", func(t *testing.T) {
+		last := events.events[len(events.events)-1]
+		if last.Kind != report.RunFinished || last.Error == "" {
+			t.Errorf("Expect failed run, got %#v", last)
+		}
+	})
+
+	t.Run("tail", func(t *testing.T) {
+		pth := path.Join(tmpRaypm, "tail.txt")
+		if err := os.WriteFile(pth, []byte("1\n2\n3\n4\n"), 0644); err != nil {
+			t.Error(err)
+		}
+
+		for n, want := range map[int]string{1: "4", 2: "3\n4", 10: "1\n2\n3\n4"} {
+			if got, err := tail(pth, n); err != nil || got != want {
+				t.Errorf("Expect %q for %d lines, got %q, %v", want, n, got, err)
+			}
+		}
+	})
+}
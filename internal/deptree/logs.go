@@ -0,0 +1,99 @@
+package deptree
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Logs of the package's phases: 'cache/<store entry>/logs'
+func LogsDir(raypmPath, entry string) string {
+	return path.Join(raypmPath, "cache", entry, "logs")
+}
+
+// Output of commands of the phase goes to '<phase>.log', android builds have
+// a log for every ABI, like 'build-arm64-v8a.log'. Log of the previous run is
+// replaced
+func (dn *Node) openLog(phase string) (f *os.File, err error) {
+	dir := LogsDir(dn.Data.BasePath, dn.Entry)
+	if err = os.MkdirAll(dir, 0754); err != nil {
+		return
+	}
+
+	name := phase
+	if dn.Vars.Abi != "" {
+		name += "-" + dn.Vars.Abi
+	}
+
+	return os.Create(path.Join(dir, name+".log"))
+}
+
+// Paths of the package's logs in order of phases
+func PhaseLogs(raypmPath, entry string) (logs []string, err error) {
+	dir := LogsDir(raypmPath, entry)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	for _, item := range entries {
+		if !item.IsDir() && strings.HasSuffix(item.Name(), ".log") {
+			logs = append(logs, path.Join(dir, item.Name()))
+		}
+	}
+
+	order := func(pth string) int {
+		phase, _, _ := strings.Cut(strings.TrimSuffix(path.Base(pth), ".log"), "-")
+		if i := slices.Index(knownPhases, phase); i >= 0 {
+			return i
+		}
+		return len(knownPhases)
+	}
+
+	slices.SortStableFunc(logs, func(a, b string) int {
+		if d := order(a) - order(b); d != 0 {
+			return d
+		}
+		return strings.Compare(a, b)
+	})
+
+	return
+}
+
+// Last 'n' lines of the file, it's read from the end
+func tail(pth string, n int) (lines string, err error) {
+	f, err := os.Open(pth)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return
+	}
+
+	const window = 64 * 1024
+	offset := max(info.Size()-window, 0)
+	data := make([]byte, info.Size()-offset)
+	if _, err = f.ReadAt(data, offset); err != nil && err != io.EOF {
+		return
+	}
+	err = nil
+
+	data = bytes.TrimRight(data, "\n")
+	for i := len(data) - 1; i >= 0; i-- {
+		if data[i] == '\n' {
+			if n--; n == 0 {
+				data = data[i+1:]
+				break
+			}
+		}
+	}
+
+	return string(data), nil
+}
@@ -4,71 +4,116 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"path/filepath"
 	"raypm/internal/dbpkg"
-	"raypm/internal/pkginfo"
+	"raypm/internal/errs"
 	"raypm/internal/pkglua"
 	"raypm/internal/vars"
+	"raypm/pkg/report"
 	log "raypm/pkg/slog"
 )
 
 type Node struct {
 	Data    *PkgData
 	Db      *dbpkg.PkgDb
-	Pkg     *pkginfo.Package
-	PkgLua  *pkglua.Package
+	Name    string
+	Pkg     *pkglua.Package
 	Depends []*Node // If len(Depends) is 0, we reach the end
+	// Hash of package.lua, version, target, host and hashes of dependencies
+	Hash string
+	// Name of the package in the store and in the database:
+	// '<hash>-<name>-<version>'
+	Entry string
+	// Custom $out, empty if the package is installed to the store
+	OutputPath string
 	// Predefined variables
 	Vars *vars.Vars
+	// Directory with package.lua, 'src_path' and 'build_path' are relative to it
+	Dir string
+	// Fetch phase is already done by the tree, see fetchAll
+	fetched bool
 }
 
-func NewNode(data *PkgData, db *dbpkg.PkgDb, internalName string) (depNode *Node, err error) {
+func NewNode(data *PkgData, db *dbpkg.PkgDb, internalName, outputPath string) (depNode *Node, err error) {
+	pkgFile := path.Join(data.PkgsPath, internalName, "package.lua")
+	if _, err = os.Stat(pkgFile); err != nil {
+		err = errs.Wrap(errs.Resolution, "PackageNotFound", err, "'%s'", internalName)
+		return
+	}
+
+	return newNodeFromFile(data, db, pkgFile, internalName, outputPath)
+}
+
+// If internalName is empty, it's taken from the package file
+func newNodeFromFile(data *PkgData, db *dbpkg.PkgDb, pkgFile, internalName, outputPath string) (depNode *Node, err error) {
 	// Creates simple dependency node
 	depNode = &Node{
 		Data: data,
-		Vars: &vars.Vars{},
 		Db:   db,
+		Name: internalName,
 	}
 
-	depNode.Vars = vars.NewVars(data.BasePath, internalName)
-
-	log.Debug("Vars:\n%v", depNode.Vars)
-
-	internal := &pkglua.Package{}
-
-	log.Debug("Creating package item '%s'", internalName)
-	internal, err = pkglua.NewPackage(
-		path.Join(data.PkgsPath, internalName),
-		data.Host,
-		data.Target,
-	)
+	if depNode.Dir, err = filepath.Abs(path.Dir(pkgFile)); err != nil {
+		return
+	}
 
-	if err != nil {
+	log.Debug("Creating package item '%s'", pkgFile)
+	if depNode.Pkg, err = pkglua.NewPackage(pkgFile, data.Host.String(), data.Target.String()); err != nil {
 		return
 	}
 
+	if depNode.Name == "" {
+		depNode.Name = depNode.Pkg.MData["name"]
+		internalName = depNode.Name
+	}
+
 	log.Debugln("Looking for dependencies...")
-	for _, item := range internal.Dependendencies {
+	for _, item := range depNode.Pkg.TargetSpec["dependencies"] {
 		log.Debug("Found '%s', appending to list", item)
 		if err = depNode.Append(depNode.Data, item); err != nil {
 			return
 		}
-		depNode.Vars.Dep = append(depNode.Vars.Dep, item)
 	}
 	log.Debug("Success fetching dependencies for package '%s'", internalName)
 
-	depNode.Pkg = internal
+	if err = depNode.countHash(pkgFile); err != nil {
+		return
+	}
+	depNode.Entry = storeEntry(depNode.Hash, internalName, depNode.Pkg.MData["version"])
+
+	depNode.OutputPath = outputPath
+
+	depNode.Vars = vars.NewVars(data.BasePath, internalName, depNode.Entry, outputPath)
+	// GOOS, GOARCH and cross compiler of the target, '${setenv}' overrides them
+	depNode.Vars.Env = data.Target.Env(data.Host)
+
+	log.Debug("Vars:\n%v", depNode.Vars)
 
 	return
 }
 
+// Root package could be installed with '-o' before, so it's removed and
+// found there. Dependencies always go to the store, even if they were
+// installed with '-o' as a root package
+func (dn *Node) useStoredOut() {
+	if dn.OutputPath != "" {
+		return
+	}
+
+	if out := dn.Db.GetOut(dn.Entry); out != "" {
+		dn.OutputPath = out
+		dn.Vars.Out = out
+	}
+}
+
 // Expand dependency nodes array
 func (dn *Node) Append(data *PkgData, internalName string) (err error) {
 
 	localNode := &Node{}
 
 	log.Debug("Creating node '%s'", internalName)
-	if localNode, err = NewNode(dn.Data, dn.Db, internalName); err != nil {
-		err = fmt.Errorf("Failed to create node: %s\n", err)
+	if localNode, err = NewNode(dn.Data, dn.Db, internalName, ""); err != nil {
+		err = errs.Wrap(errs.Resolution, "DependencyFailed", err, "'%s' of '%s'", internalName, dn.Name)
 		return
 	}
 
@@ -96,65 +141,154 @@ func (dn *Node) InstallNode() (err error) {
 		return
 	}
 
-	inDb, inStore := checkExisting(dn.Pkg.Name, dn.Db, dn.Vars.Out)
-
-	if inDb && inStore {
-		log.Info("Package '%s' already installed", dn.Vars.Out)
-		return
-	} else if inDb != inStore {
-		log.Errorln(
-			"Seems there was an error while installation/uninstallation packages:",
-		)
-		err = fmt.Errorf("DatabaseError")
-		return
+	if installed, err := dn.installed(); installed || err != nil {
+		return err
 	}
 
 	for _, item := range dn.Depends {
 		if err = item.InstallNode(); err != nil {
 			return
 		}
-		dn.Db.Add(dn.Pkg.Name)
-		dn.Db.AddDep(dn.Pkg.Name, item.Pkg.Name)
 	}
 
-	log.Infoln("Installing", dn.Pkg.Name)
+	return dn.install()
+}
 
-	if err = fetchPhase(&dn.Pkg.FetchPhase, dn.Vars); err != nil {
-		dn.Db.Del(dn.Pkg.Name)
-		return
+// Logger with package and target fields
+func (dn *Node) logger() *log.Logger {
+	return log.With("package", dn.Name, "target", dn.Data.Target.String())
+}
+
+// Installation or uninstallation was interrupted
+func (dn *Node) databaseError(inDb bool) error {
+	if inDb {
+		return errs.New(errs.Database, "DatabaseError", "'%s' is in the database, but not in '%s'", dn.Entry, dn.Vars.Out)
 	}
+	return errs.New(errs.Database, "DatabaseError", "'%s' exists, but it's not in the database", dn.Vars.Out)
+}
 
-	if err = unpackPhase(&dn.Pkg.UnpackPhase, dn.Vars); err != nil {
-		dn.Db.Del(dn.Pkg.Name)
-		return
+func (dn *Node) installed() (ok bool, err error) {
+	inDb, inStore := checkExisting(dn.Entry, dn.Db, dn.Vars.Out)
+
+	if inDb && inStore {
+		dn.logger().Info("Package '%s' already installed", dn.Vars.Out)
+		return true, nil
+	} else if inDb != inStore && !dn.restorable() {
+		err = dn.databaseError(inDb)
+	}
+
+	return
+}
+
+// Package was removed, but generations use its directory, so it's kept in
+// the store and could be installed again without building
+func (dn *Node) restorable() bool {
+	if !dn.Data.Used[dn.Vars.Out] || dn.Db.IsExists(dn.Entry) {
+		return false
 	}
 
-	if err = taskPhase("build", &dn.Pkg.BuildPhase, dn.Vars); err != nil {
-		dn.Db.Del(dn.Pkg.Name)
+	_, err := os.Stat(dn.Vars.Out)
+	return err == nil
+}
+
+// Installs the package itself, dependencies must be installed before
+func (dn *Node) install() (err error) {
+	if installed, err := dn.installed(); installed || err != nil {
+		return err
+	}
+
+	if dn.restorable() {
+		dn.logger().Info("Package '%s' is restored from '%s'", dn.Name, dn.Vars.Out)
+	} else if err = dn.build(); err != nil {
 		return
 	}
 
+	dn.Db.Add(dn.Entry)
+	for _, item := range dn.Depends {
+		dn.Db.AddDep(dn.Entry, item.Entry)
+	}
+	dn.Db.AddIndex(dn.Name, dn.Entry)
+	dn.Db.SetTarget(dn.Entry, dn.Data.Target.String())
+	if dn.OutputPath != "" {
+		dn.Db.SetOut(dn.Entry, dn.OutputPath)
+	}
+
+	report.Emit(report.Event{Kind: report.PackageInstalled, Package: dn.Name})
+	return
+}
+
+// Runs phases of the package and writes its .pc file to $out
+func (dn *Node) build() (err error) {
+	for _, phase := range []string{"fetch", "unpack", "prepare", "build"} {
+		if phase == "fetch" && dn.fetched {
+			continue
+		}
+
+		if err = dn.runPhase(phase); err != nil {
+			return
+		}
+	}
+
 	outDir := dn.Vars.Out
 	if err = os.MkdirAll(outDir, 0754); err != nil {
-		log.Error("Failed to create directory '%s': %s", outDir, err)
-		dn.Db.Del(dn.Pkg.Name)
+		err = errs.Wrap(errs.Phase, "CannotCreateFolder", err, "'%s'", outDir)
 		return
 	}
 
-	if err = taskPhase("install", &dn.Pkg.InstallPhase, dn.Vars); err != nil {
-		dn.Db.Del(dn.Pkg.Name)
+	if err = dn.runPhase("install"); err != nil {
 		return
 	}
 
-	log.Info("Package '%s' installed", dn.Pkg.Name)
-
-	dn.Db.Add(dn.Pkg.Name)
-
+	err = dn.writePkgConfig()
 	return
 }
 
-// TODO
-func (dn *Node) BuildNode() (err error) {
+// Builds the package in place: sources are in 'src_path', result goes to
+// 'build_path' or to 'outputPath'. Dependencies are installed to the store
+func (dn *Node) BuildNode(outputPath string) (err error) {
+	if dn.Pkg == nil {
+		return
+	}
+
+	for _, item := range dn.Depends {
+		if err = item.InstallNode(); err != nil {
+			return
+		}
+	}
+
+	dn.Vars.Src = inDir(dn.Dir, dn.Pkg.MData["src_path"], ".")
+	dn.Vars.Out = outputPath
+	if dn.Vars.Out == "" {
+		dn.Vars.Out = inDir(dn.Dir, dn.Pkg.MData["build_path"], "build")
+	}
+
+	if err = os.MkdirAll(dn.Vars.Out, 0754); err != nil {
+		err = errs.Wrap(errs.Phase, "CannotCreateFolder", err, "'%s'", dn.Vars.Out)
+		return
+	}
+
+	dn.logger().Infoln("Building", dn.Name)
+
+	if err = dn.runPhase("prepare"); err != nil {
+		return
+	}
+
+	switch dn.Data.Target.OS {
+	case "android":
+		err = dn.buildAndroid()
+	case "web":
+		err = dn.buildWeb()
+	case "darwin":
+		err = dn.buildDarwin()
+	default:
+		err = dn.runPhase("build")
+	}
+
+	if err != nil {
+		return
+	}
+
+	dn.logger().Info("Package '%s' built to '%s'", dn.Name, dn.Vars.Out)
 	return
 }
 
@@ -164,42 +298,86 @@ func (dn *Node) UninstallNode() (err error) {
 		return
 	}
 
-	inDb, inStore := checkExisting(dn.Pkg.Name, dn.Db, dn.Vars.Out)
+	inDb, inStore := checkExisting(dn.Entry, dn.Db, dn.Vars.Out)
 
 	if inDb != inStore {
-		log.Errorln(
-			"Seems there was an error while installation/uninstallation packages:",
-		)
-		err = fmt.Errorf("DatabaseError")
+		err = dn.databaseError(inDb)
 		return
 	} else if !inDb && !inStore {
-		log.Warn("Package '%s' is not installed", dn.Pkg.Name)
+		log.Warn("Package '%s' is not installed", dn.Name)
+		if other := dn.Db.Lookup(dn.Name); len(other) > 0 {
+			log.Warn("Installed builds of '%s': %v", dn.Name, other)
+		}
 		return
 	}
 
-	if err = dn.Db.Del(dn.Pkg.Name); err != nil {
+	if err = dn.Db.Del(dn.Entry); err != nil {
 		return
 	}
 
-	log.Infoln("Uninstalling", dn.Pkg.Name)
-	if err = taskPhase("uninstall", &dn.Pkg.UninstallPhase, dn.Vars); err != nil {
+	dn.logger().Infoln("Uninstalling", dn.Name)
+	if err = dn.runPhase("uninstall"); err != nil {
 		return
 	}
 
-	if err = os.RemoveAll(dn.Vars.Out); err != nil {
-		log.Errorln("Failed to remove package's directory")
-		log.Errorln(err)
+	// Switching to these generations needs the directory, 'raypm gc' removes
+	// it later
+	if dn.Data.Used[dn.Vars.Out] {
+		dn.logger().Info("Package '%s' is kept in the store for generations", dn.Name)
+	} else if err = os.RemoveAll(dn.Vars.Out); err != nil {
+		err = errs.Wrap(errs.Phase, "CannotRemovePackage", err, "'%s'", dn.Vars.Out)
 		return
 	}
 
 	os.RemoveAll(dn.Vars.Cache)
 
-	log.Info("Package '%s' removed", dn.Pkg.Name)
+	dn.logger().Info("Package '%s' removed", dn.Name)
+	return
+}
+
+func (dn *Node) outputs(outs map[string]string) {
+	for _, item := range dn.Depends {
+		item.outputs(outs)
+	}
+
+	outs[dn.Name] = dn.Vars.Out
+}
+
+// '$dep/<name>' is a link to the directory of the dependency, so package
+// files don't need to know store entries
+func (dn *Node) linkDeps() (err error) {
+	if err = os.RemoveAll(dn.Vars.Dep); err != nil || len(dn.Depends) == 0 {
+		return
+	}
+
+	if err = os.MkdirAll(dn.Vars.Dep, 0754); err != nil {
+		return
+	}
+
+	for _, item := range dn.Depends {
+		if err = os.Symlink(item.Vars.Out, path.Join(dn.Vars.Dep, item.Name)); err != nil {
+			return
+		}
+	}
+
 	return
 }
 
-func checkExisting(pkgName string, db *dbpkg.PkgDb, dir string) (inDataBase, inStoreDir bool) {
-	inDataBase = db.IsExists(pkgName)
+// Resolves 'pth' from package's directory, 'def' is used for empty path
+func inDir(dir, pth, def string) string {
+	if pth == "" {
+		pth = def
+	}
+
+	if filepath.IsAbs(pth) {
+		return pth
+	}
+
+	return path.Join(dir, pth)
+}
+
+func checkExisting(entry string, db *dbpkg.PkgDb, dir string) (inDataBase, inStoreDir bool) {
+	inDataBase = db.IsExists(entry)
 
 	_, err := os.Stat(dir)
 	inStoreDir = err == nil
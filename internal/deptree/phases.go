@@ -1,70 +1,92 @@
 package deptree
 
 import (
-	"path"
-	"raypm/internal/phases"
-	"raypm/internal/pkginfo"
+	"fmt"
+	"raypm/internal/errs"
 	"raypm/internal/task"
-	"raypm/internal/vars"
-	log "raypm/pkg/slog"
-	"strings"
+	"raypm/pkg/report"
+	"slices"
+	"time"
 )
 
-func fetchPhase(data *[]pkginfo.FetchPath, vv *vars.Vars) (err error) {
-	for _, item := range *data {
-		log.Info("Getting '%s'", item.From)
-		to := ""
-
-		expanded := vv.ExpandVars(&item.To)
+var knownPhases = []string{
+	"fetch", "unpack", "prepare", "build", "install", "uninstall",
+}
 
-		for _, dest := range expanded {
-			to = path.Join(to, dest)
-		}
+// Lines of the log, that are shown after failure, if output of commands is
+// not shown
+const failedLines = 20
 
-		if err = phases.GetFile(item.From, to); err != nil {
-			log.Errorln("Fetch phase failed:")
-			log.Error("Failed to get '%s': %s", item.From, err)
-			break
-		}
+func (dn *Node) runPhase(phase string) (err error) {
+	if !slices.Contains(knownPhases, phase) {
+		err = errs.New(errs.Phase, "UnknownPhase", "'%s'", phase)
+		return
 	}
 
-	return
-}
+	items := dn.Pkg.TargetSpec[phase+"_phase"]
+	if len(items) == 0 {
+		return
+	}
 
-func unpackPhase(data *[]pkginfo.UnpackTask, vv *vars.Vars) (err error) {
-	for _, item := range *data {
-		from := path.Join(vv.ExpandVars(&item.Src)...)
-		log.Debug("Expanded from '%v'\nto '%v'", item.Src, from)
+	if err = dn.linkDeps(); err != nil {
+		return errs.Wrap(errs.Phase, "CannotLinkDependencies", err, "'%s'", dn.Vars.Dep)
+	}
 
-		log.Info("Unpacking '%s'", from)
+	l := dn.logger().With("phase", phase)
+	start := time.Now()
+	report.Emit(report.Event{Kind: report.PhaseStarted, Package: dn.Name, Phase: phase})
 
-		to := path.Join(vv.ExpandVars(&item.Dest)...)
-		log.Debug("Expanded from '%v'\nto '%v'", item.Dest, to)
+	f, err := dn.openLog(phase)
+	if err != nil {
+		l.Warn("Failed to create log of the phase: %s", err)
+		err = nil
+	} else {
+		dn.Vars.Log = f
+		defer func() {
+			dn.Vars.Log = nil
+			f.Close()
+		}()
+	}
 
-		err = phases.Unpack(item.Type, from, to, item.SelectedItems)
+	for _, item := range items {
+		l.Debug("%s: %s", phase, item)
+		if f != nil {
+			fmt.Fprintf(f, "# %s\n", item)
+		}
 
-		if err != nil {
-			log.Errorln("Unpack phase failed:")
-			log.Error("Failed to unpack '%s': '%s", from, err)
+		if err = task.Do(phase, item, dn.Vars, dn.Pkg.TargetSpec); err != nil {
+			report.Fail(dn.Name, phase, err)
+			err = errs.Reported(errs.Wrap(errs.Phase, "PhaseFailed", err, "%s phase of '%s'", phase, dn.Name))
 			break
 		}
 	}
-	return
-}
 
-func taskPhase(phase string, data *[]pkginfo.CommandTask, vv *vars.Vars) (err error) {
+	elapsed := time.Since(start)
+	finished := report.Event{
+		Kind:    report.PhaseFinished,
+		Package: dn.Name,
+		Phase:   phase,
+		Elapsed: elapsed.Seconds(),
+	}
 
-	if !(phase == "build" || phase == "install" || phase == "uninstall") {
-		log.Error("Uknown phase '%s'", phase)
-		return
+	if err != nil {
+		finished.Error = err.Error()
 	}
 
-	for _, item := range *data {
-		if err = task.Do(phase, item, vv); err != nil {
-			log.Error("%s phase failed:", strings.ToTitle(phase))
-			log.Errorln(err)
-			break
+	if f != nil {
+		if err != nil {
+			fmt.Fprintf(f, "# %s phase failed after %s: %s\n", phase, elapsed.Round(time.Millisecond), err)
+		} else {
+			fmt.Fprintf(f, "# %s phase finished in %s\n", phase, elapsed.Round(time.Millisecond))
+		}
+
+		if err != nil && !task.Echo {
+			if lines, lerr := tail(f.Name(), failedLines); lerr == nil {
+				l.Error("Last lines of '%s':\n%s", f.Name(), lines)
+			}
 		}
 	}
+
+	report.Emit(finished)
 	return
 }
@@ -0,0 +1,75 @@
+package deptree
+
+import (
+	"path"
+	"path/filepath"
+	"raypm/internal/pkgconfig"
+	log "raypm/pkg/slog"
+	"strings"
+)
+
+func (dn *Node) pkgConfigFile() string {
+	return path.Join(pkgconfig.Dir(dn.Vars.Out), dn.Name+".pc")
+}
+
+func (dn *Node) hasPkgConfig() bool {
+	spec := dn.Pkg.TargetSpec
+	return len(spec["include_dirs"]) > 0 || len(spec["libs"]) > 0
+}
+
+// Writes .pc file, if package gives headers or libraries for the target.
+// Relative directories are taken from $out
+func (dn *Node) writePkgConfig() (err error) {
+	if !dn.hasPkgConfig() {
+		return
+	}
+
+	spec := dn.Pkg.TargetSpec
+	pc := &pkgconfig.Pc{
+		Name:        dn.Name,
+		Description: dn.Pkg.MData["description"],
+		Version:     dn.Pkg.MData["version"],
+		Vars:        [][2]string{{"prefix", dn.Vars.Out}},
+	}
+
+	includeDirs := spec["include_dirs"]
+	for _, item := range dn.Vars.ExpandVars(&includeDirs) {
+		pc.Cflags = append(pc.Cflags, "-I"+withPrefix(item))
+	}
+
+	libDirs := spec["lib_dirs"]
+	if len(libDirs) == 0 && len(spec["libs"]) > 0 {
+		libDirs = []string{"lib"}
+	}
+
+	for _, item := range dn.Vars.ExpandVars(&libDirs) {
+		pc.Libs = append(pc.Libs, "-L"+withPrefix(item))
+	}
+
+	for _, item := range spec["libs"] {
+		// Allows things like '-framework Cocoa' or '-pthread'
+		if strings.HasPrefix(item, "-") {
+			pc.Libs = append(pc.Libs, item)
+		} else {
+			pc.Libs = append(pc.Libs, "-l"+item)
+		}
+	}
+
+	for _, item := range dn.Depends {
+		if item.hasPkgConfig() {
+			pc.Requires = append(pc.Requires, item.Name)
+		}
+	}
+
+	log.Debug("Writing '%s'", dn.pkgConfigFile())
+	err = pkgconfig.Write(dn.pkgConfigFile(), pc)
+	return
+}
+
+func withPrefix(dir string) string {
+	if filepath.IsAbs(dir) {
+		return dir
+	}
+
+	return "${prefix}/" + dir
+}
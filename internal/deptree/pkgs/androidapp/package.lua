@@ -0,0 +1,26 @@
+local name = "androidapp"
+local version = "0.1"
+local description = "application built for Android in tests"
+
+local targets = {
+  android = {
+    cross_linux = {
+      dependencies = { "ndk" },
+      abis = { "arm64-v8a", "x86_64" },
+      app_id = "com.raypm.test",
+      build_phase = [[
+        ${setenv LIB $out/lib/$abi/libandroidapp.so}
+        sh -c "echo $GOARCH > $LIB"
+      ]],
+    },
+  },
+}
+
+Data = {
+  name = name,
+  version = version,
+  description = description,
+  src_path = ".",
+  build_path = "build",
+  targets = targets,
+}
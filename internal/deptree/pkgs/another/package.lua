@@ -0,0 +1,20 @@
+local name = "another"
+local version = "1"
+local description = "Another"
+
+local targets = {
+  linux = {
+    include_dirs = { "include" },
+    libs = { "another", "m" },
+  },
+  windows = {},
+}
+
+targets.windows.cross_linux = targets.windows
+
+Data = {
+  name = name,
+  version = version,
+  description = description,
+  targets = targets,
+}
@@ -0,0 +1,22 @@
+local name = "emsdk"
+local version = "4.0"
+local description = "fake Emscripten SDK for tests"
+
+local targets = {
+  web = {
+    cross_linux = {
+      install_phase = [[
+        mkdir -p $out/upstream/emscripten
+        cp $pkg/emcc $out/upstream/emscripten/emcc
+        chmod +x $out/upstream/emscripten/emcc
+      ]],
+    },
+  },
+}
+
+Data = {
+  name = name,
+  version = version,
+  description = description,
+  targets = targets,
+}
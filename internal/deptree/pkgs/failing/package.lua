@@ -0,0 +1,17 @@
+local name = "failing"
+local version = "1"
+local description = "package with broken build"
+
+local targets = {
+  linux = {
+    prepare_phase = "sh -c 'echo configuring'",
+    build_phase = "sh -c 'echo compiling; echo broken >&2; exit 3'",
+  },
+}
+
+Data = {
+  name = name,
+  version = version,
+  description = description,
+  targets = targets,
+}
@@ -0,0 +1,27 @@
+local name = "fetchb"
+local version = "1"
+local description = "package with shared download"
+
+local server = os.getenv("RAYPM_TEST_SERVER") or "http://localhost"
+local marks = os.getenv("RAYPM_TEST_DIR") or "/tmp"
+
+-- Waits for the other package, so it's built only in parallel with it
+local rendezvous = string.format(
+  "sh -c 'touch %s/%s; for i in $(seq 50); do test -e %s/%s && exit 0; sleep 0.1; done; exit 1'",
+  marks, "b", marks, "a"
+)
+
+local targets = {
+  linux = {
+    fetch_phase = "${get " .. server .. "/shared.txt shared.txt}",
+    build_phase = rendezvous,
+    install_phase = "${copy $fetch/shared.txt $out/shared.txt}",
+  },
+}
+
+Data = {
+  name = name,
+  version = version,
+  description = description,
+  targets = targets,
+}
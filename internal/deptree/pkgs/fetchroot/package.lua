@@ -0,0 +1,20 @@
+local name = "fetchroot"
+local version = "1"
+local description = "package for testing parallel fetching"
+
+local server = os.getenv("RAYPM_TEST_SERVER") or "http://localhost"
+
+local targets = {
+  linux = {
+    dependencies = { "fetcha", "fetchb" },
+    fetch_phase = "${get " .. server .. "/root.txt root.txt}",
+    install_phase = "${copy $fetch/root.txt $out/root.txt}",
+  },
+}
+
+Data = {
+  name = name,
+  version = version,
+  description = description,
+  targets = targets,
+}
@@ -0,0 +1,24 @@
+local name = "macapp"
+local version = "0.1"
+local description = "application built for macOS in tests"
+
+local targets = {
+  darwin = {
+    cross_linux = {
+      dependencies = { "osxcross" },
+      bundle_id = "com.raypm.macapp",
+      build_phase = [[
+        sh -c "echo $GOOS $CC > $out/macapp"
+      ]],
+    },
+  },
+}
+
+Data = {
+  name = name,
+  version = version,
+  description = description,
+  src_path = ".",
+  build_path = "build",
+  targets = targets,
+}
@@ -15,7 +15,7 @@ local targets = {
 
   windows = {
     fetch_phase = string.format("${get %s %s}", link_to_devkit, devkit_file),
-    unpack_phase = string.format("${unpack 7z %s %s}", devkit_file, devkit_dir),
+    unpack_phase = string.format("${unpack %s %s}", devkit_file, devkit_dir),
     install_phase = string.format("${copy %s $out}", devkit_dir),
   },
 }
@@ -0,0 +1,23 @@
+local name = "ndk"
+local version = "27"
+local description = "fake Android NDK with compilers for tests"
+
+local bin = "$out/toolchains/llvm/prebuilt/linux-x86_64/bin"
+
+local targets = {
+  android = {
+    cross_linux = {
+      install_phase = string.format([[
+        mkdir -p %s
+        touch %s/aarch64-linux-android29-clang %s/x86_64-linux-android29-clang
+      ]], bin, bin, bin),
+    },
+  },
+}
+
+Data = {
+  name = name,
+  version = version,
+  description = description,
+  targets = targets,
+}
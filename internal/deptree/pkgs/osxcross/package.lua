@@ -0,0 +1,21 @@
+local name = "osxcross"
+local version = "1.5"
+local description = "fake osxcross toolchain for tests"
+
+local targets = {
+  darwin = {
+    cross_linux = {
+      install_phase = [[
+        mkdir -p $out/target/bin
+        touch $out/target/bin/o64-clang $out/target/bin/o64-clang++
+      ]],
+    },
+  },
+}
+
+Data = {
+  name = name,
+  version = version,
+  description = description,
+  targets = targets,
+}
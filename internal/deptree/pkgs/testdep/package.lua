@@ -0,0 +1,23 @@
+local name = "testdep"
+local version = "1"
+local description = "package for testing dependencies"
+
+local targets = {
+  linux = {
+    dependencies = { "testpackage", "another" },
+    install_phase = "${copy $dep/another/.raypm/pkgconfig/another.pc $out/another.pc}",
+  },
+}
+
+targets.windows = {
+  dependencies = targets.linux.dependencies,
+}
+
+targets.windows.cross_linux = targets.windows
+
+Data = {
+  name = name,
+  version = version,
+  description = description,
+  targets = targets,
+}
@@ -0,0 +1,17 @@
+local name = "testpackage"
+local version = "1"
+local description = "test package"
+
+local targets = {
+  linux = {},
+  windows = {},
+}
+
+targets.windows.cross_linux = targets.windows
+
+Data = {
+  name = name,
+  version = version,
+  description = description,
+  targets = targets,
+}
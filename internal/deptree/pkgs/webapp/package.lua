@@ -0,0 +1,24 @@
+local name = "webapp"
+local version = "0.1"
+local description = "game built for web in tests"
+
+local targets = {
+  web = {
+    cross_linux = {
+      dependencies = { "emsdk" },
+      assets = { "resources" },
+      build_phase = [[
+        emcc main.c -o $out/index.html --shell-file $out/shell.html
+      ]],
+    },
+  },
+}
+
+Data = {
+  name = name,
+  version = version,
+  description = description,
+  src_path = ".",
+  build_path = "build",
+  targets = targets,
+}
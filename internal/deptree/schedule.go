@@ -0,0 +1,123 @@
+package deptree
+
+import (
+	"context"
+	"raypm/pkg/report"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Nodes, that are not installed yet, dependencies go first. Node with the
+// same entry is taken once
+func (dn *Node) plan(nodes []*Node, seen map[string]bool) []*Node {
+	if dn.Pkg == nil || seen[dn.Entry] {
+		return nodes
+	}
+	seen[dn.Entry] = true
+
+	for _, item := range dn.Depends {
+		nodes = item.plan(nodes, seen)
+	}
+
+	if inDb, inStore := checkExisting(dn.Entry, dn.Db, dn.Vars.Out); !inDb || !inStore {
+		nodes = append(nodes, dn)
+	}
+
+	return nodes
+}
+
+// Runs fetch phases of nodes with 'jobs' workers. After the first error other
+// fetches are not started
+func fetchAll(nodes []*Node, jobs int) error {
+	g, ctx := errgroup.WithContext(context.Background())
+	g.SetLimit(max(jobs, 1))
+
+	for _, item := range nodes {
+		if len(item.Pkg.TargetSpec["fetch_phase"]) == 0 || item.restorable() {
+			continue
+		}
+
+		g.Go(func() (err error) {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			if err = item.runPhase("fetch"); err == nil {
+				item.fetched = true
+			}
+			return
+		})
+	}
+
+	return g.Wait()
+}
+
+// Installs planned nodes: a node starts, when its dependencies are installed,
+// and at most 'jobs' nodes are installed at the same time. Output of commands
+// is prefixed with package's name, if there are several jobs
+func installAll(nodes []*Node, jobs int) error {
+	var (
+		done   = make(map[string]chan struct{}, len(nodes))
+		failed sync.Map // Entries, that are failed or skipped
+		slots  = make(chan struct{}, max(jobs, 1))
+	)
+
+	for _, item := range nodes {
+		done[item.Entry] = make(chan struct{})
+	}
+
+	g, ctx := errgroup.WithContext(context.Background())
+
+	for _, item := range nodes {
+		g.Go(func() (err error) {
+			defer close(done[item.Entry])
+
+			for _, dep := range item.Depends {
+				if ch, ok := done[dep.Entry]; ok {
+					<-ch
+					if _, ok := failed.Load(dep.Entry); ok {
+						failed.Store(item.Entry, true)
+						return
+					}
+				}
+			}
+
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			if ctx.Err() != nil {
+				failed.Store(item.Entry, true)
+				return
+			}
+
+			if jobs > 1 {
+				item.Vars.Prefix = "[" + item.Name + "] "
+			}
+
+			if err = item.install(); err != nil {
+				failed.Store(item.Entry, true)
+			}
+			return
+		})
+	}
+
+	return g.Wait()
+}
+
+func reportPlan(nodes []*Node) {
+	names := make([]string, 0, len(nodes))
+	for _, item := range nodes {
+		names = append(names, item.Name)
+	}
+	report.Emit(report.Event{Kind: report.PlanResolved, Packages: names})
+}
+
+func finishRun(start time.Time, err *error) {
+	e := report.Event{Kind: report.RunFinished, Elapsed: time.Since(start).Seconds()}
+	if *err != nil {
+		e.Error = (*err).Error()
+	}
+	report.Emit(e)
+}
@@ -0,0 +1,75 @@
+package deptree
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"raypm/internal/dbpkg"
+	"slices"
+)
+
+const hashLen = 32
+
+// Hash changes, if package.lua, version, target, host or any dependency were
+// changed, so each build has own directory in the store
+func (dn *Node) countHash(pkgFile string) (err error) {
+	content, err := os.ReadFile(pkgFile)
+	if err != nil {
+		return
+	}
+
+	h := sha256.New()
+	h.Write(content)
+	fmt.Fprintf(h, "\nversion:%s\n", dn.Pkg.MData["version"])
+	fmt.Fprintf(h, "target:%s\n", dn.Data.Target)
+	fmt.Fprintf(h, "host:%s\n", dn.Data.Host)
+
+	deps := make([]string, 0, len(dn.Depends))
+	for _, item := range dn.Depends {
+		deps = append(deps, item.Hash)
+	}
+	slices.Sort(deps)
+
+	for _, item := range deps {
+		io.WriteString(h, "dep:"+item+"\n")
+	}
+
+	dn.Hash = hex.EncodeToString(h.Sum(nil))[:hashLen]
+	return
+}
+
+func storeEntry(hash, name, version string) string {
+	return fmt.Sprintf("%s-%s-%s", hash, name, version)
+}
+
+// Removes directories of the store, that are neither installed nor used by
+// generations, with their caches. Returns removed entries
+func CollectGarbage(raypmPath string, db *dbpkg.PkgDb, used map[string]bool) (removed []string, err error) {
+	store := path.Join(raypmPath, "store")
+
+	dirs, err := os.ReadDir(store)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return
+	}
+
+	for _, item := range dirs {
+		out := path.Join(store, item.Name())
+		if db.IsExists(item.Name()) || used[out] {
+			continue
+		}
+
+		if err = os.RemoveAll(out); err != nil {
+			return
+		}
+		os.RemoveAll(path.Join(raypmPath, "cache", item.Name()))
+
+		removed = append(removed, item.Name())
+	}
+
+	return
+}
@@ -0,0 +1,41 @@
+package deptree
+
+import (
+	"maps"
+	"raypm/internal/web"
+)
+
+// Web target of the root package. Emscripten is taken from the dependency
+// named 'emsdk'
+func (dp *Tree) WebTarget() *web.Target {
+	return dp.Nodes.webTarget()
+}
+
+func (dn *Node) webTarget() *web.Target {
+	emsdk := ""
+	for _, item := range dn.Depends {
+		if item.Name == web.EmsdkPackage {
+			emsdk = item.Vars.Out
+		}
+	}
+
+	return web.NewTarget(dn.Name, dn.Pkg.TargetSpec, emsdk, dn.Vars.Src, dn.Vars.Out)
+}
+
+// Writes shell and assets manifest to $out, then runs build phase with
+// environment of Emscripten
+func (dn *Node) buildWeb() (err error) {
+	t := dn.webTarget()
+
+	if err = t.CheckEmsdk(); err != nil {
+		return
+	}
+
+	if err = t.Prepare(); err != nil {
+		return
+	}
+
+	maps.Copy(dn.Vars.Env, t.Env())
+	err = dn.runPhase("build")
+	return
+}
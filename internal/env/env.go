@@ -0,0 +1,341 @@
+// Environment for using installed packages: their 'bin' directories are
+// added to PATH, 'include' and 'lib' go to CGO flags, compiler is chosen for
+// the target
+package env
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"raypm/internal/deptree"
+	"raypm/internal/errs"
+	"raypm/internal/pkgconfig"
+	"raypm/internal/task"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+const (
+	Sh         string = "sh"
+	Fish       string = "fish"
+	PowerShell string = "powershell"
+)
+
+var Shells = []string{Sh, Fish, PowerShell}
+
+// Phases, where '${setenv}' is taken from
+var setenvPhases = []string{"prepare_phase", "build_phase"}
+
+type Env struct {
+	Vars    map[string]string
+	Path    []string            // Directories, that will be added to the beginning of PATH
+	Flags   map[string][]string // Flags, that are appended to the current value, like CGO_CFLAGS
+	Missing []string            // Dependencies, that are not in the profile
+}
+
+// Packages of the tree are taken from 'profile' (see generations.Profile),
+// so the environment follows the active generation
+func FromTree(dp *deptree.Tree, profile string) (e *Env) {
+	e = &Env{
+		Vars:  make(map[string]string),
+		Flags: make(map[string][]string),
+	}
+
+	var cflags, ldflags, pcDirs []string
+
+	for _, name := range slices.Sorted(maps.Keys(dp.Outputs())) {
+		out := path.Join(profile, name)
+		if !isDir(out) {
+			if name != dp.Nodes.Name {
+				e.Missing = append(e.Missing, name)
+			}
+			continue
+		}
+
+		if isDir(path.Join(out, "bin")) {
+			e.Path = append(e.Path, path.Join(out, "bin"))
+		}
+
+		if isDir(path.Join(out, "include")) {
+			cflags = append(cflags, "-I"+path.Join(out, "include"))
+		}
+
+		if isDir(path.Join(out, "lib")) {
+			ldflags = append(ldflags, "-L"+path.Join(out, "lib"))
+		}
+
+		if isDir(pkgconfig.Dir(out)) {
+			pcDirs = append(pcDirs, pkgconfig.Dir(out))
+		}
+	}
+
+	// GOOS, GOARCH and cross compiler
+	maps.Copy(e.Vars, dp.Data.Target.Env(dp.Data.Host))
+
+	if len(pcDirs) > 0 {
+		e.Vars["PKG_CONFIG_PATH"] = strings.Join(pcDirs, string(os.PathListSeparator))
+	}
+
+	// Shell gets compiler of the first ABI, '-build' goes through all of them
+	switch dp.Data.Target.OS {
+	case "android":
+		if t, err := dp.AndroidTarget(); err == nil {
+			maps.Copy(e.Vars, t.Env(t.ABIs[0]))
+		}
+	case "darwin":
+		if t, err := dp.DarwinTarget(); err == nil {
+			if t.Cross() {
+				e.Path = append(e.Path, t.ToolchainBin())
+			}
+			for key, value := range t.Env() {
+				if key != "PATH" {
+					e.Vars[key] = value
+				}
+			}
+		}
+	case "web":
+		t := dp.WebTarget()
+		e.Path = append(e.Path, t.EmscriptenDir())
+		for key, value := range t.Env() {
+			if key != "PATH" {
+				e.Vars[key] = value
+			}
+		}
+	}
+
+	// '${setenv}' of packages overrides defaults, dependencies go first, so
+	// the package itself has the last word
+	seen := make(map[*deptree.Node]bool)
+	var setenv func(dn *deptree.Node)
+	setenv = func(dn *deptree.Node) {
+		if seen[dn] || dn.Pkg == nil {
+			return
+		}
+		seen[dn] = true
+
+		for _, item := range dn.Depends {
+			setenv(item)
+		}
+
+		for _, phase := range setenvPhases {
+			for _, line := range dn.Pkg.TargetSpec[phase] {
+				d, err := task.ParseLine(line)
+				if err != nil || d.Command != task.SetEnv || len(d.Args) < 2 {
+					continue
+				}
+
+				args := dn.Vars.ExpandVars(&d.Args)
+				e.Vars[args[0]] = strings.Join(args[1:], " ")
+			}
+		}
+	}
+	setenv(dp.Nodes)
+
+	// Flags of packages are added to the value from '${setenv}' or to the
+	// user's one
+	for key, flags := range map[string][]string{"CGO_CFLAGS": cflags, "CGO_LDFLAGS": ldflags} {
+		if len(flags) == 0 {
+			continue
+		}
+
+		if value, ok := e.Vars[key]; ok {
+			e.Vars[key] = strings.Join(append([]string{value}, flags...), " ")
+		} else {
+			e.Flags[key] = flags
+		}
+	}
+
+	return
+}
+
+// Returns 'base' (like os.Environ()) with applied variables
+func (e *Env) Environ(base []string) (environ []string) {
+	environ = make([]string, 0, len(base)+len(e.Vars)+len(e.Flags))
+	oldPath := ""
+	oldFlags := make(map[string]string)
+
+	for _, item := range base {
+		key, value, _ := strings.Cut(item, "=")
+
+		if strings.EqualFold(key, "PATH") {
+			oldPath = value
+			continue
+		}
+
+		if _, ok := e.Flags[key]; ok {
+			oldFlags[key] = value
+			continue
+		}
+
+		if _, ok := e.Vars[key]; !ok {
+			environ = append(environ, item)
+		}
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(e.Vars)) {
+		environ = append(environ, key+"="+e.Vars[key])
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(e.Flags)) {
+		flags := e.Flags[key]
+		if oldFlags[key] != "" {
+			flags = append([]string{oldFlags[key]}, flags...)
+		}
+		environ = append(environ, key+"="+strings.Join(flags, " "))
+	}
+
+	newPath := slices.Clone(e.Path)
+	if oldPath != "" {
+		newPath = append(newPath, oldPath)
+	}
+	environ = append(environ, "PATH="+strings.Join(newPath, string(os.PathListSeparator)))
+
+	return
+}
+
+// Returns commands, that set the environment in the shell
+func (e *Env) Export(shell string) (script string, err error) {
+	var b strings.Builder
+	sep := string(os.PathListSeparator)
+
+	for _, key := range slices.Sorted(maps.Keys(e.Vars)) {
+		value := e.Vars[key]
+
+		switch shell {
+		case Sh:
+			fmt.Fprintf(&b, "export %s=%s\n", key, shQuote(value))
+		case Fish:
+			fmt.Fprintf(&b, "set -gx %s %s\n", key, fishQuote(value))
+		case PowerShell:
+			fmt.Fprintf(&b, "$env:%s = %s\n", key, psQuote(value))
+		default:
+			err = errs.New(errs.Usage, "UnknownShell", "'%s', available: %v", shell, Shells)
+			return
+		}
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(e.Flags)) {
+		flags := strings.Join(e.Flags[key], " ")
+
+		switch shell {
+		case Sh:
+			fmt.Fprintf(&b, "export %s=\"${%s:+$%s }\"%s\n", key, key, key, shQuote(flags))
+		case Fish:
+			fmt.Fprintf(&b, "set -gx %s $%s %s\n", key, key, fishQuote(flags))
+		case PowerShell:
+			fmt.Fprintf(&b, "$env:%s = (\"$env:%s \" + %s).Trim()\n", key, key, psQuote(flags))
+		}
+	}
+
+	if len(e.Path) > 0 {
+		dirs := strings.Join(e.Path, sep)
+
+		switch shell {
+		case Sh:
+			fmt.Fprintf(&b, "export PATH=%s\"%s$PATH\"\n", shQuote(dirs), sep)
+		case Fish:
+			quoted := make([]string, 0, len(e.Path))
+			for _, item := range e.Path {
+				quoted = append(quoted, fishQuote(item))
+			}
+			fmt.Fprintf(&b, "set -gx PATH %s $PATH\n", strings.Join(quoted, " "))
+		case PowerShell:
+			fmt.Fprintf(&b, "$env:PATH = %s + $env:PATH\n", psQuote(dirs+sep))
+		}
+	}
+
+	script = b.String()
+	return
+}
+
+// Runs a command with applied environment. The command is searched in PATH
+// of the environment first
+func (e *Env) Run(args []string) (err error) {
+	if len(args) == 0 {
+		err = errs.New(errs.Usage, "NoCommand", "nothing to run")
+		return
+	}
+
+	cmd := exec.Command(e.lookPath(args[0]), args[1:]...)
+	cmd.Env = e.Environ(os.Environ())
+
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	err = errs.Wrap(errs.Phase, "CommandFailed", cmd.Run(), "'%s'", args[0])
+	return
+}
+
+func (e *Env) lookPath(file string) string {
+	if strings.ContainsAny(file, `/\`) {
+		return file
+	}
+
+	for _, dir := range e.Path {
+		if pth, err := exec.LookPath(filepath.Join(dir, file)); err == nil {
+			return pth
+		}
+	}
+
+	return file
+}
+
+// Starts user's shell with applied environment
+func (e *Env) Spawn() (err error) {
+	shell := os.Getenv("SHELL")
+	if shell == "" {
+		shell = "/bin/sh"
+		if runtime.GOOS == "windows" {
+			shell = "powershell"
+		}
+	}
+
+	cmd := exec.Command(shell)
+	cmd.Env = e.Environ(os.Environ())
+
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	err = cmd.Run()
+	return
+}
+
+// Guesses user's shell by $SHELL
+func DetectShell() string {
+	shell := filepath.Base(os.Getenv("SHELL"))
+
+	switch {
+	case shell == "fish":
+		return Fish
+	case strings.HasPrefix(shell, "pwsh"), strings.HasPrefix(shell, "powershell"):
+		return PowerShell
+	case os.Getenv("SHELL") == "" && runtime.GOOS == "windows":
+		return PowerShell
+	}
+
+	return Sh
+}
+
+func isDir(pth string) bool {
+	fInfo, err := os.Stat(pth)
+	return err == nil && fInfo.IsDir()
+}
+
+func shQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func fishQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return "'" + strings.ReplaceAll(s, "'", `\'`) + "'"
+}
+
+func psQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,189 @@
+package env
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path"
+	"raypm/internal/deptree"
+	"raypm/internal/errs"
+	"raypm/internal/pkglua"
+	"raypm/internal/triple"
+	"raypm/internal/vars"
+	"slices"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	e := &Env{
+		Vars: map[string]string{
+			"CC":   "x86_64-w64-mingw32-gcc",
+			"GOOS": "windows",
+			"NAME": "it's",
+		},
+		Path: []string{"/store/a-go-1/bin", "/store/b-mingw-1/bin"},
+	}
+	sep := string(os.PathListSeparator)
+
+	t.Run("sh", func(t *testing.T) {
+		want := "export CC='x86_64-w64-mingw32-gcc'\n" +
+			"export GOOS='windows'\n" +
+			"export NAME='it'\\''s'\n" +
+			"export PATH='/store/a-go-1/bin" + sep + "/store/b-mingw-1/bin'\"" + sep + "$PATH\"\n"
+
+		got, err := e.Export(Sh)
+		if err != nil {
+			t.Error(err)
+		}
+
+		if got != want {
+			t.Errorf("Expect:\n%s\nGot:\n%s", want, got)
+		}
+	})
+
+	t.Run("fish", func(t *testing.T) {
+		want := "set -gx CC 'x86_64-w64-mingw32-gcc'\n" +
+			"set -gx GOOS 'windows'\n" +
+			"set -gx NAME 'it\\'s'\n" +
+			"set -gx PATH '/store/a-go-1/bin' '/store/b-mingw-1/bin' $PATH\n"
+
+		got, err := e.Export(Fish)
+		if err != nil {
+			t.Error(err)
+		}
+
+		if got != want {
+			t.Errorf("Expect:\n%s\nGot:\n%s", want, got)
+		}
+	})
+
+	t.Run("unknown shell", func(t *testing.T) {
+		if _, err := e.Export("tcsh"); err == nil {
+			t.Error("Expect error for unknown shell")
+		}
+	})
+}
+
+func TestEnviron(t *testing.T) {
+	e := &Env{
+		Vars: map[string]string{"GOOS": "windows"},
+		Path: []string{"/store/a-go-1/bin"},
+	}
+	sep := string(os.PathListSeparator)
+
+	got := e.Environ([]string{"HOME=/home/neco", "GOOS=linux", "PATH=/usr/bin"})
+	want := []string{
+		"HOME=/home/neco",
+		"GOOS=windows",
+		"PATH=/store/a-go-1/bin" + sep + "/usr/bin",
+	}
+
+	if !slices.Equal(got, want) {
+		t.Errorf("Expect %v, got %v", want, got)
+	}
+}
+
+func TestFlags(t *testing.T) {
+	e := &Env{
+		Vars:  map[string]string{},
+		Flags: map[string][]string{"CGO_CFLAGS": {"-I/store/a-raylib-1/include"}},
+	}
+
+	t.Run("appended to user's value", func(t *testing.T) {
+		got := e.Environ([]string{"CGO_CFLAGS=-O2", "PATH=/usr/bin"})
+		want := []string{"CGO_CFLAGS=-O2 -I/store/a-raylib-1/include", "PATH=/usr/bin"}
+
+		if !slices.Equal(got, want) {
+			t.Errorf("Expect %v, got %v", want, got)
+		}
+
+		got = e.Environ([]string{"PATH=/usr/bin"})
+		want = []string{"CGO_CFLAGS=-I/store/a-raylib-1/include", "PATH=/usr/bin"}
+
+		if !slices.Equal(got, want) {
+			t.Errorf("Expect %v, got %v", want, got)
+		}
+	})
+
+	t.Run("export", func(t *testing.T) {
+		for shell, want := range map[string]string{
+			Sh:         "export CGO_CFLAGS=\"${CGO_CFLAGS:+$CGO_CFLAGS }\"'-I/store/a-raylib-1/include'\n",
+			Fish:       "set -gx CGO_CFLAGS $CGO_CFLAGS '-I/store/a-raylib-1/include'\n",
+			PowerShell: "$env:CGO_CFLAGS = (\"$env:CGO_CFLAGS \" + '-I/store/a-raylib-1/include').Trim()\n",
+		} {
+			got, err := e.Export(shell)
+			if err != nil {
+				t.Error(err)
+			}
+
+			if got != want {
+				t.Errorf("Expect for %s:\n%s\nGot:\n%s", shell, want, got)
+			}
+		}
+	})
+}
+
+func TestFromTree(t *testing.T) {
+	profile, err := os.MkdirTemp(os.TempDir(), "env_test_*")
+	if err != nil {
+		t.Errorf("Cannot create temp directory: %s\n", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(profile)
+
+	os.MkdirAll(path.Join(profile, "raylib", "include"), 0754)
+	os.MkdirAll(path.Join(profile, "clang", "bin"), 0754)
+
+	node := func(name string, spec map[string][]string, deps ...*deptree.Node) *deptree.Node {
+		return &deptree.Node{
+			Name:    name,
+			Pkg:     &pkglua.Package{MData: map[string]string{"name": name}, TargetSpec: spec},
+			Depends: deps,
+			Vars:    vars.NewVars(profile, name, name, path.Join(profile, name)),
+		}
+	}
+
+	clang := node("clang", map[string][]string{"prepare_phase": {"${setenv CC $out/bin/clang}"}})
+	raylib := node("raylib", nil, clang)
+	game := node("game", map[string][]string{"build_phase": {"${setenv CGO_CFLAGS -DGAME}"}}, raylib, clang)
+
+	tree := &deptree.Tree{Nodes: game}
+	tree.Data.Host, tree.Data.Target = triple.Host(), triple.Host()
+	if tree.Data.Host.OS != "linux" {
+		return // Targets of other systems set own compilers
+	}
+
+	e := FromTree(tree, profile)
+
+	// '${setenv}' of a dependency
+	if want := path.Join(profile, "clang", "bin", "clang"); e.Vars["CC"] != want {
+		t.Errorf("Expect CC '%s', got '%s'", want, e.Vars["CC"])
+	}
+
+	// Flags are added to the value of '${setenv}'
+	if want := "-DGAME -I" + path.Join(profile, "raylib", "include"); e.Vars["CGO_CFLAGS"] != want {
+		t.Errorf("Expect CGO_CFLAGS '%s', got '%s'", want, e.Vars["CGO_CFLAGS"])
+	}
+
+	if len(e.Flags) > 0 {
+		t.Errorf("Expect flags in variables, got %v", e.Flags)
+	}
+}
+
+func TestRun(t *testing.T) {
+	e := &Env{Vars: map[string]string{}}
+
+	if err := e.Run(nil); !errors.Is(err, errs.Usage) {
+		t.Errorf("Expect usage error without command, got %v", err)
+	}
+
+	if _, err := exec.LookPath("sh"); err != nil {
+		return
+	}
+
+	// Exit code of the command is kept under the kind of error
+	var exitErr *exec.ExitError
+	if err := e.Run([]string{"sh", "-c", "exit 3"}); !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+		t.Errorf("Expect exit code 3, got %v", err)
+	}
+}
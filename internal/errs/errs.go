@@ -0,0 +1,127 @@
+package errs
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Kinds of errors. Every error of raypm wraps one of them, so callers can
+// check it with errors.Is, like errors.Is(err, errs.Checksum)
+var (
+	Usage      = errors.New("UsageError")      // Wrong command, flags or arguments
+	Resolution = errors.New("ResolutionError") // Package, dependency or target is not found
+	Fetch      = errors.New("FetchError")
+	Checksum   = errors.New("ChecksumError")
+	Unpack     = errors.New("UnpackError")
+	Phase      = errors.New("PhaseError") // Command or directive of a phase failed
+	Database   = errors.New("DatabaseError")
+	Lock       = errors.New("LockError") // Another raypm is running
+	Lua        = errors.New("LuaError")  // package.lua is broken
+)
+
+// Exit codes of raypm, they are listed in readme
+const (
+	ExitOK         = 0
+	ExitFailure    = 1 // Error without kind
+	ExitUsage      = 2
+	ExitResolution = 3
+	ExitFetch      = 4
+	ExitChecksum   = 5
+	ExitUnpack     = 6
+	ExitPhase      = 7
+	ExitDatabase   = 8
+	ExitLock       = 9
+	ExitLua        = 10
+)
+
+// The most specific kind goes first: checksum mismatch of a download in
+// fetch phase is ExitChecksum, not ExitFetch or ExitPhase
+var exitCodes = []struct {
+	kind error
+	code int
+}{
+	{Usage, ExitUsage},
+	{Lock, ExitLock},
+	{Checksum, ExitChecksum},
+	{Fetch, ExitFetch},
+	{Unpack, ExitUnpack},
+	{Lua, ExitLua},
+	{Database, ExitDatabase},
+	{Resolution, ExitResolution},
+	{Phase, ExitPhase},
+}
+
+// Error of some kind with a name, like 'ChecksumMismatch', and details. Name
+// and details are the message, cause is appended to it
+type Error struct {
+	Kind   error
+	Name   string
+	Detail string
+	Err    error // Cause, could be nil
+}
+
+func (e *Error) Error() string {
+	msg := e.Name
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+func New(kind error, name, format string, params ...any) error {
+	return &Error{Kind: kind, Name: name, Detail: fmt.Sprintf(format, params...)}
+}
+
+// Adds kind, name and details to the cause. Nil stays nil
+func Wrap(kind error, name string, err error, format string, params ...any) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Kind: kind, Name: name, Detail: fmt.Sprintf(format, params...), Err: err}
+}
+
+// Error, that is shown to user already (like error event of a phase), it's
+// not printed again on exit
+type reportedError struct {
+	error
+}
+
+func (e reportedError) Unwrap() error {
+	return e.error
+}
+
+func Reported(err error) error {
+	if err == nil {
+		return nil
+	}
+	return reportedError{err}
+}
+
+func IsReported(err error) bool {
+	var r reportedError
+	return errors.As(err, &r)
+}
+
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+
+	for _, item := range exitCodes {
+		if errors.Is(err, item.kind) {
+			return item.code
+		}
+	}
+
+	return ExitFailure
+}
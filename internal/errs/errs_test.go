@@ -0,0 +1,87 @@
+package errs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"testing"
+)
+
+func TestError(t *testing.T) {
+	t.Run("message", func(t *testing.T) {
+		for _, item := range []struct {
+			err  error
+			want string
+		}{
+			{New(Usage, "NoCommand", ""), "NoCommand"},
+			{New(Lock, "LockIsTaken", "'%s' is used", "/tmp/lock"), "LockIsTaken: '/tmp/lock' is used"},
+			{Wrap(Fetch, "DownloadFailed", errors.New("timeout"), "'%s'", "http://localhost"), "DownloadFailed: 'http://localhost': timeout"},
+			{Wrap(Usage, "WrongFlag", errors.New("UnknownReporter"), ""), "WrongFlag: UnknownReporter"},
+		} {
+			if item.err.Error() != item.want {
+				t.Errorf("Expect '%s', got '%s'", item.want, item.err)
+			}
+		}
+	})
+
+	t.Run("kind and cause", func(t *testing.T) {
+		err := Wrap(Resolution, "PackageNotFound", fs.ErrNotExist, "'raylib'")
+		err = fmt.Errorf("DependencyFailed: %w", err)
+
+		if !errors.Is(err, Resolution) || !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Expect resolution error and its cause: %v", err)
+		}
+
+		if errors.Is(err, Fetch) {
+			t.Errorf("Unexpected kind: %v", err)
+		}
+
+		var e *Error
+		if !errors.As(err, &e) || e.Name != "PackageNotFound" {
+			t.Errorf("Expect *Error, got %#v", e)
+		}
+	})
+
+	t.Run("nil is not wrapped", func(t *testing.T) {
+		if err := Wrap(Phase, "PhaseFailed", nil, ""); err != nil {
+			t.Errorf("Expect nil, got %v", err)
+		}
+	})
+}
+
+func TestExitCode(t *testing.T) {
+	checksum := New(Checksum, "ChecksumMismatch", "")
+
+	for _, item := range []struct {
+		err  error
+		want int
+	}{
+		{nil, ExitOK},
+		{errors.New("plain"), ExitFailure},
+		{New(Usage, "NoCommand", ""), ExitUsage},
+		{New(Lua, "WrongPackageFile", ""), ExitLua},
+		// The most specific kind wins
+		{Wrap(Phase, "PhaseFailed", Wrap(Fetch, "DownloadFailed", checksum, ""), ""), ExitChecksum},
+		{Reported(Wrap(Phase, "PhaseFailed", errors.New("exit status 2"), "")), ExitPhase},
+	} {
+		if got := ExitCode(item.err); got != item.want {
+			t.Errorf("Expect %d for '%v', got %d", item.want, item.err, got)
+		}
+	}
+}
+
+func TestReported(t *testing.T) {
+	err := Reported(New(Phase, "PhaseFailed", "build phase of 'raylib'"))
+
+	if !IsReported(fmt.Errorf("DependencyFailed: %w", err)) {
+		t.Errorf("Expect reported error: %v", err)
+	}
+
+	if !errors.Is(err, Phase) || err.Error() != "PhaseFailed: build phase of 'raylib'" {
+		t.Errorf("Wrong error: %v", err)
+	}
+
+	if IsReported(errors.New("plain")) || Reported(nil) != nil {
+		t.Error("Expect not reported error")
+	}
+}
@@ -0,0 +1,307 @@
+/*
+Generations are numbered snapshots of installed packages. Each generation is
+a directory with a manifest and a profile: symlinks to packages in the store,
+one directory per target:
+
+	generations/
+	  current          -> 2/pkgs (profile of active generation)
+	  1/manifest.json
+	  1/pkgs/<target>/<name> -> store/<hash>-<name>-<version>
+	  2/...
+
+env, exec and pkg-config take packages from 'current/<target>'. Switching
+between generations only replaces 'current' symlink, so it's atomic and
+doesn't touch the store.
+*/
+package generations
+
+import (
+	"encoding/json"
+	"maps"
+	"os"
+	"path"
+	"raypm/internal/errs"
+	log "raypm/pkg/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	currentLink  = "current"
+	manifestFile = "manifest.json"
+	pkgsDir      = "pkgs"
+)
+
+type Manifest struct {
+	Number  int               `json:"number"`
+	Created time.Time         `json:"created"`
+	Action  string            `json:"action"`   // What created the generation, e.g. 'install snake'
+	Pkgs    map[string]string `json:"packages"` // Key of the package (see Key) -> its directory
+}
+
+type Generations struct {
+	Path string
+}
+
+func Open(pth string) *Generations {
+	return &Generations{Path: pth}
+}
+
+// Key of the package in manifest, it's the path of its symlink in the profile
+func Key(target, name string) string {
+	return path.Join(target, name)
+}
+
+// Directory with packages of the target in active generation. Paths are
+// going through 'current', so they follow rollbacks
+func (g *Generations) Profile(target string) string {
+	return path.Join(g.Path, currentLink, target)
+}
+
+// Returns 0 if there are no generations yet
+func (g *Generations) CurrentNumber() (number int, err error) {
+	pth := path.Join(g.Path, currentLink)
+
+	target, err := os.Readlink(pth)
+	if os.IsNotExist(err) {
+		err = nil
+		return
+	} else if err != nil {
+		err = errs.Wrap(errs.Database, "BrokenGenerations", err, "'%s' must be a symlink to profile", pth)
+		return
+	}
+
+	dir, _, _ := strings.Cut(target, "/")
+	if number, err = strconv.Atoi(dir); err != nil {
+		err = errs.Wrap(errs.Database, "BrokenGenerations", err, "'%s' -> '%s'", pth, target)
+	}
+
+	return
+}
+
+// Returns an empty manifest if there are no generations yet
+func (g *Generations) Current() (m *Manifest, err error) {
+	number, err := g.CurrentNumber()
+	if err != nil {
+		return
+	}
+
+	if number == 0 {
+		m = &Manifest{Pkgs: make(map[string]string)}
+		return
+	}
+
+	return g.Get(number)
+}
+
+func (g *Generations) Get(number int) (m *Manifest, err error) {
+	pth := path.Join(g.Path, strconv.Itoa(number), manifestFile)
+
+	data, err := os.ReadFile(pth)
+	if os.IsNotExist(err) {
+		err = errs.Wrap(errs.Usage, "GenerationNotFound", err, "%d", number)
+		return
+	} else if err != nil {
+		return
+	}
+
+	m = &Manifest{}
+	if err = json.Unmarshal(data, m); err != nil {
+		err = errs.Wrap(errs.Database, "BrokenGenerations", err, "'%s'", pth)
+		return
+	}
+
+	if m.Pkgs == nil {
+		m.Pkgs = make(map[string]string)
+	}
+
+	return
+}
+
+// Returns generations sorted by number
+func (g *Generations) List() (list []*Manifest, err error) {
+	dirs, err := os.ReadDir(g.Path)
+	if os.IsNotExist(err) {
+		err = nil
+		return
+	} else if err != nil {
+		return
+	}
+
+	numbers := make([]int, 0)
+	for _, item := range dirs {
+		if number, lerr := strconv.Atoi(item.Name()); lerr == nil && item.IsDir() {
+			numbers = append(numbers, number)
+		}
+	}
+	slices.Sort(numbers)
+
+	for _, number := range numbers {
+		var m *Manifest
+		if m, err = g.Get(number); err != nil {
+			return
+		}
+		list = append(list, m)
+	}
+
+	return
+}
+
+// Directories of packages, that are used by any generation. They must stay
+// in the store, so it's possible to switch to these generations
+func (g *Generations) Used() (used map[string]bool, err error) {
+	list, err := g.List()
+	if err != nil {
+		return
+	}
+
+	used = make(map[string]bool)
+	for _, item := range list {
+		for _, dir := range item.Pkgs {
+			used[dir] = true
+		}
+	}
+
+	return
+}
+
+// Creates the next generation with given packages and makes it current
+func (g *Generations) Create(action string, pkgs map[string]string) (m *Manifest, err error) {
+	list, err := g.List()
+	if err != nil {
+		return
+	}
+
+	m = &Manifest{
+		Number:  1,
+		Created: time.Now(),
+		Action:  action,
+		Pkgs:    maps.Clone(pkgs),
+	}
+
+	if len(list) > 0 {
+		m.Number = list[len(list)-1].Number + 1
+	}
+
+	dir := path.Join(g.Path, strconv.Itoa(m.Number))
+	links := path.Join(dir, pkgsDir)
+	if err = os.MkdirAll(links, 0754); err != nil {
+		return
+	}
+
+	for key, target := range m.Pkgs {
+		link := path.Join(links, key)
+		if err = os.MkdirAll(path.Dir(link), 0754); err != nil {
+			return
+		}
+
+		if err = os.Symlink(target, link); err != nil {
+			err = errs.Wrap(errs.Database, "GenerationFailed", err, "profile of generation %d", m.Number)
+			return
+		}
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return
+	}
+
+	if err = os.WriteFile(path.Join(dir, manifestFile), data, 0644); err != nil {
+		return
+	}
+
+	err = g.Switch(m.Number)
+	return
+}
+
+// Makes generation current. Packages, that are missing in the store, are
+// reported and the generation stays the same
+func (g *Generations) Switch(number int) (err error) {
+	m, err := g.Get(number)
+	if err != nil {
+		return
+	}
+
+	missing := make([]string, 0)
+	for key, target := range m.Pkgs {
+		if _, lerr := os.Stat(target); lerr != nil {
+			missing = append(missing, key)
+		}
+	}
+
+	if len(missing) > 0 {
+		slices.Sort(missing)
+		err = errs.New(
+			errs.Database, "GenerationIsBroken",
+			"generation %d uses removed packages: %s", number, strings.Join(missing, ", "),
+		)
+		return
+	}
+
+	// Symlink is replaced with rename, so 'current' always exists
+	tmp := path.Join(g.Path, currentLink+".tmp")
+	os.Remove(tmp)
+	if err = os.Symlink(path.Join(strconv.Itoa(number), pkgsDir), tmp); err != nil {
+		return
+	}
+
+	err = os.Rename(tmp, path.Join(g.Path, currentLink))
+	return
+}
+
+// Switches to the generation before current one
+func (g *Generations) Rollback() (number int, err error) {
+	current, err := g.CurrentNumber()
+	if err != nil {
+		return
+	}
+
+	list, err := g.List()
+	if err != nil {
+		return
+	}
+
+	for _, item := range list {
+		if item.Number < current {
+			number = item.Number
+		}
+	}
+
+	if number == 0 {
+		err = errs.New(errs.Usage, "NoPreviousGeneration", "there is no generation before %d", current)
+		return
+	}
+
+	err = g.Switch(number)
+	return
+}
+
+// Removes all generations except current one, so packages used only by them
+// could be removed from the store. Returns numbers of removed generations
+func (g *Generations) Prune() (removed []int, err error) {
+	current, err := g.CurrentNumber()
+	if err != nil {
+		return
+	}
+
+	list, err := g.List()
+	if err != nil {
+		return
+	}
+
+	for _, item := range list {
+		if item.Number == current {
+			continue
+		}
+
+		if err = os.RemoveAll(path.Join(g.Path, strconv.Itoa(item.Number))); err != nil {
+			return
+		}
+		log.Debug("Generation %d removed", item.Number)
+		removed = append(removed, item.Number)
+	}
+
+	return
+}
@@ -0,0 +1,152 @@
+package generations
+
+import (
+	"errors"
+	"maps"
+	"os"
+	"path"
+	"raypm/internal/errs"
+	log "raypm/pkg/slog"
+	"slices"
+	"testing"
+)
+
+func TestGenerations(t *testing.T) {
+	log.Init(false)
+
+	tmpRaypm, err := os.MkdirTemp(os.TempDir(), "generations_test_*")
+	if err != nil {
+		t.Errorf("Cannot create temp directory: %s\n", err)
+		t.FailNow()
+	}
+
+	store := path.Join(tmpRaypm, "store")
+	oldRaylib := path.Join(store, "aaa-raylib-5.0")
+	newRaylib := path.Join(store, "bbb-raylib-5.5")
+
+	for _, item := range []string{oldRaylib, newRaylib} {
+		if err = os.MkdirAll(item, 0754); err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+	}
+
+	g := Open(path.Join(tmpRaypm, "generations"))
+	key := Key("linux/amd64/gnu", "raylib")
+
+	t.Run("empty generations", func(t *testing.T) {
+		m, err := g.Current()
+		if err != nil {
+			t.Error(err)
+		}
+
+		if m.Number != 0 || len(m.Pkgs) != 0 {
+			t.Errorf("Expect empty generation, got %v", m)
+		}
+	})
+
+	t.Run("create generations", func(t *testing.T) {
+		if _, err := g.Create("install raylib", map[string]string{key: oldRaylib}); err != nil {
+			t.Error(err)
+		}
+
+		m, err := g.Create("install raylib", map[string]string{key: newRaylib})
+		if err != nil {
+			t.Error(err)
+		}
+
+		if m.Number != 2 {
+			t.Errorf("Expect generation 2, got %d", m.Number)
+		}
+
+		link := path.Join(g.Path, "2", "pkgs", "linux", "amd64", "gnu", "raylib")
+		if target, err := os.Readlink(link); err != nil || target != newRaylib {
+			t.Errorf("Expect '%s' -> '%s', got '%s' (%v)", link, newRaylib, target, err)
+		}
+
+		if !sameDir(path.Join(g.Profile("linux/amd64/gnu"), "raylib"), newRaylib) {
+			t.Errorf("Expect profile of generation 2")
+		}
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		number, err := g.Rollback()
+		if err != nil {
+			t.Error(err)
+		}
+
+		if number != 1 {
+			t.Errorf("Expect generation 1, got %d", number)
+		}
+
+		m, err := g.Current()
+		if err != nil {
+			t.Error(err)
+		}
+
+		want := map[string]string{key: oldRaylib}
+		if !maps.Equal(m.Pkgs, want) {
+			t.Errorf("Expect %v, got %v", want, m.Pkgs)
+		}
+
+		// Profile follows the active generation
+		if !sameDir(path.Join(g.Profile("linux/amd64/gnu"), "raylib"), oldRaylib) {
+			t.Errorf("Expect profile of generation 1")
+		}
+
+		if _, err = g.Rollback(); !errors.Is(err, errs.Usage) {
+			t.Errorf("Rollback from the first generation must fail, got %v", err)
+		}
+	})
+
+	t.Run("used packages", func(t *testing.T) {
+		used, err := g.Used()
+		if err != nil {
+			t.Error(err)
+		}
+
+		if !used[oldRaylib] || !used[newRaylib] || len(used) != 2 {
+			t.Errorf("Expect both builds of raylib, got %v", used)
+		}
+	})
+
+	t.Run("switch to broken generation", func(t *testing.T) {
+		if err := os.RemoveAll(newRaylib); err != nil {
+			t.Error(err)
+		}
+
+		if err := g.Switch(2); !errors.Is(err, errs.Database) {
+			t.Errorf("Switched to generation with removed package: %v", err)
+		}
+
+		if number, _ := g.CurrentNumber(); number != 1 {
+			t.Errorf("Expect generation 1, got %d", number)
+		}
+	})
+
+	t.Run("prune", func(t *testing.T) {
+		removed, err := g.Prune()
+		if err != nil {
+			t.Error(err)
+		}
+
+		if !slices.Equal(removed, []int{2}) {
+			t.Errorf("Expect removed generation 2, got %v", removed)
+		}
+
+		used, err := g.Used()
+		if err != nil {
+			t.Error(err)
+		}
+
+		if used[newRaylib] || !used[oldRaylib] {
+			t.Errorf("Expect only the current generation, got %v", used)
+		}
+	})
+}
+
+func sameDir(a, b string) bool {
+	aInfo, aErr := os.Stat(a)
+	bInfo, bErr := os.Stat(b)
+	return aErr == nil && bErr == nil && os.SameFile(aInfo, bInfo)
+}
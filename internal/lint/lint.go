@@ -0,0 +1,484 @@
+// Checks of package.lua, that find mistakes before installation: metadata,
+// targets, directives of phases, variables, links and dependencies
+package lint
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"raypm/internal/errs"
+	"raypm/internal/pkglua"
+	"raypm/internal/task"
+	"raypm/internal/triple"
+	"raypm/internal/vars"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+type Severity string
+
+const (
+	Error   Severity = "error"
+	Warning Severity = "warning" // The package works, but likely not as expected
+)
+
+type Diagnostic struct {
+	File     string
+	Line     int // 0, if the line is unknown
+	Severity Severity
+	Message  string
+}
+
+// Like 'package.lua:12: error: message', editors jump to the line
+func (d Diagnostic) String() string {
+	if d.Line == 0 {
+		return fmt.Sprintf("%s: %s: %s", d.File, d.Severity, d.Message)
+	}
+	return fmt.Sprintf("%s:%d: %s: %s", d.File, d.Line, d.Severity, d.Message)
+}
+
+type linter struct {
+	file     string
+	pkgsPath string
+	lines    []string
+	data     *pkglua.Table
+	diags    []Diagnostic
+}
+
+// Lua errors look like 'path:line: message'
+var luaErrorLine = regexp.MustCompile(`:(\d+): (.*)$`)
+
+var variable = regexp.MustCompile(`\$([a-z_][a-z0-9_]*)`)
+
+// Checks package.lua. Dependencies are looked for in 'pkgsPath' and next to
+// the package's directory, like in a repository of packages. Error is
+// returned only if the file can't be read
+func File(pkgFile, pkgsPath string) (diags []Diagnostic, err error) {
+	src, err := os.ReadFile(pkgFile)
+	if err != nil {
+		return
+	}
+
+	ln := &linter{
+		file:     pkgFile,
+		pkgsPath: pkgsPath,
+		lines:    strings.Split(string(src), "\n"),
+	}
+
+	if ln.data, err = pkglua.ReadData(pkgFile); err != nil {
+		line, msg := 0, err.Error()
+		if match := luaErrorLine.FindStringSubmatch(msg); match != nil {
+			fmt.Sscan(match[1], &line)
+			msg = match[2]
+		}
+		ln.report(Error, line, "%s", msg)
+
+		return ln.diags, nil
+	}
+
+	ln.metadata()
+	ln.targets()
+
+	slices.SortStableFunc(ln.diags, func(a, b Diagnostic) int {
+		return a.Line - b.Line
+	})
+
+	return ln.diags, nil
+}
+
+// Paths of package.lua files: files are taken as is, directories give
+// their package.lua or, for repositories of packages, '*/package.lua'
+func Find(paths []string) (files []string, err error) {
+	for _, item := range paths {
+		var info os.FileInfo
+		if info, err = os.Stat(item); err != nil {
+			return
+		}
+
+		if !info.IsDir() {
+			files = append(files, item)
+			continue
+		}
+
+		if _, err = os.Stat(path.Join(item, "package.lua")); err == nil {
+			files = append(files, path.Join(item, "package.lua"))
+			continue
+		}
+
+		var found []string
+		if found, err = filepath.Glob(path.Join(item, "*", "package.lua")); err != nil {
+			return
+		}
+
+		if len(found) == 0 {
+			err = errs.New(errs.Usage, "NoPackageFiles", "'%s'", item)
+			return
+		}
+		files = append(files, found...)
+	}
+
+	return
+}
+
+func (ln *linter) report(severity Severity, line int, format string, params ...any) {
+	ln.diags = append(ln.diags, Diagnostic{
+		File:     ln.file,
+		Line:     line,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, params...),
+	})
+}
+
+func (ln *linter) metadata() {
+	from := ln.field(1, "Data")
+
+	for _, key := range slices.Sorted(maps.Keys(ln.data.Fields)) {
+		if key == "supported_systems" {
+			ln.report(Warning, ln.field(from, key), "'supported_systems' is not used, systems are keys of 'targets'")
+		} else if !slices.Contains(pkglua.DataFields, key) {
+			ln.report(Warning, ln.field(from, key), "unknown field '%s' of Data%s", key, suggest(key, pkglua.DataFields))
+		}
+	}
+
+	for _, key := range []string{"name", "version"} {
+		switch value := ln.data.Fields[key].(type) {
+		case nil:
+			ln.report(Error, from, "'%s' is required", key)
+		case string:
+			if value == "" {
+				ln.report(Error, ln.field(from, key), "'%s' is empty", key)
+			}
+		case float64:
+			if key == "name" {
+				ln.report(Error, ln.field(from, key), "'name' must be a string")
+			}
+		default:
+			ln.report(Error, ln.field(from, key), "'%s' must be a string, got %v", key, typeName(value))
+		}
+	}
+
+	if ln.data.Fields["description"] == nil {
+		ln.report(Warning, from, "'description' is empty")
+	}
+
+	for _, key := range []string{"description", "src_path", "build_path"} {
+		if value, ok := ln.data.Fields[key]; ok && !isString(value) {
+			ln.report(Error, ln.field(from, key), "'%s' must be a string, got %v", key, typeName(value))
+		}
+	}
+
+	if value, ok := ln.data.Fields["packages"]; ok {
+		packages, ok := value.(*pkglua.Table)
+		if !ok {
+			ln.report(Error, ln.field(from, "packages"), "'packages' must be a table of distros, got %v", typeName(value))
+			return
+		}
+
+		for _, distro := range slices.Sorted(maps.Keys(packages.Fields)) {
+			if !isArray(packages.Fields[distro]) {
+				ln.report(Error, ln.field(from, distro), "packages of '%s' must be an array of strings", distro)
+			}
+		}
+	}
+}
+
+func (ln *linter) targets() {
+	from := ln.field(1, "Data")
+
+	targets, ok := ln.data.Fields["targets"].(*pkglua.Table)
+	if !ok {
+		ln.report(Error, ln.field(from, "targets"), "'targets' is required, it's a table of systems")
+		return
+	}
+
+	if len(targets.Fields) == 0 {
+		ln.report(Warning, ln.field(from, "targets"), "there are no targets")
+		return
+	}
+
+	from = ln.field(1, "targets")
+	for _, key := range slices.Sorted(maps.Keys(targets.Fields)) {
+		line := ln.field(from, key)
+
+		t, err := triple.Parse(key)
+		if err != nil {
+			ln.report(Error, line, "unknown target '%s': %s", key, err)
+			continue
+		}
+
+		// Get_Phases looks for 'os/arch/abi', 'os/arch' and 'os'
+		if key != t.OS && key != t.OS+"/"+t.Arch && key != t.String() {
+			want := t.String()
+			if strings.Count(key, "/") == 1 {
+				want = t.OS + "/" + t.Arch
+			}
+			ln.report(Error, line, "target '%s' is never chosen, write it as '%s'", key, want)
+			continue
+		}
+
+		spec, ok := targets.Fields[key].(*pkglua.Table)
+		if !ok {
+			ln.report(Error, line, "target '%s' must be a table, got %v", key, typeName(targets.Fields[key]))
+			continue
+		}
+
+		ln.spec(key, t.OS, spec, line, false)
+	}
+}
+
+// Table of the target or its 'cross_<os>' table
+func (ln *linter) spec(name, targetOS string, spec *pkglua.Table, from int, cross bool) {
+	fields := pkglua.TargetFields()
+	hasCross := false
+
+	for _, key := range slices.Sorted(maps.Keys(spec.Fields)) {
+		value, line := spec.Fields[key], ln.field(from, key)
+
+		switch {
+		case strings.HasSuffix(key, "_phase") && slices.Contains(fields, key):
+			if !isString(value) {
+				ln.report(Error, line, "'%s' of '%s' must be a string, got %v", key, name, typeName(value))
+				continue
+			}
+			ln.phase(name, targetOS, key, value.(string), line)
+		case key == "dependencies":
+			if !isArray(value) {
+				ln.report(Error, line, "'dependencies' of '%s' must be an array of strings", name)
+				continue
+			}
+
+			for _, item := range value.(*pkglua.Table).Items {
+				ln.dependency(item.(string), line)
+			}
+		case pkglua.IsArrayField(key):
+			if !isArray(value) {
+				ln.report(Error, line, "'%s' of '%s' must be an array of strings", key, name)
+			}
+		case slices.Contains(fields, key):
+			if !isString(value) && !isNumber(value) {
+				ln.report(Error, line, "'%s' of '%s' must be a string, got %v", key, name, typeName(value))
+			}
+		case strings.HasPrefix(key, "cross_"):
+			hasCross = true
+			host := strings.TrimPrefix(key, "cross_")
+
+			if cross {
+				ln.report(Warning, line, "'%s' inside '%s' is not used", key, name)
+				continue
+			}
+
+			if !slices.Contains(triple.OSes(), host) {
+				ln.report(Error, line, "unknown host system in '%s', expect cross_<os> for %v", key, triple.OSes())
+				continue
+			}
+
+			sub, ok := value.(*pkglua.Table)
+			if !ok {
+				ln.report(Error, line, "'%s' of '%s' must be a table, got %v", key, name, typeName(value))
+				continue
+			}
+
+			// 'targets.windows.cross_linux = targets.windows'
+			if sub == spec {
+				continue
+			}
+
+			// Cross table replaces the target's one
+			if spec.Fields["dependencies"] != nil && sub.Fields["dependencies"] == nil {
+				ln.report(Warning, line, "'%s' of '%s' has no dependencies, ones of '%s' are not used for cross builds", key, name, name)
+			}
+
+			ln.spec(name+"."+key, targetOS, sub, line, true)
+		default:
+			ln.report(Warning, line, "unknown field '%s' of '%s'%s", key, name, suggest(key, fields))
+		}
+	}
+
+	// Nothing is built on these systems, so phases are taken from cross tables only
+	if !cross && !hasCross && (targetOS == "web" || targetOS == "android") {
+		ln.report(Warning, from, "target '%s' has no cross_<os> table, it can't be built", name)
+	}
+}
+
+func (ln *linter) phase(name, targetOS, phase, text string, from int) {
+	phaseType := strings.TrimSuffix(phase, "_phase")
+
+	for _, item := range strings.Split(text, "\n") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		line := ln.text(from, item)
+		if line == 0 {
+			line = from
+		}
+
+		if err := task.Check(phaseType, item); err != nil {
+			ln.report(Error, line, "%s of '%s': %s", phase, name, err)
+			continue
+		}
+
+		if d, _ := task.ParseLine(item); d.Command == task.CallPackageManager && ln.data.Fields["packages"] == nil {
+			ln.report(Error, line, "'${%s}' needs 'packages' table with packages of distros", d.Command)
+		}
+
+		for _, match := range variable.FindAllStringSubmatch(item, -1) {
+			ln.variable(match[1], targetOS, line)
+		}
+	}
+}
+
+// Variables are replaced as is, so '$srcdir' becomes '<src>dir'
+func (ln *linter) variable(name, targetOS string, line int) {
+	if slices.Contains(vars.Names, name) {
+		if name == "abi" && targetOS != "android" {
+			ln.report(Warning, line, "'$abi' is empty for %s, it's set for android only", targetOS)
+		}
+		return
+	}
+
+	for _, item := range vars.Names {
+		if strings.HasPrefix(name, item) {
+			ln.report(Warning, line, "'$%s' is expanded as '$%s' followed by '%s'", name, item, name[len(item):])
+			return
+		}
+	}
+
+	ln.report(Warning, line, "unknown variable '$%s', raypm expands %v", name, vars.Names)
+}
+
+func (ln *linter) dependency(name string, from int) {
+	line := ln.text(from, `"`+name+`"`)
+	if line == 0 {
+		line = from
+	}
+
+	if name == ln.data.Fields["name"] {
+		ln.report(Error, line, "package depends on itself")
+		return
+	}
+
+	dirs := []string{ln.pkgsPath}
+	if abs, err := filepath.Abs(ln.file); err == nil {
+		dirs = append(dirs, path.Dir(path.Dir(abs)))
+	}
+
+	for _, dir := range dirs {
+		if _, err := os.Stat(path.Join(dir, name, "package.lua")); err == nil {
+			return
+		}
+	}
+
+	ln.report(Error, line, "dependency '%s' is not found in '%s'", name, ln.pkgsPath)
+}
+
+// Line of 'key = ' or '["key"] = ', the first one after 'from' line. 0, if
+// there is no such line
+func (ln *linter) field(from int, key string) int {
+	quoted := regexp.QuoteMeta(key)
+	re := regexp.MustCompile(`(^|\W)` + quoted + `\s*=($|[^=])|\[\s*["']` + quoted + `["']\s*\]\s*=`)
+
+	return ln.find(from, re.MatchString)
+}
+
+func (ln *linter) text(from int, text string) int {
+	return ln.find(from, func(line string) bool {
+		return strings.Contains(line, text)
+	})
+}
+
+// Lines are counted from 1, lines before 'from' are looked at last
+func (ln *linter) find(from int, match func(line string) bool) int {
+	from = max(from, 1)
+
+	for i := range ln.lines {
+		line := ln.lines[(from-1+i)%len(ln.lines)]
+		if strings.HasPrefix(strings.TrimSpace(line), "--") {
+			continue
+		}
+
+		if match(line) {
+			return (from-1+i)%len(ln.lines) + 1
+		}
+	}
+
+	return 0
+}
+
+func isString(value any) bool {
+	_, ok := value.(string)
+	return ok
+}
+
+func isNumber(value any) bool {
+	_, ok := value.(float64)
+	return ok
+}
+
+func isArray(value any) bool {
+	t, ok := value.(*pkglua.Table)
+	if !ok || len(t.Fields) > 0 {
+		return false
+	}
+
+	for _, item := range t.Items {
+		if !isString(item) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func typeName(value any) string {
+	switch value.(type) {
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case *pkglua.Table:
+		return "table"
+	}
+
+	return fmt.Sprint(value)
+}
+
+// ', did you mean ...?' for typos, like 'dependecies'
+func suggest(word string, known []string) string {
+	for _, item := range known {
+		if distance(word, item) <= 2 {
+			return fmt.Sprintf(", did you mean '%s'?", item)
+		}
+	}
+	return ""
+}
+
+// Levenshtein distance
+func distance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+
+	return prev[len(b)]
+}
@@ -0,0 +1,113 @@
+package lint
+
+import (
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestFile(t *testing.T) {
+	pkgs := path.Join("testdata", "pkgs")
+
+	t.Run("package without mistakes", func(t *testing.T) {
+		for _, item := range []string{"good", "zlib"} {
+			diags, err := File(path.Join(pkgs, item, "package.lua"), pkgs)
+			if err != nil {
+				t.Error(err)
+				t.FailNow()
+			}
+
+			if len(diags) > 0 {
+				t.Errorf("Unexpected diagnostics of '%s': %v", item, diags)
+			}
+		}
+	})
+
+	t.Run("broken package", func(t *testing.T) {
+		pkgFile := path.Join(pkgs, "broken", "package.lua")
+		diags, err := File(pkgFile, pkgs)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		want := []Diagnostic{
+			{Line: 3, Severity: Warning, Message: "did you mean 'dependencies'?"},
+			{Line: 4, Severity: Error, Message: "WrongLink: 'ftp://example.com/a.zip'"},
+			{Line: 5, Severity: Error, Message: "WrongArchiveType: 'tgzz'"},
+			{Line: 7, Severity: Error, Message: "'copy' takes 2 arguments, got 1"},
+			{Line: 8, Severity: Error, Message: "UnknownCommand: 'run'"},
+			{Line: 9, Severity: Warning, Message: "'$srcdir' is expanded as '$src' followed by 'dir'"},
+			{Line: 9, Severity: Warning, Message: "'$output' is expanded as '$out' followed by 'put'"},
+			{Line: 11, Severity: Error, Message: "'${pkgman}' needs 'packages' table"},
+			{Line: 13, Severity: Error, Message: "target 'linux/x86_64' is never chosen, write it as 'linux/amd64'"},
+			{Line: 14, Severity: Error, Message: "unknown target 'beos'"},
+			{Line: 16, Severity: Error, Message: "dependency 'missing' is not found"},
+			{Line: 17, Severity: Warning, Message: "'cross_linux' of 'windows' has no dependencies"},
+			{Line: 18, Severity: Error, Message: "'unpack' takes 3 arguments, got 1"},
+			{Line: 20, Severity: Error, Message: "unknown host system in 'cross_haiku'"},
+			{Line: 22, Severity: Warning, Message: "target 'web' has no cross_<os> table"},
+			{Line: 27, Severity: Error, Message: "'name' is required"},
+			{Line: 27, Severity: Warning, Message: "'description' is empty"},
+			{Line: 28, Severity: Error, Message: "'version' is empty"},
+			{Line: 29, Severity: Warning, Message: "did you mean 'description'?"},
+		}
+
+		if len(diags) != len(want) {
+			t.Errorf("Expect %d diagnostics, got %d:\n%s", len(want), len(diags), show(diags))
+			t.FailNow()
+		}
+
+		for i, item := range want {
+			got := diags[i]
+			if got.File != pkgFile || got.Line != item.Line || got.Severity != item.Severity || !strings.Contains(got.Message, item.Message) {
+				t.Errorf("Expect line %d %s '%s', got '%s'", item.Line, item.Severity, item.Message, got)
+			}
+		}
+	})
+
+	t.Run("syntax error", func(t *testing.T) {
+		pkgFile := path.Join(pkgs, "syntax", "package.lua")
+		diags, err := File(pkgFile, pkgs)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		if len(diags) != 1 || diags[0].Line != 4 || diags[0].Severity != Error {
+			t.Errorf("Expect error on line 4, got:\n%s", show(diags))
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		if _, err := File(path.Join(pkgs, "none", "package.lua"), pkgs); err == nil {
+			t.Error("Expect error for missing file")
+		}
+	})
+}
+
+func TestFind(t *testing.T) {
+	pkgs := path.Join("testdata", "pkgs")
+
+	files, err := Find([]string{pkgs, path.Join(pkgs, "zlib"), path.Join(pkgs, "good", "package.lua")})
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	// Repository of packages, directory with package.lua and the file
+	if len(files) != 6 || files[4] != path.Join(pkgs, "zlib", "package.lua") {
+		t.Errorf("Wrong files: %v", files)
+	}
+
+	if _, err := Find([]string{"testdata"}); err == nil {
+		t.Error("Expect error for directory without packages")
+	}
+}
+
+func show(diags []Diagnostic) (out string) {
+	for _, item := range diags {
+		out += item.String() + "\n"
+	}
+	return
+}
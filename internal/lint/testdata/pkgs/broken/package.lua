@@ -0,0 +1,31 @@
+local targets = {
+  linux = {
+    dependecies = { "zlib" },
+    fetch_phase = "${get ftp://example.com/a.zip a.zip}",
+    unpack_phase = "${unpack tgzz a.tgz .}",
+    build_phase = [[
+      ${copy $src/build}
+      ${run make}
+      cc -o $srcdir/app $output/main.c
+    ]],
+    install_phase = "${pkgman}",
+  },
+  ["linux/x86_64"] = {},
+  beos = {},
+  windows = {
+    dependencies = { "zlib", "missing" },
+    cross_linux = {
+      build_phase = "${unpack a.zip}",
+    },
+    cross_haiku = {},
+  },
+  web = {
+    build_phase = "emcc main.c",
+  },
+}
+
+Data = {
+  version = "",
+  descripton = "typo",
+  targets = targets,
+}
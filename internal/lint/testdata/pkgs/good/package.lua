@@ -0,0 +1,32 @@
+local targets = {
+  linux = {
+    dependencies = { "zlib" },
+    fetch_phase = "${get https://example.com/good.tar.gz good.tar.gz}",
+    unpack_phase = "${unpack tar.gz good.tar.gz . strip_components=1}",
+    build_phase = [[
+      ${setenv CFLAGS -O2}
+      make PREFIX=$out
+    ]],
+  },
+  ["windows/386"] = {
+    cross_linux = {
+      dependencies = { "zlib" },
+      build_phase = "make CC=i686-w64-mingw32-gcc",
+    },
+  },
+  android = {
+    cross_linux = {
+      abis = { "arm64-v8a" },
+      build_phase = "make ABI=$abi",
+    },
+  },
+}
+
+targets.linux.cross_linux = targets.linux
+
+Data = {
+  name = "good",
+  version = 2,
+  description = "Package without mistakes",
+  targets = targets,
+}
@@ -0,0 +1,5 @@
+Data = {
+  name = "syntax",
+  version = "1"
+  targets = {},
+}
@@ -0,0 +1,11 @@
+Data = {
+  name = "zlib",
+  version = "1.3",
+  description = "Compression library",
+  targets = {
+    linux = {
+      include_dirs = { "include" },
+      libs = { "z" },
+    },
+  },
+}
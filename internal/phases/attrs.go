@@ -0,0 +1,65 @@
+package phases
+
+import (
+	"io/fs"
+	"os"
+	"time"
+)
+
+type dirAttrs struct {
+	path string
+	info fs.FileInfo
+}
+
+// Owner always can read and write extracted files, otherwise the package
+// cannot be removed or unpacked again
+func (e *extraction) mode(info fs.FileInfo) fs.FileMode {
+	perm := info.Mode().Perm()
+
+	if e.opts.Normalize {
+		if info.IsDir() || perm&0111 != 0 {
+			return 0755
+		}
+		return 0644
+	}
+
+	if info.IsDir() {
+		return perm | 0700
+	}
+	return perm | 0600
+}
+
+func (e *extraction) mtime(info fs.FileInfo) time.Time {
+	if e.opts.Normalize {
+		return e.opts.Mtime
+	}
+	return info.ModTime()
+}
+
+// Mode and mtime of extracted file or directory. Symlinks keep own mtime,
+// because it cannot be set portably
+func (e *extraction) setAttrs(pth string, info fs.FileInfo) (err error) {
+	if err = os.Chmod(pth, e.mode(info)); err != nil {
+		return
+	}
+
+	if mtime := e.mtime(info); !mtime.IsZero() {
+		err = os.Chtimes(pth, mtime, mtime)
+	}
+	return
+}
+
+func (e *extraction) addDir(pth string, info fs.FileInfo) {
+	e.dirs = append(e.dirs, dirAttrs{pth, info})
+}
+
+// Sets attributes of directories and reports rejected entries
+func (e *extraction) finish() (err error) {
+	for i := len(e.dirs) - 1; i >= 0; i-- {
+		if err = e.setAttrs(e.dirs[i].path, e.dirs[i].info); err != nil {
+			return
+		}
+	}
+
+	return e.result()
+}
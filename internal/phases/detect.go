@@ -0,0 +1,108 @@
+package phases
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"raypm/internal/errs"
+)
+
+type signature struct {
+	offset   int
+	magic    []byte
+	archType string
+}
+
+// Compressed streams are expected to be tarballs
+var signatures = []signature{
+	{0, []byte("PK\x03\x04"), "zip"},
+	{0, []byte("PK\x05\x06"), "zip"}, // Empty archive
+	{0, []byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}, "7z"},
+	{0, []byte{0x1f, 0x8b}, "tar.gz"},
+	{0, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, "tar.xz"},
+	{0, []byte{0x28, 0xb5, 0x2f, 0xfd}, "tar.zst"},
+	{0, []byte("BZh"), "tar.bz2"},
+	{0, []byte{0x04, 0x22, 0x4d, 0x18}, "tar.lz4"},
+	{257, []byte("ustar"), "tar"},
+}
+
+// Detects type of archive by its first bytes. Self-extracting executables
+// (PE) are detected by zip or 7z archive inside them
+func DetectArchive(pth string) (archType string, err error) {
+	f, err := os.Open(pth)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return
+	}
+	head = head[:n]
+	err = nil
+
+	if archType = detectHead(head); archType != "" {
+		return
+	}
+
+	if bytes.HasPrefix(head, []byte("MZ")) {
+		if r, lerr := zip.OpenReader(pth); lerr == nil {
+			r.Close()
+			return "zip", nil
+		}
+
+		if _, err = f.Seek(0, io.SeekStart); err != nil {
+			return
+		}
+
+		if found, lerr := contains(f, signatures[2].magic); lerr != nil {
+			return "", lerr
+		} else if found {
+			return "7z", nil
+		}
+	}
+
+	err = errs.New(errs.Unpack, "UnknownArchiveFormat", "'%s', set type in unpack: zip, 7z, tar, tar.gz, ...", pth)
+	return
+}
+
+// Type of archive by its first 512 bytes, empty if it is unknown
+func detectHead(head []byte) string {
+	for _, item := range signatures {
+		if bytes.HasPrefix(head[min(item.offset, len(head)):], item.magic) {
+			return item.archType
+		}
+	}
+	return ""
+}
+
+// Searches 'pattern' in the stream without reading it to memory
+func contains(r io.Reader, pattern []byte) (found bool, err error) {
+	br := bufio.NewReaderSize(r, 64*1024)
+	chunk := make([]byte, 64*1024)
+	buf := make([]byte, 0, len(chunk)+len(pattern))
+
+	for {
+		n, lerr := br.Read(chunk)
+		buf = append(buf, chunk[:n]...)
+
+		if bytes.Contains(buf, pattern) {
+			return true, nil
+		}
+
+		if lerr == io.EOF {
+			return false, nil
+		} else if lerr != nil {
+			return false, lerr
+		}
+
+		// Keep the tail, pattern could be split between chunks
+		if len(buf) >= len(pattern) {
+			buf = append(buf[:0], buf[len(buf)-len(pattern)+1:]...)
+		}
+	}
+}
@@ -1,54 +1,152 @@
 package phases
 
 import (
-	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
-	"raypm/pkg/progress"
+	"raypm/internal/errs"
+	"raypm/pkg/report"
 	log "raypm/pkg/slog"
+	"sync"
 )
 
-func GetFile(link, destPath string) (err error) {
-	downloader := http.DefaultClient
+type download struct {
+	done chan struct{}
+	dest string
+	err  error
+}
+
+// Links, that are downloading or downloaded by this process. Packages are
+// fetched in parallel, so the same link is downloaded once and copied to
+// other destinations
+var downloads = struct {
+	sync.Mutex
+	m map[string]*download
+}{m: map[string]*download{}}
 
+func GetFile(link, destPath string) (err error) {
 	if _, err = os.Stat(destPath); err == nil {
 		log.Warn("File '%s' exists, skip downloading\n", destPath)
-		err = nil
-		return
+		return nil
 	}
 
+	downloads.Lock()
+	d, started := downloads.m[link]
+	if !started {
+		d = &download{done: make(chan struct{}), dest: destPath}
+		downloads.m[link] = d
+	}
+	downloads.Unlock()
+
+	if started {
+		<-d.done
+		if d.err != nil {
+			return d.err
+		}
+
+		if _, err = os.Stat(d.dest); err == nil {
+			log.Info("'%s' is already downloaded to '%s'", link, d.dest)
+			return copyFile(d.dest, destPath)
+		}
+
+		// Downloaded file is removed, so download it again
+		downloads.Lock()
+		if downloads.m[link] == d {
+			delete(downloads.m, link)
+		}
+		downloads.Unlock()
+		return GetFile(link, destPath)
+	}
+
+	d.err = getFile(link, destPath)
+
+	if d.err != nil {
+		downloads.Lock()
+		delete(downloads.m, link)
+		downloads.Unlock()
+	}
+	close(d.done)
+
+	return d.err
+}
+
+func getFile(link, destPath string) (err error) {
+	downloader := http.DefaultClient
+
 	var resp *http.Response
 	if resp, err = downloader.Get(link); err != nil {
-		return
+		return errs.Wrap(errs.Fetch, "DownloadFailed", err, "'%s'", link)
 	}
 	defer resp.Body.Close()
 
-	src := progress.NewProgress(true, "Downloading", resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return errs.New(errs.Fetch, "DownloadFailed", "'%s': %s", link, resp.Status)
+	}
 
 	currDir := filepath.Dir(destPath)
 
 	if currDir != "" && currDir != "." && currDir != "."+string(os.PathSeparator) {
 		err = os.MkdirAll(currDir, 0754)
 		if err != nil {
-			err = fmt.Errorf("Failed to create a folder:\n%s\n", err)
-			log.Errorln(err)
-			return
+			return errs.Wrap(errs.Fetch, "CannotCreateFolder", err, "'%s'", currDir)
+		}
+	}
+
+	// File is written to '<dest>.part' and renamed after the download, so
+	// an interrupted download is not taken as downloaded file
+	part := destPath + ".part"
+	out, err := os.Create(part)
+	if err != nil {
+		return errs.Wrap(errs.Fetch, "CannotCreateFile", err, "'%s'", part)
+	}
+	defer func() {
+		out.Close()
+		if err != nil {
+			os.Remove(part)
 		}
+	}()
+
+	bar := report.StartFetch(link, filepath.Base(destPath), resp.ContentLength)
+	if _, err = io.Copy(out, bar.Reader(resp.Body)); err != nil {
+		err = errs.Wrap(errs.Fetch, "DownloadFailed", err, "'%s'", link)
 	}
+	bar.Finish(err)
 
-	out, err := os.Create(destPath)
 	if err != nil {
 		return
 	}
-	defer out.Close()
 
-	fmt.Println()
-	if _, err = io.Copy(out, src); err != nil {
-		err = fmt.Errorf("Failed to download a file:\n%s\n", err)
+	if err = out.Close(); err != nil {
+		return errs.Wrap(errs.Fetch, "DownloadFailed", err, "'%s'", link)
+	}
+
+	err = os.Rename(part, destPath)
+	return
+}
+
+// Hard link, or a copy if the link is impossible
+func copyFile(src, dst string) (err error) {
+	if err = os.MkdirAll(filepath.Dir(dst), 0754); err != nil {
+		return
 	}
-	fmt.Println()
 
+	if err = os.Link(src, dst); err == nil {
+		return
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
 	return
 }
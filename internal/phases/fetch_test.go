@@ -0,0 +1,64 @@
+package phases
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"raypm/internal/errs"
+	log "raypm/pkg/slog"
+	"testing"
+)
+
+func TestGetFile(t *testing.T) {
+	log.Init(false)
+
+	dir, err := os.MkdirTemp(os.TempDir(), "fetch_test_*")
+	if err != nil {
+		t.Errorf("Cannot create temp directory: %s\n", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServer(http.Dir("testdata")))
+	mux.HandleFunc("/broken.zip", func(w http.ResponseWriter, r *http.Request) {
+		// Connection is closed before the whole body is sent
+		w.Header().Set("Content-Length", "1024")
+		w.Write([]byte("PK"))
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	t.Run("interrupted download", func(t *testing.T) {
+		dest := path.Join(dir, "broken.zip")
+		if err := GetFile(server.URL+"/broken.zip", dest); !errors.Is(err, errs.Fetch) {
+			t.Errorf("Expect download error, got %v", err)
+		}
+
+		for _, item := range []string{dest, dest + ".part"} {
+			if _, err := os.Stat(item); err == nil {
+				t.Errorf("'%s' must be removed", item)
+			}
+		}
+	})
+
+	t.Run("download", func(t *testing.T) {
+		dest := path.Join(dir, "fetch", "arch.zip")
+		if err := GetFile(server.URL+"/recursive_unpack.zip", dest); err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		want, _ := os.ReadFile(path.Join("testdata", "recursive_unpack.zip"))
+		if got, err := os.ReadFile(dest); err != nil || string(got) != string(want) {
+			t.Errorf("Expect downloaded file (%v)", err)
+		}
+
+		if _, err := os.Stat(dest + ".part"); err == nil {
+			t.Error("Expect no '.part' file")
+		}
+	})
+}
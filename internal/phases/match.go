@@ -0,0 +1,140 @@
+package phases
+
+import (
+	"path"
+	"raypm/internal/errs"
+	"regexp"
+	"strings"
+)
+
+// Gitignore-like pattern:
+//   - '*' and '?' don't match '/', '**' matches any number of directories
+//   - pattern without '/' matches name at any depth, like '*.so*'
+//   - leading '/' anchors pattern to the root of the archive
+//   - trailing '/' matches only directories
+//
+// Pattern matches the entry, if it matches entry or one of its parents, so
+// 'include/' selects everything inside of 'include' directories
+type pattern struct {
+	re      *regexp.Regexp
+	dirOnly bool
+}
+
+func compilePattern(s string) (p pattern, err error) {
+	if s == "" || s == "/" {
+		err = errs.New(errs.Unpack, "EmptyPattern", "")
+		return
+	}
+
+	p.dirOnly = strings.HasSuffix(s, "/")
+	s = strings.TrimSuffix(s, "/")
+
+	anchored := strings.Contains(s, "/")
+	s = strings.TrimPrefix(s, "/")
+
+	var re strings.Builder
+	re.WriteString("^")
+	if !anchored {
+		re.WriteString("(.*/)?")
+	}
+
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case strings.HasPrefix(s[i:], "**/"):
+			re.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(s[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 {
+				err = errs.New(errs.Unpack, "WrongPattern", "'%s', unclosed '['", s)
+				return
+			}
+			class := s[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + class + "]")
+			i += end
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+
+	p.re, err = regexp.Compile(re.String())
+	return
+}
+
+func compilePatterns(ss []string) (ps []pattern, err error) {
+	for _, s := range ss {
+		p, lerr := compilePattern(s)
+		if lerr != nil {
+			return nil, lerr
+		}
+		ps = append(ps, p)
+	}
+	return
+}
+
+// Name is relative to the root of archive, without leading './'
+func (p pattern) match(name string, isDir bool) bool {
+	name = strings.TrimSuffix(name, "/")
+
+	for {
+		if p.re.MatchString(name) && (isDir || !p.dirOnly) {
+			return true
+		}
+
+		i := strings.LastIndexByte(name, '/')
+		if i < 0 {
+			return false
+		}
+		name, isDir = name[:i], true
+	}
+}
+
+func matchAny(ps []pattern, name string, isDir bool) bool {
+	for _, p := range ps {
+		if p.match(name, isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *extraction) selected(name string, isDir bool) bool {
+	if len(e.include) > 0 && !matchAny(e.include, name, isDir) {
+		return false
+	}
+	return !matchAny(e.exclude, name, isDir)
+}
+
+// Strips leading directories and renames the longest matching prefix,
+// empty result means the entry is skipped
+func (e *extraction) rename(name string) string {
+	parts := strings.Split(strings.Trim(path.Clean(name), "/"), "/")
+	if len(parts) <= e.opts.StripComponents {
+		return ""
+	}
+	name = path.Join(parts[e.opts.StripComponents:]...)
+
+	from, to := "", ""
+	for item, newName := range e.opts.Rename {
+		item = strings.Trim(path.Clean(item), "/")
+		if (name == item || strings.HasPrefix(name, item+"/")) && len(item) > len(from) {
+			from, to = item, newName
+		}
+	}
+
+	if from != "" {
+		name = path.Join(to, strings.TrimPrefix(name, from))
+	}
+	return name
+}
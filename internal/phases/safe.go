@@ -0,0 +1,157 @@
+package phases
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"raypm/internal/errs"
+	"raypm/pkg/report"
+	log "raypm/pkg/slog"
+	"strings"
+)
+
+// Limits against archive bombs, they are counted for the whole archive
+type Limits struct {
+	MaxEntries int
+	MaxSize    int64 // Summary size of extracted files
+}
+
+// Enough for NDK and SDKs
+var DefaultLimits = Limits{
+	MaxEntries: 500_000,
+	MaxSize:    32 << 30,
+}
+
+// State of one archive's extraction. Entries, that escape the destination
+// (by name, by symlink or through symlink on disk), are rejected, other
+// entries are extracted anyway
+type extraction struct {
+	dest     string
+	realDest string // Destination with resolved symlinks
+	limits   Limits
+	opts     UnpackOptions
+	entries  int
+	size     int64
+	rejected []string
+	dirs     []dirAttrs // Set after extraction, new entries change mtime
+	include  []pattern
+	exclude  []pattern
+	bar      *report.Transfer // Counts extracted bytes, if set
+}
+
+func newExtraction(dest string, opts UnpackOptions) (e *extraction, err error) {
+	e = &extraction{
+		dest:   path.Clean(filepath.ToSlash(dest)),
+		limits: DefaultLimits,
+		opts:   opts,
+	}
+
+	if e.include, err = compilePatterns(opts.Include); err != nil {
+		return
+	}
+
+	if e.exclude, err = compilePatterns(opts.Exclude); err != nil {
+		return
+	}
+
+	if err = os.MkdirAll(e.dest, 0754); err != nil {
+		return
+	}
+
+	e.realDest, err = filepath.EvalSymlinks(e.dest)
+	return
+}
+
+func within(dir, pth string) bool {
+	rel, err := filepath.Rel(dir, pth)
+	if err != nil {
+		return false
+	}
+
+	rel = filepath.ToSlash(rel)
+	return rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+// Checks name of entry and its path in the destination
+func (e *extraction) checkPath(name, destPath string) error {
+	if path.IsAbs(name) || strings.HasPrefix(name, `\`) || filepath.VolumeName(name) != "" {
+		return errs.New(errs.Unpack, "AbsolutePath", "'%s'", name)
+	}
+
+	if !within(e.dest, destPath) {
+		return errs.New(errs.Unpack, "PathEscapesDestination", "'%s'", name)
+	}
+
+	// Some parent could be a symlink, extracted earlier or existing before
+	dir := path.Dir(destPath)
+	for dir != e.dest && dir != path.Dir(dir) {
+		if _, err := os.Lstat(dir); err == nil {
+			break
+		}
+		dir = path.Dir(dir)
+	}
+
+	real, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return errs.Wrap(errs.Unpack, "UnresolvedPath", err, "'%s'", name)
+	}
+
+	if !within(e.realDest, real) {
+		return errs.New(errs.Unpack, "PathThroughSymlink", "'%s' is in '%s'", name, real)
+	}
+
+	return nil
+}
+
+// Symlinks must be relative and point inside the destination
+func (e *extraction) checkLink(name, destPath, linkName string) error {
+	if path.IsAbs(linkName) || filepath.IsAbs(linkName) || filepath.VolumeName(linkName) != "" {
+		return errs.New(errs.Unpack, "AbsoluteSymlink", "'%s' -> '%s'", name, linkName)
+	}
+
+	if !within(e.dest, path.Join(path.Dir(destPath), filepath.ToSlash(linkName))) {
+		return errs.New(errs.Unpack, "SymlinkEscapesDestination", "'%s' -> '%s'", name, linkName)
+	}
+
+	return nil
+}
+
+func (e *extraction) reject(name string, err error) {
+	log.Error("Rejected '%s': %s", name, err)
+	e.rejected = append(e.rejected, name)
+}
+
+func (e *extraction) countEntry() error {
+	e.entries++
+	if e.entries > e.limits.MaxEntries {
+		return errs.New(errs.Unpack, "TooManyEntries", "more than %d", e.limits.MaxEntries)
+	}
+	return nil
+}
+
+// Copies file's content, while summary size is in the limit
+func (e *extraction) copy(dst io.Writer, src io.Reader) error {
+	if e.bar != nil {
+		dst = e.bar.Writer(dst)
+	}
+
+	n, err := io.CopyN(dst, src, e.limits.MaxSize-e.size+1)
+	e.size += n
+
+	if e.size > e.limits.MaxSize {
+		return errs.New(errs.Unpack, "ArchiveIsTooLarge", "more than %d bytes", e.limits.MaxSize)
+	}
+
+	if err == io.EOF {
+		err = nil
+	}
+	return err
+}
+
+func (e *extraction) result() error {
+	if len(e.rejected) > 0 {
+		return errs.New(errs.Unpack, "UnsafeArchive", "%d entries rejected: %v", len(e.rejected), e.rejected)
+	}
+	return nil
+}
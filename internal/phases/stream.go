@@ -0,0 +1,254 @@
+package phases
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"raypm/internal/errs"
+	"raypm/pkg/report"
+	log "raypm/pkg/slog"
+	"strings"
+)
+
+type StreamOptions struct {
+	UnpackOptions
+
+	Keep   string // Path for downloaded archive, it is not kept if empty
+	Sha256 string // Expected checksum of the archive, not checked if empty
+}
+
+// Downloads and extracts the archive at the same time. Only tarballs can be
+// extracted from the stream, other archives are downloaded to Keep (or to a
+// temporary file) and unpacked after that.
+//
+// Checksum is computed while downloading, so for tarballs it is verified
+// after extraction. Archive is extracted into a temporary directory next to
+// the destination and moved there only on success, so failed download
+// doesn't leave a part of it in the destination
+func GetUnpack(link, archType, dest string, opts StreamOptions) (err error) {
+	if err = os.MkdirAll(filepath.Dir(dest), 0754); err != nil {
+		return errs.Wrap(errs.Unpack, "CannotCreateFolder", err, "'%s'", filepath.Dir(dest))
+	}
+
+	tmp, err := os.MkdirTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".part_*")
+	if err != nil {
+		return errs.Wrap(errs.Unpack, "CannotCreateFolder", err, "temporary folder for '%s'", dest)
+	}
+	defer os.RemoveAll(tmp)
+
+	// MkdirTemp makes it private, it could become the destination itself
+	if err = os.Chmod(tmp, 0754); err != nil {
+		return
+	}
+
+	if err = getUnpack(link, archType, tmp, opts); err != nil {
+		return
+	}
+
+	if err = moveInto(tmp, dest); err != nil {
+		err = errs.Wrap(errs.Unpack, "CannotMoveExtracted", err, "'%s'", dest)
+	}
+	return
+}
+
+func getUnpack(link, archType, dest string, opts StreamOptions) (err error) {
+	if opts.Keep != "" {
+		if _, err = os.Stat(opts.Keep); err == nil {
+			log.Warn("File '%s' exists, skip downloading\n", opts.Keep)
+			if err = checkSum(opts.Keep, opts.Sha256); err != nil {
+				return
+			}
+			return UnpackWith(archType, opts.Keep, dest, opts.UnpackOptions)
+		}
+
+		if err = os.MkdirAll(filepath.Dir(opts.Keep), 0754); err != nil {
+			return errs.Wrap(errs.Fetch, "CannotCreateFolder", err, "'%s'", filepath.Dir(opts.Keep))
+		}
+	}
+
+	resp, err := http.DefaultClient.Get(link)
+	if err != nil {
+		return errs.Wrap(errs.Fetch, "DownloadFailed", err, "'%s'", link)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errs.New(errs.Fetch, "DownloadFailed", "'%s': %s", link, resp.Status)
+	}
+
+	body := bufio.NewReaderSize(resp.Body, 64*1024)
+	if archType == "" || archType == "auto" {
+		head, _ := body.Peek(512)
+		if archType = detectHead(head); archType == "" {
+			archType = "auto" // Maybe self-extracting archive, detected from file
+		}
+		log.Debug("Detected type of '%s': %s", link, archType)
+	}
+
+	decompress, stream := tarDecompressors[archType]
+
+	// Archive is written to '<keep>.part', it is renamed after verification
+	var (
+		hash    = sha256.New()
+		outs    = []io.Writer{hash}
+		archive string
+		out     *os.File
+	)
+
+	switch {
+	case opts.Keep != "":
+		archive = opts.Keep
+		out, err = os.Create(archive + ".part")
+	case !stream:
+		out, err = os.CreateTemp(os.TempDir(), "raypm_*")
+		if err == nil {
+			archive = out.Name()
+		}
+	}
+
+	if err != nil {
+		return errs.Wrap(errs.Fetch, "CannotCreateFile", err, "archive of '%s'", link)
+	}
+
+	if out != nil {
+		// Temporary file, or '.part' if something is wrong
+		defer func() {
+			out.Close()
+			os.Remove(out.Name())
+		}()
+		outs = append(outs, out)
+	}
+
+	bar := report.StartFetch(link, path.Base(resp.Request.URL.Path), resp.ContentLength)
+	src := io.TeeReader(bar.Reader(body), io.MultiWriter(outs...))
+
+	if stream {
+		err = streamTar(decompress, src, link, dest, opts.UnpackOptions)
+	} else {
+		_, err = io.Copy(io.Discard, src)
+	}
+	bar.Finish(err)
+
+	if err != nil {
+		// Errors of extraction have own kind
+		var e *errs.Error
+		if !errors.As(err, &e) {
+			err = errs.Wrap(errs.Fetch, "DownloadFailed", err, "'%s'", link)
+		}
+		return
+	}
+
+	if err = verify(hash.Sum(nil), opts.Sha256, link); err != nil {
+		return
+	}
+
+	if out != nil {
+		if err = out.Close(); err != nil {
+			return
+		}
+
+		if opts.Keep != "" {
+			if err = os.Rename(out.Name(), opts.Keep); err != nil {
+				return
+			}
+		}
+	}
+
+	if !stream {
+		err = UnpackWith(archType, archive, dest, opts.UnpackOptions)
+	}
+
+	return
+}
+
+func streamTar(decompress decompressor, src io.Reader, name, dest string, opts UnpackOptions) (err error) {
+	e, err := newExtraction(dest, opts)
+	if err != nil {
+		return
+	}
+
+	if err = extractTar(decompress, src, name, e, opts.SelectedItems); err != nil {
+		return
+	}
+
+	// Rest of the stream (padding of tar, for example) is a part of checksum
+	if _, err = io.Copy(io.Discard, src); err != nil {
+		return
+	}
+
+	return e.finish()
+}
+
+// Moves entries to the directory. Existing files are kept, like when archive
+// is unpacked to the directory directly
+func moveInto(from, to string) (err error) {
+	if _, err = os.Lstat(to); os.IsNotExist(err) {
+		return os.Rename(from, to)
+	} else if err != nil {
+		return
+	}
+
+	entries, err := os.ReadDir(from)
+	if err != nil {
+		return
+	}
+
+	for _, item := range entries {
+		src, dst := filepath.Join(from, item.Name()), filepath.Join(to, item.Name())
+
+		info, lerr := os.Lstat(dst)
+		switch {
+		case os.IsNotExist(lerr):
+			err = os.Rename(src, dst)
+		case lerr != nil:
+			err = lerr
+		case item.IsDir() && info.IsDir():
+			err = moveInto(src, dst)
+		default:
+			log.Warn("File '%s' already exists, seems archive is already unpacked", dst)
+		}
+
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func checkSum(pth, want string) (err error) {
+	if want == "" {
+		return
+	}
+
+	f, err := os.Open(pth)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err = io.Copy(hash, f); err != nil {
+		return
+	}
+
+	return verify(hash.Sum(nil), want, pth)
+}
+
+func verify(sum []byte, want, name string) error {
+	if want == "" {
+		return nil
+	}
+
+	if got := hex.EncodeToString(sum); got != strings.ToLower(want) {
+		return errs.New(errs.Checksum, "ChecksumMismatch", "'%s' is '%s', expect '%s'", name, got, want)
+	}
+
+	return nil
+}
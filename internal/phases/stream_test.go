@@ -0,0 +1,109 @@
+package phases
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"raypm/internal/errs"
+	log "raypm/pkg/slog"
+	"testing"
+)
+
+func TestGetUnpack(t *testing.T) {
+	log.Init(false)
+
+	dir, err := os.MkdirTemp(os.TempDir(), "stream_test_*")
+	if err != nil {
+		t.Errorf("Cannot create temp directory: %s\n", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	server := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer server.Close()
+
+	data, _ := os.ReadFile(path.Join("testdata", "arch.tar.xz"))
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+
+	t.Run("stream tarball", func(t *testing.T) {
+		out := path.Join(dir, "stream")
+		if err := GetUnpack(server.URL+"/arch.tar.xz", "auto", out, StreamOptions{Sha256: checksum}); err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		checkForFiles(t, []string{path.Join(out, "arch", "first", "second", "low.txt")})
+	})
+
+	t.Run("keep archive", func(t *testing.T) {
+		keep := path.Join(dir, "fetch", "arch.tar.xz")
+		opts := StreamOptions{Keep: keep, UnpackOptions: UnpackOptions{StripComponents: 1}}
+		if err := GetUnpack(server.URL+"/arch.tar.xz", "tar.xz", path.Join(dir, "keep"), opts); err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		checkForFiles(t, []string{keep, path.Join(dir, "keep", "bin", "run.sh")})
+		if _, err := os.Stat(keep + ".part"); err == nil {
+			t.Error("Expect no '.part' file")
+		}
+	})
+
+	t.Run("zip is downloaded before unpacking", func(t *testing.T) {
+		out := path.Join(dir, "zip")
+		if err := GetUnpack(server.URL+"/recursive_unpack.zip", "", out, StreamOptions{}); err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		checkForFiles(t, []string{path.Join(out, "arch", "first", "second", "low.txt")})
+	})
+
+	t.Run("wrong checksum", func(t *testing.T) {
+		keep := path.Join(dir, "fetch", "wrong.tar.xz")
+		opts := StreamOptions{Keep: keep, Sha256: "00" + checksum[2:]}
+		if err := GetUnpack(server.URL+"/arch.tar.xz", "auto", path.Join(dir, "wrong"), opts); !errors.Is(err, errs.Checksum) {
+			t.Errorf("Expect checksum mismatch, got %v", err)
+		}
+
+		// Tarball is streamed, but nothing is left after the mismatch
+		for _, item := range []string{keep, keep + ".part", path.Join(dir, "wrong")} {
+			if _, err := os.Stat(item); err == nil {
+				t.Errorf("'%s' must be removed", item)
+			}
+		}
+
+		if parts, _ := filepath.Glob(path.Join(dir, ".wrong.part_*")); len(parts) > 0 {
+			t.Errorf("Expect no temporary folders, got %v", parts)
+		}
+	})
+
+	t.Run("existing destination", func(t *testing.T) {
+		out := path.Join(dir, "existing")
+		os.MkdirAll(path.Join(out, "arch"), 0754)
+		os.WriteFile(path.Join(out, "arch", "link.txt"), []byte("own"), 0644)
+		os.WriteFile(path.Join(out, "own.txt"), []byte("own"), 0644)
+
+		if err := GetUnpack(server.URL+"/arch.tar.xz", "auto", out, StreamOptions{Sha256: checksum}); err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		checkForFiles(t, []string{path.Join(out, "own.txt"), path.Join(out, "arch", "first", "second", "low.txt")})
+		if data, _ := os.ReadFile(path.Join(out, "arch", "link.txt")); string(data) != "own" {
+			t.Errorf("Expect existing file to be kept, got '%s'", data)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		if err := GetUnpack(server.URL+"/missing.tar.gz", "auto", path.Join(dir, "missing"), StreamOptions{}); !errors.Is(err, errs.Fetch) {
+			t.Errorf("Expect error for 404, got %v", err)
+		}
+	})
+}
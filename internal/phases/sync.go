@@ -3,9 +3,9 @@ package phases
 import (
 	"bufio"
 	"context"
-	"fmt"
 	"os"
 	"path"
+	"raypm/internal/errs"
 	log "raypm/pkg/slog"
 
 	"github.com/google/go-github/v69/github"
@@ -66,7 +66,8 @@ func Sync(raypmPath string) (pathToArchive, version string, err error) {
 
 	if _, err = os.Stat(cache); err != nil {
 		if err = os.MkdirAll(cache, 0754); err != nil {
-			err = fmt.Errorf("Failed to create '%s': '%s'", cache, err)
+			err = errs.Wrap(errs.Fetch, "CannotCreateFolder", err, "'%s'", cache)
+			return
 		}
 		log.Debugln("Created")
 	} else {
@@ -76,7 +77,7 @@ func Sync(raypmPath string) (pathToArchive, version string, err error) {
 	log.Debugln("Checking installed version")
 	if _, err = os.Stat(fInfoPath); err == nil {
 		if fInfo, err = os.Open(fInfoPath); err != nil {
-			log.Error("Failed to open '%s': %s", fInfoPath, err)
+			err = errs.Wrap(errs.Fetch, "CannotReadVersion", err, "'%s'", fInfoPath)
 			return
 		}
 		defer fInfo.Close()
@@ -92,7 +93,7 @@ func Sync(raypmPath string) (pathToArchive, version string, err error) {
 			)
 
 			if err = os.RemoveAll(pkgsPath); err != nil {
-				log.Error("Failed to remove '%s': %s", pkgsPath, err)
+				err = errs.Wrap(errs.Fetch, "CannotRemoveOldPkgs", err, "'%s'", pkgsPath)
 				return
 			}
 			log.Info("'pkgs' removed")
@@ -1,32 +1,55 @@
 package phases
 
 import (
+	"archive/tar"
 	"archive/zip"
-	"fmt"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
 	"io"
+	"io/fs"
 	"os"
 	"path"
+	"raypm/internal/errs"
+	"raypm/pkg/report"
 	log "raypm/pkg/slog"
 	"reflect"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/bodgit/sevenzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
 )
 
 type fileInArchive interface {
 	Open() (io.ReadCloser, error)
+	FileInfo() fs.FileInfo
 }
 
 type archive interface {
 	Close() error
 }
 
+// Entry of tar archive, its content can be read only before the next entry
+type tarFile struct {
+	*tar.Header
+	r io.Reader
+}
+
+func (f *tarFile) Open() (io.ReadCloser, error) {
+	return io.NopCloser(f.r), nil
+}
+
 var (
 	forArchZip = &zip.ReadCloser{}
 	forArch7z  = &sevenzip.ReadCloser{}
 
 	forZip = &zip.File{}
 	for7z  = &sevenzip.File{}
+	forTar = &tarFile{}
 )
 
 var (
@@ -35,44 +58,148 @@ var (
 
 	zipType      = reflect.TypeOf(forZip)
 	sevenzipType = reflect.TypeOf(for7z)
+	tarType      = reflect.TypeOf(forTar)
 )
 
+type decompressor func(io.Reader) (io.ReadCloser, error)
+
+// Tarballs by type of archive, short names are used by some upstreams
+var tarDecompressors = map[string]decompressor{
+	"tar": func(r io.Reader) (io.ReadCloser, error) {
+		return io.NopCloser(r), nil
+	},
+	"tar.gz": func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	},
+	"tar.bz2": func(r io.Reader) (io.ReadCloser, error) {
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	},
+	"tar.xz": func(r io.Reader) (io.ReadCloser, error) {
+		xr, err := xz.NewReader(r)
+		return io.NopCloser(xr), err
+	},
+	"tar.zst": func(r io.Reader) (io.ReadCloser, error) {
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	},
+	"tar.lz4": func(r io.Reader) (io.ReadCloser, error) {
+		return io.NopCloser(lz4.NewReader(r)), nil
+	},
+}
+
+func init() {
+	for alias, archType := range map[string]string{
+		"tgz": "tar.gz", "tbz2": "tar.bz2", "txz": "tar.xz", "tzst": "tar.zst",
+	} {
+		tarDecompressors[alias] = tarDecompressors[archType]
+	}
+}
+
+// Types of 'unpack' and 'get_unpack', 'auto' detects type by signature
+func ArchiveTypes() (types []string) {
+	types = []string{"auto", "zip", "7z"}
+	for archType := range tarDecompressors {
+		types = append(types, archType)
+	}
+	slices.Sort(types[3:])
+	return
+}
+
+type UnpackOptions struct {
+	SelectedItems []string
+
+	// Entry is extracted, if it matches one of Include patterns (or there are
+	// no patterns) and doesn't match Exclude ones, see 'pattern'
+	Include []string
+	Exclude []string
+
+	// Leading directories are removed from names like with 'tar
+	// --strip-components', then Rename replaces path prefixes
+	StripComponents int
+	Rename          map[string]string
+
+	// Modes are 0644 or 0755 and mtimes are Mtime, for reproducible builds
+	Normalize bool
+	Mtime     time.Time
+}
+
 func Unpack(archType, archSrc, dest string, selectedItems []string) (err error) {
+	return UnpackWith(archType, archSrc, dest, UnpackOptions{SelectedItems: selectedItems})
+}
+
+// Extracts archive with modes, mtimes and symlinks of its entries
+func UnpackWith(archType, archSrc, dest string, opts UnpackOptions) (err error) {
 	var (
-		r archive
+		r             archive
+		selectedItems = opts.SelectedItems
 	)
 
+	if archType == "" || archType == "auto" {
+		if archType, err = DetectArchive(archSrc); err != nil {
+			return
+		}
+		log.Debug("Detected type of '%s': %s", archSrc, archType)
+	}
+
+	e, err := newExtraction(dest, opts)
+	if err != nil {
+		return
+	}
+
+	if decompress, ok := tarDecompressors[archType]; ok {
+		if err = unpackTar(decompress, archSrc, e, selectedItems); err != nil {
+			return
+		}
+		return e.finish()
+	}
+
 	switch archType {
 	case "7z":
 		r, err = sevenzip.OpenReader(archSrc)
 	case "zip":
 		r, err = zip.OpenReader(archSrc)
 	default:
-		log.Error("Type '%s' is not supported yet.\n", archType)
-		err = fmt.Errorf("ArchiveFormatIsNotSupported")
-		return
+		return errs.New(errs.Unpack, "ArchiveFormatIsNotSupported", "'%s'", archType)
 	}
 
 	if err != nil {
-		log.Error("Failed to open archive '%s': %s\n", archSrc, err)
-		return
+		return errs.Wrap(errs.Unpack, "CannotOpenArchive", err, "'%s'", archSrc)
 	}
 	defer r.Close()
 
 	log.Debugln("Archive type is", reflect.TypeOf(r))
 
+	// Progress is counted by extracted bytes
+	var size int64
+	switch reflect.TypeOf(r) {
+	case zipArchType:
+		for _, f := range r.(*zip.ReadCloser).File {
+			size += int64(f.UncompressedSize64)
+		}
+	case sevenzipArchType:
+		for _, f := range r.(*sevenzip.ReadCloser).File {
+			size += int64(f.UncompressedSize)
+		}
+	}
+
+	e.bar = report.StartUnpack(path.Base(archSrc), size)
+	defer func() { e.bar.Finish(err) }()
+
 	switch reflect.TypeOf(r) {
 	case zipArchType:
 		log.Debugln("Archive is zip")
 		for _, f := range r.(*zip.ReadCloser).File {
-			if err = extractFile(f, dest, selectedItems); err != nil {
+			if err = extractFile(f, e, selectedItems); err != nil {
 				return err
 			}
 		}
 	case sevenzipArchType:
 		log.Debugln("Archive is 7z")
 		for _, f := range r.(*sevenzip.ReadCloser).File {
-			if err = extractFile(f, dest, selectedItems); err != nil {
+			if err = extractFile(f, e, selectedItems); err != nil {
 				return err
 			}
 		}
@@ -80,108 +207,261 @@ func Unpack(archType, archSrc, dest string, selectedItems []string) (err error)
 		log.Error("Something goes wrong: %v\n", reflect.TypeOf(r))
 	}
 
-	return err
+	return e.finish()
+}
+
+func unpackTar(decompress decompressor, archSrc string, e *extraction, selectedItems []string) (err error) {
+	f, err := os.Open(archSrc)
+	if err != nil {
+		return errs.Wrap(errs.Unpack, "CannotOpenArchive", err, "'%s'", archSrc)
+	}
+	defer f.Close()
+
+	var size int64
+	if info, err := f.Stat(); err == nil {
+		size = info.Size()
+	}
+
+	bar := report.StartUnpack(path.Base(archSrc), size)
+	err = extractTar(decompress, bar.Reader(f), archSrc, e, selectedItems)
+	bar.Finish(err)
+	return
+}
+
+// Extracts tarball from stream, 'name' is used in messages
+func extractTar(decompress decompressor, r io.Reader, name string, e *extraction, selectedItems []string) (err error) {
+	dr, err := decompress(r)
+	if err != nil {
+		return errs.Wrap(errs.Unpack, "CannotDecompress", err, "'%s'", name)
+	}
+	defer dr.Close()
+
+	log.Debugln("Archive is tar")
+	tr := tar.NewReader(dr)
+	for {
+		hdr, lerr := tr.Next()
+		if lerr == io.EOF {
+			break
+		} else if lerr != nil {
+			return errs.Wrap(errs.Unpack, "CannotReadArchive", lerr, "'%s'", name)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeDir, tar.TypeSymlink, tar.TypeLink:
+		default:
+			log.Debug("Skipping '%s' with type '%c'", hdr.Name, hdr.Typeflag)
+			continue
+		}
+
+		if err = extractFile(&tarFile{hdr, tr}, e, selectedItems); err != nil {
+			return
+		}
+	}
+
+	return
 }
 
 // Main problem, that this function just copy files and not recreating all
 // folders. For ex., file $fetch/bebra/touchme.c will copied as $src/touchme.c
-// TODO: Dest must be just string
-func extractFile(file fileInArchive, destDir string, selectedItems []string) (err error) {
+// Unsafe entries are rejected without error, limits of extraction stop it
+func extractFile(file fileInArchive, e *extraction, selectedItems []string) (err error) {
 	var (
 		fileName   string
-		isDir      bool
-		itemFound  bool = false
+		linkName   string
+		hardTarget string
 		checkItems bool = selectedItems != nil || len(selectedItems) > 0
+		info            = file.FileInfo()
+		isDir           = info.IsDir()
+		isLink          = info.Mode()&fs.ModeSymlink != 0
+		isHardLink      = false
 	)
 
 	switch reflect.TypeOf(file) {
 	case zipType:
 		fileName = file.(*zip.File).Name
-		isDir = file.(*zip.File).FileInfo().IsDir()
 	case sevenzipType:
 		fileName = file.(*sevenzip.File).Name
-		isDir = file.(*sevenzip.File).FileInfo().IsDir()
+	case tarType:
+		hdr := file.(*tarFile).Header
+		fileName = strings.TrimPrefix(hdr.Name, "./")
+		linkName = hdr.Linkname
+		isHardLink = hdr.Typeflag == tar.TypeLink
+	}
+
+	if fileName == "" || fileName == "." {
+		return
+	}
+
+	if err = e.countEntry(); err != nil {
+		return
 	}
 
 	log.Debug("Fname: %s; isDir: %t", fileName, isDir)
 
-	destPath := destDir
+	rel, itemFound := itemPath(fileName, selectedItems)
+	if checkItems && !itemFound {
+		log.Debug("Skipping '%s', because it doesn't match with: '%#v'", fileName, selectedItems)
+		return
+	}
 
-	for i := 0; checkItems && i < len(selectedItems) && !itemFound; i++ {
-		item := selectedItems[i]
+	if !e.selected(fileName, isDir) {
+		log.Debug("Skipping '%s', because of include and exclude patterns", fileName)
+		return
+	}
 
-		if strings.HasPrefix(fileName, item) {
-			log.Debug("Found item: '%s'", item)
-			itemFound = true
-			splited := strings.Split(item, "/")
-			depth := len(splited)
-			depth--
-			log.Debug("Depth is %d", depth)
-			endOfPath := (strings.Split(fileName, "/"))[depth:]
-			log.Debug("Second part of path is %v", endOfPath)
+	if rel = e.rename(rel); rel == "" {
+		log.Debug("Skipping '%s', nothing is left after stripping components", fileName)
+		return
+	}
+
+	destPath := path.Join(e.dest, rel)
+	log.Debug("Final path is '%s'", destPath)
 
-			// Maybe it looks like a shit
-			log.Debug("Full %s %v", destPath, endOfPath)
-			destPath = path.Join(destPath, path.Join(endOfPath...))
+	if err = e.checkPath(fileName, destPath); err != nil {
+		e.reject(fileName, err)
+		return nil
+	}
+
+	// Zip and 7z keep target of symlink as its content
+	if isLink && linkName == "" {
+		if linkName, err = readLink(file); err != nil {
+			return errs.Wrap(errs.Unpack, "CannotReadSymlink", err, "'%s'", fileName)
 		}
 	}
 
-	if !checkItems {
-		endOfPath := strings.Split(fileName, "/")
-		destPath = path.Join(destPath, path.Join(endOfPath...))
+	if isLink {
+		if err = e.checkLink(fileName, destPath, linkName); err != nil {
+			e.reject(fileName, err)
+			return nil
+		}
 	}
 
-	log.Debug("Final path is '%s'", destPath)
+	if isHardLink {
+		if hardTarget, err = e.hardLinkTarget(fileName, linkName, selectedItems); err != nil {
+			e.reject(fileName, err)
+			return nil
+		}
+	}
 
-	if checkItems && !itemFound {
-		log.Debug("Skipping '%s', because it doesn't match with: '%#v'", fileName, selectedItems)
+	if isDir {
+		log.Debugln("Item is a directory")
+		if err = os.MkdirAll(destPath, 0755); err != nil {
+			return
+		}
+		e.addDir(destPath, info)
 		return
 	}
 
-	if _, err = os.Stat(destPath); err == nil {
+	if _, err = os.Lstat(destPath); err == nil {
 		log.Warn(
 			"File '%s' already exists, seems archive is already unpacked", destPath,
 		)
+		return nil
+	}
+
+	if err = os.MkdirAll(path.Dir(destPath), 0755); err != nil {
 		return
 	}
 
-	if isDir {
-		log.Debugln("Item is a directory")
-		if err = os.MkdirAll(destPath, 0754); err != nil {
-			return
-		}
-		log.Debugln(destPath, "created")
-	} else {
-		rc, lerr := file.Open()
+	if isLink {
+		log.Debug("Item is a symlink to '%s'", linkName)
+		return os.Symlink(linkName, destPath)
+	}
 
-		if lerr != nil {
-			err = lerr
-			return
-		}
-		defer rc.Close()
-		log.Debug("Opened '%s'", fileName)
+	if isHardLink {
+		log.Debug("Item is a hard link to '%s'", hardTarget)
+		return os.Link(hardTarget, destPath)
+	}
 
-		log.Debugln("Item is a file")
-		baseDest := path.Dir(destPath)
+	rc, err := file.Open()
+	if err != nil {
+		return
+	}
+	defer rc.Close()
 
-		if _, err = os.Stat(baseDest); err != nil {
-			if err = os.MkdirAll(baseDest, 0754); err != nil {
-				return
-			}
-		}
+	log.Debugln("Item is a file")
+	dstFile, err := os.OpenFile(destPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return
+	}
 
-		dstFile, err := os.Create(destPath)
-		if err != nil {
-			return err
+	if err = e.copy(dstFile, rc); err != nil {
+		dstFile.Close()
+		if !errors.Is(err, errs.Unpack) {
+			err = errs.Wrap(errs.Unpack, "CannotExtract", err, "'%s'", fileName)
 		}
-		defer dstFile.Close()
+		return
+	}
 
-		log.Debugln("File", destPath, "created")
+	if err = dstFile.Close(); err != nil {
+		return
+	}
+	log.Debugln("File", destPath, "created")
 
-		if _, err = io.Copy(dstFile, rc); err != nil {
-			return err
+	return e.setAttrs(destPath, info)
+}
+
+// Path of the entry after choosing of selected items. Without selected items
+// it's the name itself
+func itemPath(fileName string, selectedItems []string) (rel string, itemFound bool) {
+	rel = fileName
+
+	for i := 0; i < len(selectedItems) && !itemFound; i++ {
+		item := selectedItems[i]
+
+		if strings.HasPrefix(fileName, item) {
+			log.Debug("Found item: '%s'", item)
+			itemFound = true
+			splited := strings.Split(item, "/")
+			depth := len(splited)
+			depth--
+			log.Debug("Depth is %d", depth)
+			endOfPath := (strings.Split(fileName, "/"))[depth:]
+			log.Debug("Second part of path is %v", endOfPath)
+
+			rel = path.Join(endOfPath...)
 		}
 	}
 
 	return
 }
+
+// Hard link of tar names an earlier entry of the archive. The entry goes
+// through the same selection and checks, as the link itself, and must be
+// already extracted as a regular file
+func (e *extraction) hardLinkTarget(name, linkName string, selectedItems []string) (target string, err error) {
+	linkName = strings.TrimPrefix(linkName, "./")
+
+	rel, itemFound := itemPath(linkName, selectedItems)
+	if (len(selectedItems) > 0 && !itemFound) || !e.selected(linkName, false) {
+		err = errs.New(errs.Unpack, "HardLinkTargetSkipped", "'%s' -> '%s'", name, linkName)
+		return
+	}
+
+	if rel = e.rename(rel); rel == "" {
+		err = errs.New(errs.Unpack, "HardLinkTargetSkipped", "'%s' -> '%s'", name, linkName)
+		return
+	}
+
+	target = path.Join(e.dest, rel)
+	if err = e.checkPath(linkName, target); err != nil {
+		return
+	}
+
+	if info, lerr := os.Lstat(target); lerr != nil || !info.Mode().IsRegular() {
+		err = errs.New(errs.Unpack, "WrongHardLink", "'%s' -> '%s' is not an extracted file", name, linkName)
+	}
+
+	return
+}
+
+func readLink(file fileInArchive) (link string, err error) {
+	rc, err := file.Open()
+	if err != nil {
+		return
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(io.LimitReader(rc, 4096))
+	return string(data), err
+}
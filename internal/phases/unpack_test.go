@@ -1,11 +1,14 @@
 package phases
 
 import (
+	"archive/tar"
+	"archive/zip"
 	"fmt"
 	"os"
 	"path"
 	log "raypm/pkg/slog"
 	"testing"
+	"time"
 )
 
 func TestUnpack(t *testing.T) {
@@ -119,3 +122,400 @@ func deleteTmpFiles(endOfPath string) {
 		log.Errorln("Failed to delete:", err)
 	}
 }
+
+func TestUnpackTar(t *testing.T) {
+	log.Init(false)
+
+	dir, err := os.MkdirTemp(os.TempDir(), "unpack_test_*")
+	if err != nil {
+		t.Errorf("Cannot create temp directory: %s\n", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	for _, archType := range []string{"tar", "tar.gz", "tar.xz", "tar.zst", "tar.bz2", "tar.lz4"} {
+		t.Run(archType, func(t *testing.T) {
+			out := path.Join(dir, archType)
+			if err := Unpack(archType, path.Join("testdata", "arch."+archType), out, nil); err != nil {
+				t.Error(err)
+				t.FailNow()
+			}
+
+			checkForFiles(t, []string{
+				path.Join(out, "arch", "first", "second", "low.txt"),
+				path.Join(out, "arch", "bin", "run.sh"),
+			})
+
+			if info, err := os.Stat(path.Join(out, "arch", "bin", "run.sh")); err == nil && info.Mode()&0111 == 0 {
+				t.Error("Expect executable 'run.sh'")
+			}
+
+			if link, err := os.Readlink(path.Join(out, "arch", "link.txt")); err != nil || link != "first/second/low.txt" {
+				t.Errorf("Expect symlink to 'first/second/low.txt', got '%s' (%v)", link, err)
+			}
+		})
+	}
+
+	t.Run("selected items", func(t *testing.T) {
+		out := path.Join(dir, "selected")
+		if err := Unpack("tgz", path.Join("testdata", "arch.tar.gz"), out, []string{"arch/first"}); err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		checkForFiles(t, []string{path.Join(out, "first", "second", "low.txt")})
+		if _, err := os.Stat(path.Join(out, "bin")); err == nil {
+			t.Error("Expect only selected items")
+		}
+	})
+}
+
+func TestDetectArchive(t *testing.T) {
+	log.Init(false)
+
+	dir, err := os.MkdirTemp(os.TempDir(), "unpack_test_*")
+	if err != nil {
+		t.Errorf("Cannot create temp directory: %s\n", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	zipData, _ := os.ReadFile(path.Join("testdata", "recursive_unpack.zip"))
+	stub := append([]byte("MZ"), make([]byte, 100*1024)...)
+
+	os.WriteFile(path.Join(dir, "zip.exe"), append(stub, zipData...), 0644)
+	os.WriteFile(path.Join(dir, "7z.exe"), append(stub, '7', 'z', 0xbc, 0xaf, 0x27, 0x1c, 0, 4), 0644)
+	os.WriteFile(path.Join(dir, "plain.exe"), stub, 0644)
+	os.WriteFile(path.Join(dir, "text.txt"), []byte("hello"), 0644)
+
+	t.Run("signatures", func(t *testing.T) {
+		tests := map[string]string{
+			path.Join("testdata", "recursive_unpack.zip"): "zip",
+			path.Join("testdata", "arch.tar"):             "tar",
+			path.Join("testdata", "arch.tar.gz"):          "tar.gz",
+			path.Join("testdata", "arch.tar.xz"):          "tar.xz",
+			path.Join("testdata", "arch.tar.zst"):         "tar.zst",
+			path.Join("testdata", "arch.tar.bz2"):         "tar.bz2",
+			path.Join("testdata", "arch.tar.lz4"):         "tar.lz4",
+			path.Join(dir, "zip.exe"):                     "zip",
+			path.Join(dir, "7z.exe"):                      "7z",
+		}
+
+		for pth, want := range tests {
+			if got, err := DetectArchive(pth); err != nil || got != want {
+				t.Errorf("Expect '%s' for '%s', got '%s' (%v)", want, pth, got, err)
+			}
+		}
+	})
+
+	t.Run("unknown formats", func(t *testing.T) {
+		for _, item := range []string{"plain.exe", "text.txt"} {
+			if _, err := DetectArchive(path.Join(dir, item)); err == nil {
+				t.Errorf("Expect error for '%s'", item)
+			}
+		}
+	})
+
+	t.Run("unpack self-extracting zip", func(t *testing.T) {
+		out := path.Join(dir, "out")
+		if err := Unpack("auto", path.Join(dir, "zip.exe"), out, nil); err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		checkForFiles(t, []string{path.Join(out, "arch", "first", "second", "low.txt")})
+	})
+}
+
+func TestUnpackUnsafe(t *testing.T) {
+	log.Init(false)
+
+	dir, err := os.MkdirTemp(os.TempDir(), "unpack_test_*")
+	if err != nil {
+		t.Errorf("Cannot create temp directory: %s\n", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	zipFile := path.Join(dir, "evil.zip")
+	if f, err := os.Create(zipFile); err == nil {
+		w := zip.NewWriter(f)
+		for _, name := range []string{"../escaped.txt", "/absolute.txt", "a/../../escaped2.txt", "good.txt"} {
+			fw, _ := w.Create(name)
+			fw.Write([]byte(name))
+		}
+		w.Close()
+		f.Close()
+	}
+
+	tarFile := path.Join(dir, "evil.tar")
+	if f, err := os.Create(tarFile); err == nil {
+		w := tar.NewWriter(f)
+		links := map[string]string{"abs": "/etc", "up": "../..", "inside": "good/../good.txt"}
+		for _, name := range []string{"abs", "up", "inside"} {
+			w.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeSymlink, Linkname: links[name]})
+		}
+		w.WriteHeader(&tar.Header{Name: "outside/file.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 4})
+		w.Write([]byte("evil"))
+		w.Close()
+		f.Close()
+	}
+
+	t.Run("escaping names", func(t *testing.T) {
+		out := path.Join(dir, "zip", "out")
+		err := Unpack("zip", zipFile, out, nil)
+		if err == nil {
+			t.Error("Expect error for unsafe archive")
+		}
+
+		checkForFiles(t, []string{path.Join(out, "good.txt")})
+		for _, item := range []string{path.Join(dir, "zip", "escaped.txt"), path.Join(dir, "escaped2.txt"), path.Join(out, "absolute.txt")} {
+			if _, err := os.Stat(item); err == nil {
+				t.Errorf("'%s' must not be extracted", item)
+			}
+		}
+	})
+
+	t.Run("symlinks", func(t *testing.T) {
+		out := path.Join(dir, "tar")
+		os.MkdirAll(out, 0754)
+		os.Symlink(dir, path.Join(out, "outside")) // Existing symlink outside of destination
+
+		if err := Unpack("tar", tarFile, out, nil); err == nil {
+			t.Error("Expect error for unsafe archive")
+		}
+
+		if _, err := os.Lstat(path.Join(out, "inside")); err != nil {
+			t.Error("Expect symlink inside of destination")
+		}
+
+		for _, item := range []string{path.Join(out, "abs"), path.Join(out, "up"), path.Join(dir, "file.txt")} {
+			if _, err := os.Lstat(item); err == nil {
+				t.Errorf("'%s' must not be extracted", item)
+			}
+		}
+	})
+
+	t.Run("hard links", func(t *testing.T) {
+		hardFile := path.Join(dir, "hard.tar")
+		if f, err := os.Create(hardFile); err == nil {
+			w := tar.NewWriter(f)
+			w.WriteHeader(&tar.Header{Name: "./lib/libz.so.1", Typeflag: tar.TypeReg, Mode: 0644, Size: 4})
+			w.Write([]byte("libz"))
+			links := map[string]string{"lib/libz.so": "./lib/libz.so.1", "up": "../escaped.txt", "missing": "lib/none"}
+			for _, name := range []string{"lib/libz.so", "up", "missing"} {
+				w.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeLink, Linkname: links[name]})
+			}
+			w.Close()
+			f.Close()
+		}
+
+		out := path.Join(dir, "hard")
+		if err := Unpack("tar", hardFile, out, nil); err == nil {
+			t.Error("Expect error for unsafe archive")
+		}
+
+		first, err := os.Stat(path.Join(out, "lib", "libz.so.1"))
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		if second, err := os.Stat(path.Join(out, "lib", "libz.so")); err != nil || !os.SameFile(first, second) {
+			t.Errorf("Expect hard link to 'lib/libz.so.1' (%v)", err)
+		}
+
+		for _, item := range []string{path.Join(out, "up"), path.Join(out, "missing")} {
+			if _, err := os.Lstat(item); err == nil {
+				t.Errorf("'%s' must not be extracted", item)
+			}
+		}
+	})
+
+	t.Run("limits", func(t *testing.T) {
+		defer func(l Limits) { DefaultLimits = l }(DefaultLimits)
+
+		DefaultLimits = Limits{MaxEntries: 1000, MaxSize: 3}
+		if err := Unpack("tar.gz", path.Join("testdata", "arch.tar.gz"), path.Join(dir, "size"), nil); err == nil {
+			t.Error("Expect error for too large archive")
+		}
+
+		DefaultLimits = Limits{MaxEntries: 2, MaxSize: 1 << 20}
+		if err := Unpack("tar.gz", path.Join("testdata", "arch.tar.gz"), path.Join(dir, "entries"), nil); err == nil {
+			t.Error("Expect error for too many entries")
+		}
+	})
+}
+
+func TestUnpackAttributes(t *testing.T) {
+	log.Init(false)
+
+	dir, err := os.MkdirTemp(os.TempDir(), "unpack_test_*")
+	if err != nil {
+		t.Errorf("Cannot create temp directory: %s\n", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	mtime := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	zipFile := path.Join(dir, "attrs.zip")
+	if f, err := os.Create(zipFile); err == nil {
+		w := zip.NewWriter(f)
+		for name, mode := range map[string]os.FileMode{
+			"bin/gcc":         0755,
+			"lib/libfoo.so.1": 0644,
+			"lib/libfoo.so":   0777 | os.ModeSymlink,
+		} {
+			hdr := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: mtime}
+			hdr.SetMode(mode)
+			fw, _ := w.CreateHeader(hdr)
+			if mode&os.ModeSymlink != 0 {
+				fw.Write([]byte("libfoo.so.1"))
+			} else {
+				fw.Write([]byte(name))
+			}
+		}
+		w.Close()
+		f.Close()
+	}
+
+	t.Run("zip", func(t *testing.T) {
+		out := path.Join(dir, "zip")
+		if err := Unpack("zip", zipFile, out, nil); err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		if info, err := os.Stat(path.Join(out, "bin", "gcc")); err != nil || info.Mode().Perm() != 0755 {
+			t.Errorf("Expect mode 0755 of 'bin/gcc': %v", info)
+		} else if !info.ModTime().Equal(mtime) {
+			t.Errorf("Expect mtime %s, got %s", mtime, info.ModTime())
+		}
+
+		if link, err := os.Readlink(path.Join(out, "lib", "libfoo.so")); err != nil || link != "libfoo.so.1" {
+			t.Errorf("Expect symlink to 'libfoo.so.1', got '%s' (%v)", link, err)
+		}
+	})
+
+	t.Run("tar", func(t *testing.T) {
+		out := path.Join(dir, "tar")
+		if err := Unpack("tar", path.Join("testdata", "arch.tar"), out, nil); err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		want := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		for _, item := range []string{"arch", "arch/first", "arch/first/second/low.txt"} {
+			if info, err := os.Stat(path.Join(out, item)); err != nil || !info.ModTime().Equal(want) {
+				t.Errorf("Expect mtime %s of '%s': %v", want, item, info)
+			}
+		}
+	})
+
+	t.Run("normalize", func(t *testing.T) {
+		out := path.Join(dir, "normalized")
+		epoch := time.Unix(0, 0)
+		if err := UnpackWith("zip", zipFile, out, UnpackOptions{Normalize: true, Mtime: epoch}); err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		tests := map[string]os.FileMode{"bin/gcc": 0755, "lib/libfoo.so.1": 0644}
+		for item, mode := range tests {
+			info, err := os.Stat(path.Join(out, item))
+			if err != nil {
+				t.Error(err)
+				continue
+			}
+
+			if info.Mode().Perm() != mode || !info.ModTime().Equal(epoch) {
+				t.Errorf("Expect %o and %s for '%s', got %o and %s", mode, epoch, item, info.Mode().Perm(), info.ModTime())
+			}
+		}
+	})
+}
+
+func TestPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		isDir   bool
+		want    bool
+	}{
+		{"*.so*", "raylib/lib/libraylib.so.5", false, true},
+		{"*.so*", "raylib/lib/libraylib.a", false, false},
+		{"raylib/lib/*.so*", "raylib/lib/libraylib.so", false, true},
+		{"raylib/lib/*.so*", "other/raylib/lib/libraylib.so", false, false},
+		{"/include", "include/raylib.h", false, true},
+		{"include/", "raylib/include/raylib.h", false, true},
+		{"include/", "raylib/include", false, false},
+		{"**/examples/**", "raylib/src/examples/core/main.c", false, true},
+		{"lib?.a", "libm.a", false, true},
+		{"[!a]*.txt", "readme.txt", false, true},
+		{"[!a]*.txt", "a.txt", false, false},
+	}
+
+	for _, item := range tests {
+		p, err := compilePattern(item.pattern)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		if got := p.match(item.name, item.isDir); got != item.want {
+			t.Errorf("'%s' for '%s': expect %t, got %t", item.pattern, item.name, item.want, got)
+		}
+	}
+}
+
+func TestUnpackFilters(t *testing.T) {
+	log.Init(false)
+
+	dir, err := os.MkdirTemp(os.TempDir(), "unpack_test_*")
+	if err != nil {
+		t.Errorf("Cannot create temp directory: %s\n", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	arch := path.Join("testdata", "arch.tar.gz")
+
+	t.Run("strip and include", func(t *testing.T) {
+		out := path.Join(dir, "include")
+		opts := UnpackOptions{StripComponents: 2, Include: []string{"arch/first/**/*.txt"}}
+		if err := UnpackWith("auto", arch, out, opts); err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		checkForFiles(t, []string{path.Join(out, "second", "low.txt")})
+		if entries, _ := os.ReadDir(out); len(entries) != 1 {
+			t.Errorf("Expect only 'second', got %v", entries)
+		}
+	})
+
+	t.Run("exclude and rename", func(t *testing.T) {
+		out := path.Join(dir, "rename")
+		opts := UnpackOptions{
+			Exclude: []string{"*.sh", "link.txt"},
+			Rename:  map[string]string{"arch/first/": "data", "arch": "root"},
+		}
+		if err := UnpackWith("auto", arch, out, opts); err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		checkForFiles(t, []string{path.Join(out, "data", "second", "low.txt"), path.Join(out, "root", "bin")})
+		for _, item := range []string{"root/bin/run.sh", "root/link.txt", "arch"} {
+			if _, err := os.Lstat(path.Join(out, item)); err == nil {
+				t.Errorf("'%s' must not be extracted", item)
+			}
+		}
+	})
+
+	t.Run("wrong pattern", func(t *testing.T) {
+		if err := UnpackWith("auto", arch, path.Join(dir, "wrong"), UnpackOptions{Include: []string{"[a"}}); err == nil {
+			t.Error("Expect error for '[a'")
+		}
+	})
+}
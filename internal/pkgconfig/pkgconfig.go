@@ -0,0 +1,297 @@
+// pkg-config files for installed packages. Each package keeps its .pc file
+// in its own directory, so it's switched with generations. Makefiles and CGO
+// could use 'PKG_CONFIG_PATH' or 'raypm pkg-config'
+package pkgconfig
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"raypm/internal/errs"
+	log "raypm/pkg/slog"
+	"slices"
+	"strings"
+)
+
+type Pc struct {
+	Name        string
+	Description string
+	Version     string
+	Vars        [][2]string // Keeping order, because variables could use previous ones
+	Requires    []string
+	Cflags      []string
+	Libs        []string
+}
+
+// Directory with .pc file of the package, 'out' is its directory. It's not
+// 'lib/pkgconfig', so files of the package itself are not overwritten
+func Dir(out string) string {
+	return path.Join(out, ".raypm", "pkgconfig")
+}
+
+// Path of '<name>.pc' in the first of 'dirs', that has it. Returns empty
+// string, if there is no such file
+func Find(dirs []string, name string) string {
+	for _, item := range dirs {
+		pth := path.Join(item, name+".pc")
+		if _, err := os.Stat(pth); err == nil {
+			return pth
+		}
+	}
+
+	return ""
+}
+
+func Write(pth string, pc *Pc) (err error) {
+	if err = os.MkdirAll(path.Dir(pth), 0754); err != nil {
+		err = errs.Wrap(errs.Phase, "CannotCreateFolder", err, "'%s'", path.Dir(pth))
+		return
+	}
+
+	f, err := os.Create(pth)
+	if err != nil {
+		err = errs.Wrap(errs.Phase, "CannotCreateFile", err, "'%s'", pth)
+		return
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	for _, item := range pc.Vars {
+		fmt.Fprintf(w, "%s=%s\n", item[0], item[1])
+	}
+
+	fmt.Fprintf(w, "\nName: %s\n", pc.Name)
+	fmt.Fprintf(w, "Description: %s\n", pc.Description)
+	fmt.Fprintf(w, "Version: %s\n", pc.Version)
+
+	if len(pc.Requires) > 0 {
+		fmt.Fprintf(w, "Requires: %s\n", strings.Join(pc.Requires, " "))
+	}
+
+	fmt.Fprintf(w, "Cflags: %s\n", strings.Join(pc.Cflags, " "))
+	fmt.Fprintf(w, "Libs: %s\n", strings.Join(pc.Libs, " "))
+
+	err = w.Flush()
+	return
+}
+
+func Read(pth string) (pc *Pc, err error) {
+	f, err := os.Open(pth)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	pc = &Pc{}
+	vars := make(map[string]string)
+	scan := bufio.NewScanner(f)
+
+	for scan.Scan() {
+		line := strings.TrimSpace(scan.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// 'Key: value' is a field, 'key=value' is a variable
+		if i := strings.IndexAny(line, ":="); i > 0 {
+			key := strings.TrimSpace(line[:i])
+			value := expand(strings.TrimSpace(line[i+1:]), vars)
+
+			if line[i] == '=' {
+				vars[key] = value
+				pc.Vars = append(pc.Vars, [2]string{key, value})
+				continue
+			}
+
+			switch key {
+			case "Name":
+				pc.Name = value
+			case "Description":
+				pc.Description = value
+			case "Version":
+				pc.Version = value
+			case "Requires":
+				pc.Requires = parseRequires(value)
+			case "Cflags":
+				pc.Cflags = strings.Fields(value)
+			case "Libs":
+				pc.Libs = strings.Fields(value)
+			}
+		}
+	}
+
+	err = scan.Err()
+	return
+}
+
+// Names of packages in 'Requires', version constraints like 'glib-2.0 >= 2.50'
+// are dropped
+func parseRequires(value string) (names []string) {
+	fields := strings.Fields(strings.ReplaceAll(value, ",", " "))
+
+	for i := 0; i < len(fields); i++ {
+		item := fields[i]
+
+		// Operator with the version, the version could be the next field
+		if j := strings.IndexAny(item, "<>=!"); j >= 0 {
+			if j > 0 {
+				names = append(names, item[:j])
+			}
+
+			if strings.TrimLeft(item[j:], "<>=!") == "" {
+				i++
+			}
+			continue
+		}
+
+		names = append(names, item)
+	}
+
+	return
+}
+
+func expand(value string, vars map[string]string) string {
+	for {
+		start := strings.Index(value, "${")
+		if start < 0 {
+			return value
+		}
+
+		end := strings.Index(value[start:], "}")
+		if end < 0 {
+			return value
+		}
+		end += start
+
+		value = value[:start] + vars[value[start+2:end]] + value[end+1:]
+	}
+}
+
+type Query struct {
+	Cflags     bool
+	Libs       bool
+	ModVersion bool
+	Exists     bool
+	Target     string
+	Pkgs       []string
+}
+
+// Parses arguments like real pkg-config does: '--cflags --libs raylib'.
+// Unknown options, like '--static', are ignored
+func ParseArgs(args []string) (q *Query, err error) {
+	q = &Query{}
+
+	for i := 0; i < len(args); i++ {
+		item := args[i]
+
+		if !strings.HasPrefix(item, "-") {
+			q.Pkgs = append(q.Pkgs, item)
+			continue
+		}
+
+		name, value, hasValue := strings.Cut(strings.TrimLeft(item, "-"), "=")
+
+		switch name {
+		case "cflags":
+			q.Cflags = true
+		case "libs":
+			q.Libs = true
+		case "modversion":
+			q.ModVersion = true
+		case "exists":
+			q.Exists = true
+		case "target":
+			if !hasValue {
+				if i+1 >= len(args) {
+					err = errs.New(errs.Usage, "NoTarget", "'--target' needs a value")
+					return
+				}
+				i++
+				value = args[i]
+			}
+			q.Target = value
+		default:
+			log.Debug("Ignoring '%s'", item)
+		}
+	}
+
+	if len(q.Pkgs) == 0 {
+		err = errs.New(errs.Usage, "NoPackages", "usage: raypm pkg-config [--cflags] [--libs] <package>...")
+	}
+
+	return
+}
+
+// Prints requested flags of packages in 'dirs' and of packages they require
+func (q *Query) Run(dirs []string, w io.Writer) (err error) {
+	var (
+		cflags []string
+		libs   []string
+		seen   = make(map[string]bool)
+	)
+
+	var collect func(name string) error
+	collect = func(name string) (err error) {
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+
+		pth := Find(dirs, name)
+		if pth == "" {
+			return errs.New(errs.Resolution, "PackageNotFound", "'%s' is not installed for the target", name)
+		}
+
+		pc, err := Read(pth)
+		if err != nil {
+			return
+		}
+
+		if q.ModVersion && slices.Contains(q.Pkgs, name) {
+			fmt.Fprintln(w, pc.Version)
+		}
+
+		cflags = appendNew(cflags, pc.Cflags)
+		libs = appendNew(libs, pc.Libs)
+
+		for _, item := range pc.Requires {
+			if err = collect(item); err != nil {
+				return
+			}
+		}
+
+		return
+	}
+
+	for _, item := range q.Pkgs {
+		if err = collect(item); err != nil {
+			return
+		}
+	}
+
+	flags := make([]string, 0)
+	if q.Cflags {
+		flags = append(flags, cflags...)
+	}
+
+	if q.Libs {
+		flags = append(flags, libs...)
+	}
+
+	if q.Cflags || q.Libs {
+		fmt.Fprintln(w, strings.Join(flags, " "))
+	}
+
+	return
+}
+
+func appendNew(s []string, items []string) []string {
+	for _, item := range items {
+		if !slices.Contains(s, item) {
+			s = append(s, item)
+		}
+	}
+	return s
+}
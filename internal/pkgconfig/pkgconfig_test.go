@@ -0,0 +1,153 @@
+package pkgconfig
+
+import (
+	"errors"
+	"os"
+	"path"
+	"raypm/internal/errs"
+	log "raypm/pkg/slog"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPkgConfig(t *testing.T) {
+	log.Init(false)
+
+	dir, err := os.MkdirTemp(os.TempDir(), "pkgconfig_test_*")
+	if err != nil {
+		t.Errorf("Cannot create temp directory: %s\n", err)
+		t.FailNow()
+	}
+
+	glfw := &Pc{
+		Name:        "glfw",
+		Description: "Window library",
+		Version:     "3.4",
+		Vars:        [][2]string{{"prefix", "/store/a-glfw-3.4"}},
+		Cflags:      []string{"-I${prefix}/include"},
+		Libs:        []string{"-L${prefix}/lib", "-lglfw"},
+	}
+
+	raylib := &Pc{
+		Name:        "raylib",
+		Description: "Videogames programming library",
+		Version:     "5.0",
+		Vars:        [][2]string{{"prefix", "/store/b-raylib-5.0"}},
+		Requires:    []string{"glfw"},
+		Cflags:      []string{"-I${prefix}/include"},
+		Libs:        []string{"-L${prefix}/lib", "-lraylib", "-lm"},
+	}
+
+	for _, item := range []*Pc{glfw, raylib} {
+		if err = Write(path.Join(dir, item.Name+".pc"), item); err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+	}
+
+	t.Run("read", func(t *testing.T) {
+		pc, err := Read(path.Join(dir, "raylib.pc"))
+		if err != nil {
+			t.Error(err)
+		}
+
+		wantLibs := []string{"-L/store/b-raylib-5.0/lib", "-lraylib", "-lm"}
+		if !slices.Equal(pc.Libs, wantLibs) {
+			t.Errorf("Expect %v, got %v", wantLibs, pc.Libs)
+		}
+
+		if !slices.Equal(pc.Requires, []string{"glfw"}) {
+			t.Errorf("Expect requiring 'glfw', got %v", pc.Requires)
+		}
+	})
+
+	t.Run("cflags and libs with requires", func(t *testing.T) {
+		q, err := ParseArgs([]string{"--cflags", "--libs", "--static", "raylib"})
+		if err != nil {
+			t.Error(err)
+		}
+
+		var out strings.Builder
+		if err = q.Run([]string{dir}, &out); err != nil {
+			t.Error(err)
+		}
+
+		want := "-I/store/b-raylib-5.0/include -I/store/a-glfw-3.4/include " +
+			"-L/store/b-raylib-5.0/lib -lraylib -lm -L/store/a-glfw-3.4/lib -lglfw\n"
+		if out.String() != want {
+			t.Errorf("Expect:\n%s\nGot:\n%s", want, out.String())
+		}
+	})
+
+	t.Run("target and modversion", func(t *testing.T) {
+		q, err := ParseArgs([]string{"--target", "windows", "--modversion", "raylib"})
+		if err != nil {
+			t.Error(err)
+		}
+
+		if q.Target != "windows" {
+			t.Errorf("Expect 'windows', got '%s'", q.Target)
+		}
+
+		var out strings.Builder
+		if err = q.Run([]string{dir}, &out); err != nil {
+			t.Error(err)
+		}
+
+		if out.String() != "5.0\n" {
+			t.Errorf("Expect '5.0', got '%s'", out.String())
+		}
+	})
+
+	t.Run("requires with versions", func(t *testing.T) {
+		data := "Name: game\nVersion: 1\nRequires: glfw >= 3.0, raylib>=5.0\nLibs: -lgame\n"
+		if err := os.WriteFile(path.Join(dir, "game.pc"), []byte(data), 0644); err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		q, err := ParseArgs([]string{"--libs", "game"})
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		var out strings.Builder
+		if err = q.Run([]string{dir}, &out); err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		if !strings.Contains(out.String(), "-lglfw") || !strings.Contains(out.String(), "-lraylib") {
+			t.Errorf("Expect libs of required packages, got '%s'", out.String())
+		}
+
+		for value, want := range map[string][]string{
+			"glib-2.0 >= 2.50":        {"glib-2.0"},
+			"a = 1, b,c < 2 d != 3 e": {"a", "b", "c", "d", "e"},
+			"x>1 y":                   {"x", "y"},
+		} {
+			if got := parseRequires(value); !slices.Equal(got, want) {
+				t.Errorf("Expect %v for '%s', got %v", want, value, got)
+			}
+		}
+	})
+
+	t.Run("unknown package", func(t *testing.T) {
+		q := &Query{Cflags: true, Pkgs: []string{"sdl"}}
+
+		var out strings.Builder
+		if err := q.Run([]string{dir}, &out); !errors.Is(err, errs.Resolution) {
+			t.Errorf("Expect resolution error for unknown package, got %v", err)
+		}
+	})
+
+	t.Run("wrong arguments", func(t *testing.T) {
+		for _, args := range [][]string{{"--cflags"}, {"raylib", "--target"}} {
+			if _, err := ParseArgs(args); !errors.Is(err, errs.Usage) {
+				t.Errorf("Expect usage error for %q, got %v", args, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,109 @@
+package pkglua
+
+import (
+	"raypm/internal/errs"
+	"raypm/internal/triple"
+	"slices"
+
+	"github.com/Shopify/go-lua"
+)
+
+// Fields of 'Data' table of package.lua
+var DataFields = []string{
+	"name", "version", "description", "src_path", "build_path", "targets", "packages",
+}
+
+// Lua table as is: string keys are in 'Fields', the array part is in
+// 'Items'. Values are string, float64, bool, *Table, or name of Lua's type for
+// functions and others. Tables, that are referenced twice, like
+// 'targets.windows.cross_linux = targets.windows', are the same *Table
+type Table struct {
+	Fields map[string]any
+	Items  []any
+}
+
+// Phases, arrays and single values, that raypm reads from target's table
+func TargetFields() []string {
+	return slices.Concat(phaseSpecs, targetArrSpecs, targetStrSpecs)
+}
+
+// Fields, that are arrays of strings, others are strings
+func IsArrayField(field string) bool {
+	return slices.Contains(targetArrSpecs, field)
+}
+
+// Reads 'Data' of package.lua without choosing a target, for checks of the
+// whole file. 'Host' and 'Target' globals are the host system. Errors of Lua
+// start with 'path:line:'
+func ReadData(pathToPackageFile string) (data *Table, err error) {
+	defer func() {
+		err = errs.Wrap(errs.Lua, "WrongPackageFile", err, "'%s'", pathToPackageFile)
+	}()
+
+	l, err := newState()
+	if err != nil {
+		return
+	}
+
+	host := triple.Host()
+	setTriple(l, "Host", host)
+	setTriple(l, "Target", host)
+
+	if err = loadPackage(l, pathToPackageFile); err != nil {
+		return
+	}
+
+	l.Global("Data")
+	if !l.IsTable(-1) {
+		err = &LuaTableError{Err: FieldIsNil, FieldName: "Data"}
+		return
+	}
+
+	return readTable(l, -1, map[any]*Table{}), nil
+}
+
+func readTable(l *lua.State, index int, seen map[any]*Table) (t *Table) {
+	index = l.AbsIndex(index)
+
+	if t = seen[l.ToValue(index)]; t != nil {
+		return
+	}
+	t = &Table{Fields: map[string]any{}}
+	seen[l.ToValue(index)] = t
+
+	for i := 1; i <= l.RawLength(index); i++ {
+		l.RawGetInt(index, i)
+		t.Items = append(t.Items, readValue(l, -1, seen))
+		l.Pop(1)
+	}
+
+	l.PushNil()
+	for l.Next(index) {
+		if l.TypeOf(-2) == lua.TypeString {
+			key, _ := l.ToString(-2)
+			t.Fields[key] = readValue(l, -1, seen)
+		}
+		l.Pop(1)
+	}
+
+	return
+}
+
+func readValue(l *lua.State, index int, seen map[any]*Table) any {
+	switch l.TypeOf(index) {
+	case lua.TypeNil:
+		return nil
+	case lua.TypeString:
+		str, _ := l.ToString(index)
+		return str
+	case lua.TypeNumber:
+		num, _ := l.ToNumber(index)
+		return num
+	case lua.TypeBoolean:
+		return l.ToBoolean(index)
+	case lua.TypeTable:
+		return readTable(l, index, seen)
+	}
+
+	return lua.TypeNameOf(l, index)
+}
@@ -24,7 +24,7 @@ func (e *LuaTableError) Error() (err string) {
 	case FieldIsNotArray:
 		err = fmt.Sprintf("%v: (%s) got = %v", e.Err, e.FieldName, e.Value)
 	case ParseStringFailed:
-		err = fmt.Sprintf("%v: got = %v", e.Err, e.Value)
+		err = fmt.Sprintf("%v: (%s) got = %v", e.Err, e.FieldName, e.Value)
 	case FieldIsNil:
 		err = fmt.Sprintf("%v: %s", e.Err, e.FieldName)
 	}
@@ -10,6 +10,9 @@ local available_systems = {
   "all",
   "linux",
   "windows",
+  "darwin",
+  "android",
+  "web",
 }
 
 local pkgman_base_cmd = {
@@ -40,9 +43,9 @@ pkgman_base_cmd.mint = pkgman_base_cmd.ubuntu
 local root_permission = "sudo"
 
 -- functions (global)
-function Get_Pkgman_Cmd(luaPkg, distro)
+-- 'Data' is set by package.lua, that is loaded before the calls
+function Get_Pkgman_Cmd(distro)
   local cmd = {}
-  dofile(luaPkg)
 
   if Data.packages == nil then
     return nil
@@ -81,8 +84,7 @@ end
 
 -- Returns 'pkg' object
 -- If fails, returns nil, error type and errors object
-function Get_Metadata(pkg_lua_file)
-  dofile(pkg_lua_file)
+function Get_Metadata()
   local metadata = {}
 
   metadata.name = Data.name
@@ -93,18 +95,27 @@ function Get_Metadata(pkg_lua_file)
   return metadata
 end
 
-function Get_Phases(pkg_lua_file, host, target)
-  dofile(pkg_lua_file)
-  local phases = {}
+-- 'Target' and 'Host' globals are tables: os, arch, abi, name ('os/arch/abi')
+-- and triple of compiler. Targets could be described by full name, by
+-- 'os/arch' or just by os
+function Get_Phases()
+  local phases = Data.targets[Target.name]
+    or Data.targets[Target.os .. "/" .. Target.arch]
+    or Data.targets[Target.os]
 
-  if target ~= host then
-    phases = Data.targets[target]["cross_" .. host]
-  else
-    phases = Data.targets[target]
+  if phases == nil then
+    return nil, "UnsupportedSystem", Host.name, Target.name
+  end
+
+  if Target.os ~= Host.os then
+    phases = phases["cross_" .. Host.os]
+  elseif Target.name ~= Host.name then
+    -- The same system with other arch could be built without special rules
+    phases = phases["cross_" .. Host.os] or phases
   end
 
   if phases == nil then
-    return nil, "UnknownSystem", host, target
+    return nil, "UnknownSystem", Host.name, Target.name
   end
 
   return phases
@@ -3,8 +3,11 @@ package pkglua
 import (
 	"bufio"
 	_ "embed"
+	"errors"
 	"fmt"
 	"os"
+	"raypm/internal/errs"
+	"raypm/internal/triple"
 	log "raypm/pkg/slog"
 	"strings"
 
@@ -30,28 +33,86 @@ type MData map[string]string
 // Contains:
 //   - supported_systems
 //   - dependencies
+//   - include_dirs, lib_dirs, libs (for pkg-config)
+//   - abis, api_level, app_id, lib_name (for android)
+//   - assets, shell_file (for web)
+//   - bundle_id, executable, icon, min_macos, assets (for darwin)
 //   - pkgman_install
 //   - pkgman_uninstall
-//   - packages
 //   - phases*
 type TargetSpec map[string][]string
 
+// Value of single field (see targetStrSpecs), empty if it's not set
+func (ts TargetSpec) Value(field string) string {
+	if len(ts[field]) == 0 {
+		return ""
+	}
+
+	return ts[field][0]
+}
+
+// Arrays in target's table
+var targetArrSpecs = []string{
+	"dependencies", "include_dirs", "lib_dirs", "libs", "abis", "assets",
+}
+
+// Single values in target's table, kept as arrays of one item
+var targetStrSpecs = []string{
+	"api_level", "app_id", "lib_name", "shell_file",
+	"bundle_id", "executable", "icon", "min_macos",
+}
+
+// Phases in target's table, kept as arrays of lines
+var phaseSpecs = []string{
+	"fetch_phase", "unpack_phase", "prepare_phase", "build_phase", "install_phase", "uninstall_phase",
+}
+
+// 'host' and 'target' are triples like 'linux/amd64/gnu' or just systems
+// Errors are LuaError, or ResolutionError if the package doesn't support
+// the target
 func NewPackage(pathToPackageFile, host, target string) (pd *Package, err error) {
+	defer func() {
+		var se *SystemError
+		if errors.As(err, &se) {
+			err = errs.Wrap(errs.Resolution, "UnsupportedTarget", err, "'%s'", pathToPackageFile)
+		} else {
+			err = errs.Wrap(errs.Lua, "WrongPackageFile", err, "'%s'", pathToPackageFile)
+		}
+	}()
+
 	mdata := make(map[string]string)
 	tspec := make(map[string][]string)
 	ok := true
 	phaseStr := ""
-	l := lua.NewState()
-	lua.OpenLibraries(l)
+	l, err := newState()
+	if err != nil {
+		return
+	}
+
+	hostTriple, err := triple.Parse(host)
+	if err != nil {
+		err = errs.Wrap(errs.Usage, "WrongHost", err, "'%s'", host)
+		return
+	}
+
+	targetTriple, err := triple.Parse(target)
+	if err != nil {
+		err = errs.Wrap(errs.Usage, "WrongTarget", err, "'%s'", target)
+		return
+	}
+
+	// package.lua could look at them while its table is created
+	setTriple(l, "Host", hostTriple)
+	setTriple(l, "Target", targetTriple)
 
-	if err = lua.DoString(l, lualib); err != nil {
-		log.Errorln("Failed to execute internal lib in Lua:", err)
+	if err = loadPackage(l, pathToPackageFile); err != nil {
 		return
 	}
 
 	l.Global("Get_Metadata")
-	l.PushString(pathToPackageFile)
-	l.Call(1, 5)
+	if err = l.ProtectedCall(0, 5, 0); err != nil {
+		return
+	}
 	tableInd := 1
 
 	if l.IsNil(tableInd) {
@@ -66,10 +127,10 @@ func NewPackage(pathToPackageFile, host, target string) (pd *Package, err error)
 	mdata["name"], ok = l.ToString(-1)
 	if !ok {
 		err = &LuaTableError{
-			Err:   ParseStringFailed,
-			Value: l.ToValue(-1),
+			Err:       ParseStringFailed,
+			Value:     l.ToValue(-1),
+			FieldName: "name",
 		}
-		log.Errorln("'name' field is required!")
 		return
 	}
 
@@ -77,10 +138,10 @@ func NewPackage(pathToPackageFile, host, target string) (pd *Package, err error)
 	mdata["version"], ok = l.ToString(-1)
 	if !ok {
 		err = &LuaTableError{
-			Err:   ParseStringFailed,
-			Value: l.ToValue(-1),
+			Err:       ParseStringFailed,
+			Value:     l.ToValue(-1),
+			FieldName: "version",
 		}
-		log.Errorln("'version' field is required!")
 		return
 	}
 
@@ -97,16 +158,19 @@ func NewPackage(pathToPackageFile, host, target string) (pd *Package, err error)
 	l.SetTop(0)
 
 	l.Global("Get_Phases")
-	l.PushString(pathToPackageFile)
-	l.PushString(host)
-	l.PushString(target)
-	l.Call(3, 7)
+	if err = l.ProtectedCall(0, 4, 0); err != nil {
+		return
+	}
 
-	pkgArrSpecs := []string{
-		"fetch_phase", "unpack_phase", "prepare_phase", "build_phase", "install_phase", "uninstall_phase",
+	if l.IsNil(1) {
+		err = &SystemError{
+			Err:   UnsupportedSystem,
+			Value: targetTriple.String(),
+		}
+		return
 	}
 
-	for _, item := range pkgArrSpecs {
+	for _, item := range phaseSpecs {
 		l.Field(1, item)
 		phaseStr, ok = l.ToString(l.Top())
 		if ok {
@@ -117,27 +181,20 @@ func NewPackage(pathToPackageFile, host, target string) (pd *Package, err error)
 		}
 	}
 
-	// Parsing dependencies
-	l.Field(1, "dependencies")
-	if !l.IsNil(l.Top()) {
-		ok = true
-		depInd := l.Top()
-		deps := make([]string, 0)
-
-		for i := 1; ok; i++ {
-			l.RawGetInt(depInd, i)
-			ind := l.Top()
-
-			if l.IsNil(ind) {
-				break
-			}
-			phaseStr, ok = l.ToString(ind)
-			if ok {
-				deps = append(deps, phaseStr)
-			}
+	// Parsing arrays: dependencies and what the package gives for
+	// pkg-config
+	for _, item := range targetArrSpecs {
+		if arr := readArray(l, 1, item); arr != nil {
+			tspec[item] = arr
 		}
+	}
 
-		tspec["dependencies"] = deps
+	for _, item := range targetStrSpecs {
+		l.Field(1, item)
+		if str, ok := l.ToString(l.Top()); ok && str != "" {
+			tspec[item] = []string{str}
+		}
+		l.Pop(1)
 	}
 
 	l.Pop(l.Top() + 1)
@@ -147,15 +204,13 @@ func NewPackage(pathToPackageFile, host, target string) (pd *Package, err error)
 	pd.MData = mdata
 	pd.TargetSpec = tspec
 
-	if host == "linux" {
+	if hostTriple.OS == "linux" {
 		var (
 			osRelease *os.File
 			distro    string
-			pm        []string
 		)
 
 		if osRelease, err = os.Open("/etc/os-release"); err != nil {
-			log.Errorln("Failed to read /etc/os-release:", err)
 			return
 		}
 		defer osRelease.Close()
@@ -169,28 +224,93 @@ func NewPackage(pathToPackageFile, host, target string) (pd *Package, err error)
 			}
 		}
 
-		l.Global("Get_Pkgman_Cmd")
-		l.PushString(pathToPackageFile)
-		l.PushString(distro)
-		l.Call(3, 3)
+		// Lua's Get_Pkgman_Cmd looks at 'install' global to choose the command
+		for _, phase := range []string{"install", "uninstall"} {
+			l.SetTop(0)
+			l.PushBoolean(phase == "install")
+			l.SetGlobal("install")
 
-		log.Debugln(showStack(l, "pkgs"))
-		if l.IsNil(1) {
-			log.Debugln("Packages is nil")
-			return
-		}
+			l.Global("Get_Pkgman_Cmd")
+			l.PushString(distro)
+			if err = l.ProtectedCall(1, 1, 0); err != nil {
+				return
+			}
 
-		ok := true
-		str := ""
-		for ok {
-			l.RawGetInt(1, l.Top()-2)
-			str, ok = l.ToString(l.Top())
-			if ok {
+			log.Debugln(showStack(l, "pkgs"))
+			if !l.IsTable(1) {
+				log.Debugln("Packages is nil")
+				continue
+			}
+
+			pm := make([]string, 0)
+			for i := 1; ; i++ {
+				l.RawGetInt(1, i)
+				str, ok := l.ToString(l.Top())
+				l.Pop(1)
+				if !ok {
+					break
+				}
 				pm = append(pm, str)
 			}
+
+			pd.TargetSpec["pkgman_"+phase] = pm
+		}
+		l.SetTop(0)
+	}
+
+	return
+}
+
+func (pd *Package) Info() {
+	fmt.Printf("Name: %s\n", pd.MData["name"])
+	fmt.Printf("Version: %s\n", pd.MData["version"])
+	fmt.Printf("Description: %s\n", pd.MData["description"])
+
+	if deps := pd.TargetSpec["dependencies"]; len(deps) > 0 {
+		fmt.Printf("Depends on:\n")
+		for _, item := range deps {
+			fmt.Printf("\t+ %s\n", item)
 		}
+	}
+}
+
+// Sets global table with fields: os, arch, abi, name and triple
+func setTriple(l *lua.State, global string, t triple.Triple) {
+	l.NewTable()
+	for key, value := range map[string]string{
+		"os":     t.OS,
+		"arch":   t.Arch,
+		"abi":    t.ABI,
+		"name":   t.String(),
+		"triple": t.Compiler(),
+	} {
+		l.PushString(value)
+		l.SetField(-2, key)
+	}
+	l.SetGlobal(global)
+}
 
-		pd.TargetSpec["packages"] = pm
+// Returns nil, if there is no such field
+func readArray(l *lua.State, tableInd int, field string) (arr []string) {
+	l.Field(tableInd, field)
+	defer l.Pop(1)
+
+	if !l.IsTable(l.Top()) {
+		return
+	}
+
+	arrInd := l.Top()
+	arr = make([]string, 0)
+
+	for i := 1; ; i++ {
+		l.RawGetInt(arrInd, i)
+		str, ok := l.ToString(l.Top())
+		l.Pop(1)
+
+		if !ok {
+			break
+		}
+		arr = append(arr, str)
 	}
 
 	return
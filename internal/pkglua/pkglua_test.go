@@ -1,13 +1,18 @@
 package pkglua
 
 import (
+	"errors"
 	"fmt"
 	"maps"
+	"os"
 	"path"
+	"raypm/internal/errs"
 	log "raypm/pkg/slog"
 	"runtime"
 	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestLuaOnWindows(t *testing.T) {
@@ -110,6 +115,51 @@ func TestGoLua(t *testing.T) {
 		}
 	})
 
+	t.Run("android fields", func(t *testing.T) {
+		pd, err := NewPackage(path.Join("testdata", "snake.lua"), "linux", "android")
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		want := map[string][]string{
+			"abis":      {"arm64-v8a", "x86_64"},
+			"api_level": {"29"},
+			"app_id":    {"com.raylib.snake"},
+		}
+
+		for key, val := range want {
+			if !slices.Equal(pd.TargetSpec[key], val) {
+				t.Errorf("Expect %s = %v, got %v", key, val, pd.TargetSpec[key])
+			}
+		}
+
+		if _, ok := pd.TargetSpec["lib_name"]; ok {
+			t.Error("Expect no 'lib_name'")
+		}
+	})
+
+	t.Run("target with arch", func(t *testing.T) {
+		pd, err := NewPackage(path.Join("testdata", "snake.lua"), "linux/amd64", "windows/386")
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		if !slices.Equal(pd.TargetSpec["dependencies"], []string{"go", "mingw32"}) {
+			t.Errorf("Expect dependencies of 'windows/386', got %v", pd.TargetSpec["dependencies"])
+		}
+
+		want := []string{"go build -o build/snake-386.exe ."}
+		if !slices.Equal(pd.TargetSpec["build_phase"], want) {
+			t.Errorf("Expect %v, got %v", want, pd.TargetSpec["build_phase"])
+		}
+
+		if _, err = NewPackage(path.Join("testdata", "snake.lua"), "linux", "windows/mips"); err == nil {
+			t.Error("Expect error for unsupported arch")
+		}
+	})
+
 	t.Run("pkgdata with linux packages", func(t *testing.T) {
 		if runtime.GOOS == "windows" {
 			fmt.Println(t.Name(), "- This test cannot run on Windows")
@@ -124,6 +174,126 @@ func TestGoLua(t *testing.T) {
 	})
 }
 
+func TestSandbox(t *testing.T) {
+	log.Init(false)
+
+	dir, err := os.MkdirTemp(os.TempDir(), "pkglua_test_*")
+	if err != nil {
+		t.Errorf("Cannot create temp directory: %s\n", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	// Package with the code before 'Data'
+	write := func(name, code string) string {
+		pth := path.Join(dir, name+".lua")
+		data := code + `
+Data = {
+  name = "` + name + `",
+  version = "1",
+  targets = { linux = { build_phase = os.getenv("RAYPM_SANDBOX_TEST") or "make" } },
+}`
+		if err := os.WriteFile(pth, []byte(data), 0644); err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		return pth
+	}
+
+	t.Run("environment is readable", func(t *testing.T) {
+		t.Setenv("RAYPM_SANDBOX_TEST", "ninja")
+
+		pd, err := NewPackage(write("env", ""), "linux", "linux")
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		if !slices.Equal(pd.TargetSpec["build_phase"], []string{"ninja"}) {
+			t.Errorf("Expect build phase from environment, got %v", pd.TargetSpec["build_phase"])
+		}
+	})
+
+	t.Run("files and processes are not available", func(t *testing.T) {
+		marker := path.Join(dir, "marker")
+
+		for name, code := range map[string]string{
+			"execute":  `os.execute("touch ` + marker + `")`,
+			"remove":   `os.remove("` + marker + `")`,
+			"io":       `io.open("` + marker + `", "w")`,
+			"dofile":   `dofile("/etc/passwd")`,
+			"load":     `load("return 1")`,
+			"require":  `require("os")`,
+			"debug":    `debug.sethook()`,
+			"registry": `package.loaded.io.open("` + marker + `", "w")`,
+		} {
+			if _, err := NewPackage(write(name, code), "linux", "linux"); !errors.Is(err, errs.Lua) {
+				t.Errorf("Expect Lua error for '%s', got %v", code, err)
+			}
+		}
+
+		if _, err := os.Stat(marker); err == nil {
+			t.Errorf("'%s' is created by package file", marker)
+		}
+	})
+
+	t.Run("budget", func(t *testing.T) {
+		instructions, timeout := MaxInstructions, Timeout
+		defer func() { MaxInstructions, Timeout = instructions, timeout }()
+
+		MaxInstructions = 100_000
+		for name, code := range map[string]string{
+			"loop":  `while true do end`,
+			"pcall": `while true do pcall(function() while true do end end) end`,
+		} {
+			if _, err := NewPackage(write(name, code), "linux", "linux"); err == nil || !strings.Contains(err.Error(), "BudgetExceeded") {
+				t.Errorf("Expect exceeded budget for '%s', got %v", code, err)
+			}
+		}
+
+		MaxInstructions, Timeout = instructions, time.Millisecond
+		start := time.Now()
+		if _, err := NewPackage(write("timeout", `while true do end`), "linux", "linux"); err == nil {
+			t.Error("Expect timeout")
+		}
+
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("Package file runs for %s", elapsed)
+		}
+
+		// Usual package fits the budget
+		MaxInstructions, Timeout = instructions, timeout
+		if _, err := NewPackage(path.Join("testdata", "snake.lua"), "linux", "windows"); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("long strings", func(t *testing.T) {
+		for name, code := range map[string]string{
+			"rep":      `local s = string.rep("x", 2^40)`,
+			"method":   `local s = ("xy"):rep(2^30, ",")`,
+			"concat":   `local s = "x" while true do s = s .. s end`,
+			"field":    `local t = {s = "x"} for i = 1, 64 do t.s = t.s .. t.s end`,
+			"pcall":    `local s = "x" while true do pcall(function() s = s .. s end) end`,
+			"table":    `local t = {string.rep("x", 2^20)} for i = 1, 20 do t = {table.concat(t), table.concat(t)} end`,
+			"values":   `local t = {} for i = 1, 100 do t[i] = string.rep("x", 2^20) end local s = table.concat(t)`,
+			"format":   `local s = "x" for i = 1, 64 do s = string.format("%s%s", s, s) end`,
+			"tostring": `local s = "1" for i = 1, 64 do s = tostring(s) .. tostring(s) end`,
+		} {
+			if _, err := NewPackage(write(name, code), "linux", "linux"); err == nil || !strings.Contains(err.Error(), "StringIsTooLong") {
+				t.Errorf("Expect too long string for '%s', got %v", code, err)
+			}
+		}
+
+		code := `assert(string.rep("ab", 3, "-") == "ab-ab-ab" and ("x"):rep(0) == "")
+			assert(table.concat({1, "b", 3}, ",") == "1,b,3" and table.concat({"a", "b"}, "", 2) == "b")
+			assert(string.format("%s-%5d", "a", 1) == "a-    1" and "a" .. 1 .. "b" == "a1b")`
+		if _, err := NewPackage(write("short", code), "linux", "linux"); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
 func cmpPackage(pd1, pd2 *Package) bool {
 	if !maps.Equal(pd1.MData, pd2.MData) {
 		return false
@@ -157,3 +327,13 @@ func outputPd(pd *Package) (s string) {
 	}
 	return
 }
+
+func TestTargetSpecValue(t *testing.T) {
+	spec := TargetSpec{"app_id": {"com.neco.snake"}, "abis": {}}
+
+	for field, want := range map[string]string{"app_id": "com.neco.snake", "abis": "", "icon": ""} {
+		if got := spec.Value(field); got != want {
+			t.Errorf("Expect '%s' for '%s', got '%s'", want, field, got)
+		}
+	}
+}
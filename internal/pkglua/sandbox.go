@@ -0,0 +1,199 @@
+package pkglua
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Shopify/go-lua"
+)
+
+// Budget of package.lua: reading metadata, like for '-list', must not hang
+// raypm or take all memory. Time is checked between instructions, so a call
+// of Go function is not interrupted: string.rep, string.format and
+// table.concat, that could make huge string in one call, are limited by
+// MaxStringSize. Strings in registers of the running function are limited
+// by it too, they are operands of concatenation
+var (
+	MaxInstructions = 10_000_000
+	Timeout         = 5 * time.Second
+	MaxStringSize   = 16 << 20
+)
+
+// Instructions between checks of the time
+const budgetStep = 1000
+
+// Libraries without access to files and processes
+var safeLibs = []lua.RegistryFunction{
+	{Name: "_G", Function: lua.BaseOpen},
+	{Name: "table", Function: lua.TableOpen},
+	{Name: "string", Function: lua.StringOpen},
+	{Name: "bit32", Function: lua.Bit32Open},
+	{Name: "math", Function: lua.MathOpen},
+}
+
+// Functions of base library, that load code from files and strings
+var unsafeGlobals = []string{"dofile", "loadfile", "load", "loadstring", "require", "module"}
+
+// Part of 'os', that only reads environment and time
+var safeOS = []string{"getenv", "time", "clock", "date", "difftime"}
+
+// Lua state with safe libraries, raypm's lib.lua and the budget. 'io',
+// 'debug', 'package' and most of 'os' are not available
+func newState() (l *lua.State, err error) {
+	l = lua.NewState()
+
+	for _, lib := range safeLibs {
+		lua.Require(l, lib.Name, lib.Function, true)
+		l.Pop(1)
+	}
+
+	for _, name := range unsafeGlobals {
+		l.PushNil()
+		l.SetGlobal(name)
+	}
+
+	l.Global("string")
+	l.PushGoFunction(stringRep)
+	l.SetField(-2, "rep")
+	limit(l, "format", formatSize)
+	l.Pop(1)
+
+	l.Global("table")
+	limit(l, "concat", concatSize)
+	l.Pop(1)
+
+	lua.Require(l, "os", lua.OSOpen, false)
+	l.NewTable()
+	for _, name := range safeOS {
+		l.Field(-2, name)
+		l.SetField(-2, name)
+	}
+	l.SetGlobal("os")
+	l.Pop(1)
+
+	setBudget(l)
+
+	if err = lua.DoString(l, lualib); err != nil {
+		return
+	}
+
+	return
+}
+
+// Stops the state with error, when the budget is spent. The hook runs before
+// every instruction, so result of concatenation is checked before the next
+// one. pcall could catch the error, so the next instruction after it fails too
+func setBudget(l *lua.State) {
+	var (
+		deadline = time.Now().Add(Timeout)
+		spent    = 0
+		timedOut = false
+	)
+
+	hook := func(l *lua.State, _ lua.Debug) {
+		if spent++; spent%budgetStep == 0 && time.Now().After(deadline) {
+			timedOut = true
+		}
+
+		if spent > MaxInstructions || timedOut {
+			lua.Errorf(l, "%s", fmt.Sprintf("BudgetExceeded: package file runs longer than %d instructions or %s", MaxInstructions, Timeout))
+		}
+
+		if stringsSize(l, 1, l.Top()) > MaxStringSize {
+			lua.Errorf(l, "%s", fmt.Sprintf("StringIsTooLong: strings of a function take more than %d bytes", MaxStringSize))
+		}
+	}
+
+	lua.SetDebugHook(l, hook, lua.MaskCount, 1)
+}
+
+// Total length of strings on the stack from 'first' to 'last'. In the hook
+// these are registers of the running function
+func stringsSize(l *lua.State, first, last int) (size int) {
+	for i := first; i <= last; i++ {
+		if l.TypeOf(i) == lua.TypeString {
+			s, _ := l.ToString(i)
+			size += len(s)
+		}
+	}
+	return
+}
+
+// Replaces function 'name' of the library on top of the stack: 'size'
+// estimates its result before the call, and the result is checked after it
+func limit(l *lua.State, name string, size func(*lua.State) int) {
+	l.Field(-1, name)
+	f := l.ToGoFunction(-1)
+	l.Pop(1)
+
+	l.PushGoFunction(func(l *lua.State) int {
+		if size(l) > MaxStringSize {
+			lua.Errorf(l, "%s", fmt.Sprintf("StringIsTooLong: %s makes more than %d bytes", name, MaxStringSize))
+		}
+
+		n := f(l)
+		if n > 0 && stringsSize(l, l.Top()-n+1, l.Top()-n+1) > MaxStringSize {
+			lua.Errorf(l, "%s", fmt.Sprintf("StringIsTooLong: %s makes more than %d bytes", name, MaxStringSize))
+		}
+		return n
+	})
+	l.SetField(-2, name)
+}
+
+// Format and strings, that are inserted to it. Width of a value is up to 99
+func formatSize(l *lua.State) int {
+	return stringsSize(l, 1, l.Top()) + 100*(l.Top()-1)
+}
+
+// Values of the table with separators between them. Wrong values are left
+// for table.concat to report
+func concatSize(l *lua.State) (size int) {
+	lua.CheckType(l, 1, lua.TypeTable)
+	sep := lua.OptString(l, 2, "")
+	first := lua.OptInteger(l, 3, 1)
+	last := lua.OptInteger(l, 4, 0)
+	if l.IsNoneOrNil(4) {
+		last = lua.LengthEx(l, 1)
+	}
+
+	for i := first; i <= last && size <= MaxStringSize; i++ {
+		l.RawGetInt(1, i)
+		s, ok := l.ToString(-1)
+		l.Pop(1)
+		if !ok {
+			break
+		}
+		size += len(s) + len(sep)
+	}
+	return
+}
+
+// string.rep with the result up to MaxStringSize
+func stringRep(l *lua.State) int {
+	s, n, sep := lua.CheckString(l, 1), lua.CheckInteger(l, 2), lua.OptString(l, 3, "")
+	if n <= 0 {
+		l.PushString("")
+		return 1
+	}
+
+	if len(s)+len(sep) > 0 && n > (MaxStringSize+len(sep))/(len(s)+len(sep)) {
+		lua.Errorf(l, "%s", fmt.Sprintf("StringIsTooLong: string.rep makes more than %d bytes", MaxStringSize))
+	}
+
+	l.PushString(strings.Repeat(s+sep, n-1) + s)
+	return 1
+}
+
+// Runs the package file, it sets 'Data' global. Binary chunks are not
+// loaded. Errors start with 'path:line:'
+func loadPackage(l *lua.State, pathToPackageFile string) (err error) {
+	if err = lua.LoadFile(l, pathToPackageFile, "t"); err != nil {
+		msg, _ := l.ToString(-1)
+		l.Pop(1)
+		return errors.New(msg)
+	}
+
+	return l.ProtectedCall(0, 0, 0)
+}
@@ -1,7 +1,7 @@
 local name = "snake"
 local version = "0.2.1"
 local description = [[Simple snake on golang]]
-local supported_systems = { "linux", "windows" }
+local supported_systems = { "linux", "windows", "android" }
 
 local src_path = "."
 local build_path = "build"
@@ -33,6 +33,26 @@ targets.windows.cross_linux = {
   build_phase = targets.windows.build_phase,
 }
 
+-- 32-bit Windows has own compiler, 'Target' is set by raypm
+targets["windows/386"] = {
+  cross_linux = {
+    dependencies = { "go", "mingw32" },
+    build_phase = string.format("go build -o %s/%s-%s.exe %s", build_path, name, Target.arch, src_path),
+  },
+}
+
+-- Android is always cross compiled: build phase runs once per ABI with
+-- GOOS, GOARCH and CC of NDK, libraries go to $out/lib/$abi
+targets.android = {
+  cross_linux = {
+    dependencies = { "go", "ndk" },
+    abis = { "arm64-v8a", "x86_64" },
+    api_level = 29,
+    app_id = "com.raylib.snake",
+    build_phase = "go build -buildmode=c-shared -ldflags '-s -w' -o $out/lib/$abi/libsnake.so .",
+  },
+}
+
 -- What we will return(required table)
 Data = {
   name = name,
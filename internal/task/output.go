@@ -0,0 +1,67 @@
+package task
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"raypm/pkg/progress"
+	"sync"
+)
+
+// Commands of packages, that are built in parallel, write to the same terminal
+var outputLock sync.Mutex
+
+// Output of commands is shown, besides the log of the phase (see Vars.Log),
+// only on a terminal
+var Echo = progress.IsTerminal(os.Stdout)
+
+// Where output of commands is echoed, progress bars are redrawn around it
+var Stdout, Stderr = progress.Default.Wrap(os.Stdout), progress.Default.Wrap(os.Stderr)
+
+// Writes whole lines with prefix, so lines of parallel commands are not mixed
+type prefixWriter struct {
+	prefix []byte
+	w      io.Writer
+	buf    []byte
+}
+
+func newPrefixWriter(prefix string, w io.Writer) *prefixWriter {
+	return &prefixWriter{prefix: []byte(prefix), w: w}
+}
+
+func (pw *prefixWriter) Write(p []byte) (n int, err error) {
+	pw.buf = append(pw.buf, p...)
+
+	for {
+		i := bytes.IndexByte(pw.buf, '\n')
+		if i < 0 {
+			break
+		}
+
+		if err = pw.writeLine(pw.buf[:i+1]); err != nil {
+			return
+		}
+		pw.buf = pw.buf[i+1:]
+	}
+
+	return len(p), nil
+}
+
+// Writes the last line without '\n'
+func (pw *prefixWriter) Flush() (err error) {
+	if len(pw.buf) == 0 {
+		return
+	}
+
+	err = pw.writeLine(append(pw.buf, '\n'))
+	pw.buf = nil
+	return
+}
+
+func (pw *prefixWriter) writeLine(line []byte) (err error) {
+	outputLock.Lock()
+	defer outputLock.Unlock()
+
+	_, err = pw.w.Write(append(pw.prefix[:len(pw.prefix):len(pw.prefix)], line...))
+	return
+}
@@ -1,17 +1,25 @@
 package task
 
 import (
-	"bufio"
-	"fmt"
+	"crypto/sha256"
+	"encoding/hex"
 	"io"
+	"net/url"
 	"os"
 	"os/exec"
 	"path"
-	"raypm/internal/pkginfo"
+	"path/filepath"
+	"raypm/internal/errs"
+	"raypm/internal/phases"
+	"raypm/internal/pkglua"
 	"raypm/internal/vars"
 	"raypm/pkg/progress"
 	log "raypm/pkg/slog"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 const (
@@ -20,60 +28,470 @@ const (
 	Copy               string = "copy"
 	CallPackageManager string = "pkgman"
 	Overwrite          string = "overwrite"
+	SetEnv             string = "setenv"
+	Get                string = "get"
+	Unpack             string = "unpack"
+	GetUnpack          string = "get_unpack"
 )
 
-func Do(phaseType string, task pkginfo.CommandTask, vv *vars.Vars) (err error) {
-	cmd := task.Command
-	if cmd == Exec {
-		exe := vv.ExpandVars(&task.ExecBase)
-		args := vv.ExpandVars(&task.Args)
-
-		err = external_cmd(exe, args)
-	} else if cmd == Mkdir {
-		pth := vv.ExpandVars(&task.Path)
-		err = mkdir(pth)
-	} else if cmd == Copy || cmd == Overwrite{
-		from := path.Join(vv.ExpandVars(&task.From)...)
-		to := path.Join(vv.ExpandVars(&task.To)...)
-
-		overwrite := cmd == Overwrite
-
-		err = copyItemOverwrite(from, to, overwrite)
-	} else if cmd == CallPackageManager {
-		err = pkgman(vv.Package, phaseType)
-	} else {
-		log.Error("Unknown command '%s'", cmd)
-		err = fmt.Errorf("UnknownCommand")
+// Line of a phase. Lines like '${copy from to}' are directives, any other
+// line is a command, that will be executed with Exec
+type Directive struct {
+	Command string
+	Args    []string
+}
+
+func ParseLine(line string) (d Directive, err error) {
+	line = strings.TrimSpace(line)
+
+	if !strings.HasPrefix(line, "${") {
+		d.Command = Exec
+		d.Args, err = SplitCommand(line)
+		return
+	}
+
+	if !strings.HasSuffix(line, "}") {
+		err = errs.New(errs.Phase, "DirectiveIsNotClosed", "'%s'", line)
+		return
+	}
+
+	if d.Args, err = SplitCommand(line[2 : len(line)-1]); err != nil {
+		return
+	}
+
+	if len(d.Args) == 0 {
+		err = errs.New(errs.Phase, "EmptyDirective", "'%s'", line)
+		return
+	}
+
+	d.Command = d.Args[0]
+	d.Args = d.Args[1:]
+
+	return
+}
+
+// Splits command line by spaces, keeping quoted parts together
+func SplitCommand(line string) (words []string, err error) {
+	var (
+		word    strings.Builder
+		quote   rune
+		escaped bool
+		inWord  bool
+	)
+
+	words = make([]string, 0)
+
+	for _, c := range line {
+		switch {
+		case escaped:
+			word.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inWord = true
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+
+	if quote != 0 || escaped {
+		err = errs.New(errs.Phase, "UnterminatedQuote", "'%s'", line)
+		return
+	}
+
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	return
+}
+
+// Relative paths are resolved from package's cache:
+//   - 'get' puts files to $fetch
+//   - 'unpack' takes archives from $fetch and extracts them to $src, types are
+//     zip, 7z, tar, tar.gz, tar.xz, tar.zst, tar.bz2, tar.lz4 or auto (the
+//     default, detected by signature), see unpackOptions for filters
+//   - 'get_unpack <url> [type] <dir>' extracts tarballs while downloading,
+//     see streamOptions
+//   - other commands are running in $src
+func Do(phaseType, line string, vv *vars.Vars, spec pkglua.TargetSpec) (err error) {
+	d, err := ParseLine(line)
+	if err != nil {
+		return
+	}
+
+	args := vv.ExpandVars(&d.Args)
+
+	switch d.Command {
+	case Exec:
+		err = external_cmd(args, vv)
+	case Get:
+		if err = checkArgs(d, 2); err != nil {
+			return
+		}
+		err = phases.GetFile(args[0], inDir(vv.Fetch, args[1]))
+	case Unpack:
+		var opts phases.UnpackOptions
+		if args, opts, err = unpackOptions(args); err != nil {
+			return
+		}
+
+		// Type can be omitted, if there are no selected items
+		if len(args) == 2 {
+			args = append([]string{"auto"}, args...)
+		}
+
+		if len(args) < 3 {
+			err = checkArgs(d, 3)
+			return
+		}
+
+		if len(args) > 3 {
+			opts.SelectedItems = args[3:]
+		}
+
+		if opts.Mtime, opts.Normalize, err = sourceDateEpoch(vv); err != nil {
+			return
+		}
+
+		err = phases.UnpackWith(
+			args[0], inDir(vv.Fetch, args[1]), inDir(vv.Src, args[2]), opts,
+		)
+	case GetUnpack:
+		var opts phases.StreamOptions
+		args, opts.Keep, opts.Sha256 = streamOptions(args)
+		if opts.Keep != "" {
+			opts.Keep = inDir(vv.Fetch, opts.Keep)
+		}
+
+		if args, opts.UnpackOptions, err = unpackOptions(args); err != nil {
+			return
+		}
+
+		if len(args) == 2 {
+			args = []string{args[0], "auto", args[1]}
+		}
+
+		if len(args) < 3 {
+			err = checkArgs(d, 3)
+			return
+		}
+
+		if len(args) > 3 {
+			opts.SelectedItems = args[3:]
+		}
+
+		if opts.Mtime, opts.Normalize, err = sourceDateEpoch(vv); err != nil {
+			return
+		}
+
+		err = phases.GetUnpack(args[0], args[1], inDir(vv.Src, args[2]), opts)
+	case Mkdir:
+		for _, item := range args {
+			if err = mkdir(inDir(vv.Src, item)); err != nil {
+				return
+			}
+		}
+	case Copy, Overwrite:
+		if err = checkArgs(d, 2); err != nil {
+			return
+		}
+
+		overwrite := d.Command == Overwrite
+		err = copyItemOverwrite(inDir(vv.Src, args[0]), inDir(vv.Src, args[1]), overwrite)
+	case SetEnv:
+		if len(args) < 2 {
+			err = checkArgs(d, 2)
+			return
+		}
+		vv.Env[args[0]] = strings.Join(args[1:], " ")
+		log.Debug("Set '%s' to '%s'", args[0], vv.Env[args[0]])
+	case CallPackageManager:
+		err = pkgman(spec["pkgman_"+phaseType], vv)
+	default:
+		err = errs.New(errs.Phase, "UnknownCommand", "'%s'", d.Command)
+	}
+
+	return
+}
+
+// Checks the line like Do, but without running it: command, arguments and
+// options of directives. Variables are not expanded, so links with them
+// are not checked
+func Check(phaseType, line string) (err error) {
+	d, err := ParseLine(line)
+	if err != nil {
+		return
+	}
+
+	args := d.Args
+
+	switch d.Command {
+	case Exec:
+	case Get:
+		if err = checkArgs(d, 2); err != nil {
+			return
+		}
+		err = checkLink(args[0])
+	case Unpack:
+		if args, _, err = unpackOptions(args); err != nil {
+			return
+		}
+
+		if len(args) == 2 {
+			args = append([]string{"auto"}, args...)
+		}
+
+		if len(args) < 3 {
+			err = checkArgs(d, 3)
+			return
+		}
+
+		err = checkArchiveType(args[0])
+	case GetUnpack:
+		var sum string
+		if args, _, sum = streamOptions(args); sum != "" {
+			if _, herr := hex.DecodeString(sum); herr != nil || len(sum) != sha256.Size*2 {
+				err = errs.New(errs.Phase, "WrongStreamOption", "sha256 '%s', expect %d hex digits", sum, sha256.Size*2)
+				return
+			}
+		}
+
+		if args, _, err = unpackOptions(args); err != nil {
+			return
+		}
+
+		if len(args) == 2 {
+			args = []string{args[0], "auto", args[1]}
+		}
+
+		if len(args) < 3 {
+			err = checkArgs(d, 3)
+			return
+		}
+
+		if err = checkLink(args[0]); err != nil {
+			return
+		}
+		err = checkArchiveType(args[1])
+	case Mkdir:
+		if len(args) == 0 {
+			err = errs.New(errs.Phase, "WrongNumberOfArguments", "'%s' takes directories, got nothing", d.Command)
+		}
+	case Copy, Overwrite:
+		err = checkArgs(d, 2)
+	case SetEnv:
+		if len(args) < 2 {
+			err = checkArgs(d, 2)
+		}
+	case CallPackageManager:
+		if err = checkArgs(d, 0); err != nil {
+			return
+		}
+
+		if phaseType != "install" && phaseType != "uninstall" {
+			err = errs.New(errs.Phase, "WrongPhase", "'%s' works in install and uninstall phases, not in %s", d.Command, phaseType)
+		}
+	default:
+		err = errs.New(errs.Phase, "UnknownCommand", "'%s'", d.Command)
+	}
+
+	return
+}
+
+// Links are downloaded over HTTP
+func checkLink(link string) (err error) {
+	if strings.Contains(link, "$") {
+		return
+	}
+
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		err = errs.New(errs.Phase, "WrongLink", "'%s', expect http(s)://host/path", link)
+	}
+
+	return
+}
+
+func checkArchiveType(archType string) (err error) {
+	if types := phases.ArchiveTypes(); !slices.Contains(types, archType) {
+		err = errs.New(errs.Phase, "WrongArchiveType", "'%s', expect one of %v", archType, types)
+	}
+	return
+}
+
+// Takes 'key=value' options of unpack out of arguments:
+//   - strip_components=<count>
+//   - include=<pattern>, exclude=<pattern> (gitignore-like, can be repeated)
+//   - rename=<from>:<to> (can be repeated)
+func unpackOptions(args []string) (rest []string, opts phases.UnpackOptions, err error) {
+	for _, item := range args {
+		key, value, _ := strings.Cut(item, "=")
+
+		switch key {
+		case "strip_components":
+			if opts.StripComponents, err = strconv.Atoi(value); err != nil || opts.StripComponents < 0 {
+				err = errs.New(errs.Phase, "WrongUnpackOption", "strip_components '%s', expect a number", value)
+				return
+			}
+		case "include":
+			opts.Include = append(opts.Include, value)
+		case "exclude":
+			opts.Exclude = append(opts.Exclude, value)
+		case "rename":
+			from, to, ok := strings.Cut(value, ":")
+			if !ok || from == "" {
+				err = errs.New(errs.Phase, "WrongUnpackOption", "rename '%s', expect <from>:<to>", value)
+				return
+			}
+
+			if opts.Rename == nil {
+				opts.Rename = map[string]string{}
+			}
+			opts.Rename[from] = to
+		default:
+			rest = append(rest, item)
+		}
 	}
 
 	return
 }
 
-func external_cmd(exec_base []string, args []string) (err error) {
-	exe := path.Join(exec_base...)
+// Takes 'keep=<file>' (archive is kept in $fetch) and 'sha256=<hex>' out of
+// arguments of get_unpack
+func streamOptions(args []string) (rest []string, keep, sum string) {
+	for _, item := range args {
+		key, value, _ := strings.Cut(item, "=")
+
+		switch key {
+		case "keep":
+			keep = value
+		case "sha256":
+			sum = value
+		default:
+			rest = append(rest, item)
+		}
+	}
+
+	return
+}
+
+// SOURCE_DATE_EPOCH from package's or user's environment makes unpacked
+// files reproducible: modes are normalised and mtimes are set to it
+func sourceDateEpoch(vv *vars.Vars) (mtime time.Time, ok bool, err error) {
+	epoch, ok := vv.Env["SOURCE_DATE_EPOCH"]
+	if !ok {
+		epoch, ok = os.LookupEnv("SOURCE_DATE_EPOCH")
+	}
 
-	cmd := exec.Command(exe, args...)
+	if !ok {
+		return
+	}
+
+	sec, err := strconv.ParseInt(epoch, 10, 64)
+	if err != nil {
+		err = errs.New(errs.Phase, "WrongSourceDateEpoch", "'%s', expect seconds since 1970", epoch)
+		return
+	}
+
+	return time.Unix(sec, 0), true, nil
+}
+
+func checkArgs(d Directive, count int) (err error) {
+	if len(d.Args) != count {
+		err = errs.New(
+			errs.Phase, "WrongNumberOfArguments", "'%s' takes %d arguments, got %d: %v",
+			d.Command, count, len(d.Args), d.Args,
+		)
+	}
+	return
+}
+
+func inDir(dir, pth string) string {
+	if filepath.IsAbs(pth) {
+		return pth
+	}
+
+	return path.Join(dir, pth)
+}
+
+func external_cmd(args []string, vv *vars.Vars) (err error) {
+	if len(args) == 0 {
+		return
+	}
+
+	if err = os.MkdirAll(vv.Src, 0754); err != nil {
+		return
+	}
+
+	// PATH could be changed for the target, like emsdk for web
+	name := args[0]
+	if pth, ok := vv.Env["PATH"]; ok && !strings.ContainsAny(name, `/\`) {
+		for _, dir := range filepath.SplitList(pth) {
+			if found, err := exec.LookPath(filepath.Join(dir, name)); err == nil {
+				name = found
+				break
+			}
+		}
+	}
+
+	cmd := exec.Command(name, args[1:]...)
+	cmd.Dir = vv.Src
+	cmd.Env = os.Environ()
+
+	for k, v := range vv.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
 
-	cmd.Stdout = os.Stdout
 	cmd.Stdin = os.Stdin
-	cmd.Stderr = os.Stderr
+	cmd.Stdout, cmd.Stderr = io.Discard, io.Discard
+
+	if Echo || vv.Log == nil {
+		cmd.Stdout, cmd.Stderr = Stdout, Stderr
+
+		if vv.Prefix != "" {
+			stdout := newPrefixWriter(vv.Prefix, Stdout)
+			stderr := newPrefixWriter(vv.Prefix, Stderr)
+			defer stdout.Flush()
+			defer stderr.Flush()
+
+			cmd.Stdout, cmd.Stderr = stdout, stderr
+		}
+	}
+
+	if vv.Log != nil {
+		cmd.Stdout = io.MultiWriter(cmd.Stdout, vv.Log)
+		cmd.Stderr = io.MultiWriter(cmd.Stderr, vv.Log)
+	}
 
 	err = cmd.Run()
 
 	return
 }
 
-func mkdir(pth []string) (err error) {
-	dir := path.Join(pth...)
-
+func mkdir(dir string) (err error) {
 	if _, err = os.Stat(dir); err == nil {
-		log.Error("Directory '%s' already exists!", dir)
-		err = fmt.Errorf("DirectoryAlreadyExists")
+		err = errs.New(errs.Phase, "DirectoryAlreadyExists", "'%s'", dir)
 		return
 	}
 
 	if err = os.MkdirAll(dir, 0754); err != nil {
-		log.Error("Failed to create '%s': %s", dir, err)
 		return
 	}
 
@@ -82,14 +500,12 @@ func mkdir(pth []string) (err error) {
 
 func copyItemOverwrite(from, to string, overwrite bool) (err error) {
 	if _, err = os.Stat(to); err == nil && !overwrite {
-		log.Error("Cannot copy '%s' to '%s': it already exists", from, to)
-		err = fmt.Errorf("FileAlreadyExists")
+		err = errs.New(errs.Phase, "FileAlreadyExists", "cannot copy '%s' to '%s'", from, to)
 		return
 	}
 
 	fInfo, err := os.Stat(from)
 	if err != nil {
-		log.Error("Failed to get info of '%s': %s", from, err)
 		return
 	}
 
@@ -100,27 +516,22 @@ func copyItemOverwrite(from, to string, overwrite bool) (err error) {
 		)
 		inpFile, err = os.Open(from)
 		if err != nil {
-			log.Error("Failed to open '%s': %s", from, err)
 			return
 		}
 		defer inpFile.Close()
 
 		outFile, err = os.Create(to)
 		if err != nil {
-			log.Error("Failed to create '%s': %s", to, err)
 			return
 		}
 		defer outFile.Close()
 
 		src := &progress.PassThru{Reader: inpFile}
 
-		if _, err = io.Copy(outFile, src); err != nil {
-			log.Error("Failed to copy '%s': %s", from, err)
-		}
+		_, err = io.Copy(outFile, src)
 	} else {
 		var files []os.DirEntry
 		if err = os.MkdirAll(to, 0754); err != nil {
-			log.Error("Failed to create '%s': %s", to, err)
 			return
 		}
 
@@ -130,15 +541,9 @@ func copyItemOverwrite(from, to string, overwrite bool) (err error) {
 				nTo := path.Join(to, item.Name())
 
 				if err = copyItemOverwrite(nFrom, nTo, overwrite); err != nil {
-					log.Error(
-						"Failed to copy '%s' to '%s': %s",
-						item.Name(), to, err,
-					)
 					return
 				}
 			}
-		} else {
-			log.Error("Failed to read '%s': %s", from, err)
 		}
 	}
 	log.Debug("'%s' created", to)
@@ -146,84 +551,32 @@ func copyItemOverwrite(from, to string, overwrite bool) (err error) {
 	return
 }
 
-func pkgman(pkgFiles, phase string) (err error) {
-	var (
-		osRelease    *os.File
-		packagesList *os.File
-		distro       string
-		pm           []string
-	)
+// pm is a full command, that pkglua got from package's 'packages' table
+var pkgmanLock sync.Mutex
 
-	if osRelease, err = os.Open("/etc/os-release"); err != nil {
-		log.Errorln("Failed to read /etc/os-release:", err)
+func pkgman(pm []string, vv *vars.Vars) (err error) {
+	if len(pm) == 0 {
+		err = errs.New(errs.Phase, "DistroIsNotSupported", "package doesn't provide packages for your distro")
 		return
 	}
-	defer osRelease.Close()
-
-	scan := bufio.NewScanner(osRelease)
 
-	for scan.Scan() {
-		if strings.HasPrefix(scan.Text(), "ID=") {
-			distro = scan.Text()[3:]
-			log.Info("Linux distro is '%s'", distro)
-			break
-		}
-	}
+	// Package managers take own lock and may ask for password
+	pkgmanLock.Lock()
+	defer pkgmanLock.Unlock()
 
-	switch distro {
-	case "arch", "manjaro":
-		pm = []string{"pacman"}
-		if phase == "install" {
-			pm = append(pm, "-Sy")
-		} else if phase == "uninstall" {
-			pm = append(pm, "-R")
-		}
+	log.Info("Calling '%s'", strings.Join(pm, " "))
 
-		pm = append(pm, "--noconfirm")
-		pm = append(pm, "--needed")
-	case "fedora":
-		pm = []string{"dnf"}
-		if phase == "install" {
-			pm = append(pm, "install")
-		} else if phase == "uninstall" {
-			pm = append(pm, "remove")
-		}
-	case "ubuntu", "debian":
-		pm = []string{"apt"}
-		if phase == "install" {
-			pm = append(pm, "install")
-		} else if phase == "uninstall" {
-			pm = append(pm, "purge")
-		}
-	case "void":
-		if phase == "install" {
-			pm = []string{"xbps-install"}
-		} else if phase == "uninstall" {
-			pm = []string{"xbps-remove"}
-		}
-	default:
-		log.Error("Package manager for '%s' is not implemented yet", distro)
-	}
-
-	f := path.Join(pkgFiles, pm[0]) + ".txt"
-	if packagesList, err = os.Open(f); err !=
-		nil {
-		log.Error("Failed to open '%s': %s", f, err)
-		return
-	}
-	defer packagesList.Close()
-
-	buf := bufio.NewScanner(packagesList)
+	cmd := exec.Command(pm[0], pm[1:]...)
+	cmd.Stdin = os.Stdin
 
-	for buf.Scan() {
-		pm = append(pm, buf.Text())
+	// Package managers may ask questions, so their output is always shown,
+	// whole lines are not waited for
+	cmd.Stdout, cmd.Stderr = Stdout, Stderr
+	if vv.Log != nil {
+		cmd.Stdout = io.MultiWriter(cmd.Stdout, vv.Log)
+		cmd.Stderr = io.MultiWriter(cmd.Stderr, vv.Log)
 	}
 
-	cmd := exec.Command("sudo", pm...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Stdin = os.Stdin
-
 	err = cmd.Run()
 	return
 }
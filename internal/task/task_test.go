@@ -0,0 +1,144 @@
+package task
+
+import (
+	"io"
+	"raypm/internal/vars"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseLine(t *testing.T) {
+	t.Run("directive", func(t *testing.T) {
+		d, err := ParseLine("${unpack 7z w64devkit.exe w64devkit}")
+		if err != nil {
+			t.Error(err)
+		}
+
+		if d.Command != Unpack {
+			t.Errorf("Expect '%s', got '%s'", Unpack, d.Command)
+		}
+
+		want := []string{"7z", "w64devkit.exe", "w64devkit"}
+		if !slices.Equal(d.Args, want) {
+			t.Errorf("Expect %v, got %v", want, d.Args)
+		}
+	})
+
+	t.Run("command with quotes", func(t *testing.T) {
+		d, err := ParseLine("go build -x -ldflags '-s -w' -o build .")
+		if err != nil {
+			t.Error(err)
+		}
+
+		if d.Command != Exec {
+			t.Errorf("Expect '%s', got '%s'", Exec, d.Command)
+		}
+
+		want := []string{"go", "build", "-x", "-ldflags", "-s -w", "-o", "build", "."}
+		if !slices.Equal(d.Args, want) {
+			t.Errorf("Expect %v, got %v", want, d.Args)
+		}
+	})
+
+	t.Run("broken lines", func(t *testing.T) {
+		for _, line := range []string{"${copy a b", "${}", "echo 'hello"} {
+			if _, err := ParseLine(line); err == nil {
+				t.Errorf("Expect error for '%s'", line)
+			}
+		}
+	})
+}
+
+func TestUnpackOptions(t *testing.T) {
+	args := []string{
+		"raylib.tar.gz", "$out", "strip_components=1", "include=lib/*.so*",
+		"exclude=*.a", "rename=lib:libs",
+	}
+
+	rest, opts, err := unpackOptions(args)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	if want := []string{"raylib.tar.gz", "$out"}; !slices.Equal(rest, want) {
+		t.Errorf("Expect %v, got %v", want, rest)
+	}
+
+	if opts.StripComponents != 1 || !slices.Equal(opts.Include, []string{"lib/*.so*"}) ||
+		!slices.Equal(opts.Exclude, []string{"*.a"}) || opts.Rename["lib"] != "libs" {
+		t.Errorf("Wrong options: %+v", opts)
+	}
+
+	for _, item := range []string{"strip_components=x", "rename=lib"} {
+		if _, _, err := unpackOptions([]string{item}); err == nil {
+			t.Errorf("Expect error for '%s'", item)
+		}
+	}
+}
+
+func TestPrefixWriter(t *testing.T) {
+	var out strings.Builder
+	w := newPrefixWriter("[go] ", &out)
+
+	w.Write([]byte("first\nsec"))
+	w.Write([]byte("ond\nlast"))
+	w.Flush()
+
+	if want := "[go] first\n[go] second\n[go] last\n"; out.String() != want {
+		t.Errorf("Expect %q, got %q", want, out.String())
+	}
+}
+
+func TestCheck(t *testing.T) {
+	sum := strings.Repeat("ab", 32)
+
+	for _, line := range []string{
+		"make -j4 PREFIX=$out",
+		"${get https://example.com/raylib.zip raylib.zip}",
+		"${get $url raylib.zip}",
+		"${unpack raylib.zip $src}",
+		"${unpack tgz raylib.tgz $src include/raylib.h strip_components=1}",
+		"${get_unpack https://example.com/raylib.tar.gz . keep=raylib.tar.gz sha256=" + sum + "}",
+		"${mkdir build lib}",
+		"${setenv CFLAGS -O2 -g}",
+	} {
+		if err := Check("build", line); err != nil {
+			t.Errorf("'%s': %s", line, err)
+		}
+	}
+
+	for _, item := range []struct{ phase, line string }{
+		{"build", "${copy a}"},
+		{"build", "${run make}"},
+		{"build", "${mkdir}"},
+		{"fetch", "${get ftp://example.com/raylib.zip raylib.zip}"},
+		{"fetch", "${get raylib.zip raylib.zip}"},
+		{"unpack", "${unpack tgzz raylib.tgz .}"},
+		{"unpack", "${unpack raylib.tgz . strip_components=x}"},
+		{"unpack", "${get_unpack https://example.com/raylib.tar.gz . sha256=abc}"},
+		{"build", "${pkgman}"},
+		{"install", "${pkgman raylib}"},
+	} {
+		if err := Check(item.phase, item.line); err == nil {
+			t.Errorf("Expect error for '%s' in %s phase", item.line, item.phase)
+		}
+	}
+}
+
+func TestPkgman(t *testing.T) {
+	prev := Stdout
+	Stdout = io.Discard
+	defer func() { Stdout = prev }()
+
+	var phaseLog strings.Builder
+	if err := pkgman([]string{"echo", "installed"}, &vars.Vars{Log: &phaseLog}); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	if got := phaseLog.String(); got != "installed\n" {
+		t.Errorf("Expect output in log of the phase, got %q", got)
+	}
+}
@@ -0,0 +1,174 @@
+// Targets as os/arch(/abi) triples, like 'windows/386' or
+// 'linux/arm64/musl'. Missing parts are filled with defaults, so 'windows'
+// is the same target as 'windows/amd64/gnu'
+package triple
+
+import (
+	"raypm/internal/errs"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+type Triple struct {
+	OS   string
+	Arch string // GOARCH
+	ABI  string // C library, empty for systems with the only one
+}
+
+// Supported architectures, the first one is default, if the target is not
+// the host system
+var archs = map[string][]string{
+	"linux":   {"amd64", "386", "arm64", "arm"},
+	"windows": {"amd64", "386", "arm64"},
+	"darwin":  {"amd64", "arm64"},
+	"android": {"arm64", "arm", "386", "amd64"},
+	"web":     {"wasm"},
+}
+
+// Supported ABIs, the first one is default
+var abis = map[string][]string{
+	"linux":   {"gnu", "musl"},
+	"windows": {"gnu"},
+}
+
+// Names from compiler triples and other tools
+var archAliases = map[string]string{
+	"x86_64":  "amd64",
+	"x64":     "amd64",
+	"aarch64": "arm64",
+	"i386":    "386",
+	"i686":    "386",
+	"x86":     "386",
+	"armv7":   "arm",
+	"wasm32":  "wasm",
+}
+
+func OSes() []string {
+	return []string{"linux", "windows", "darwin", "android", "web"}
+}
+
+func Host() Triple {
+	t, err := Parse(runtime.GOOS + "/" + runtime.GOARCH)
+	if err != nil {
+		return Triple{OS: runtime.GOOS, Arch: runtime.GOARCH}
+	}
+	return t
+}
+
+// Parses 'os[/arch[/abi]]'. Arch of the host is default for the same OS
+func Parse(s string) (t Triple, err error) {
+	parts := strings.Split(strings.ToLower(strings.TrimSpace(s)), "/")
+	if len(parts) > 3 {
+		err = errs.New(errs.Usage, "WrongTarget", "'%s', expect os/arch/abi", s)
+		return
+	}
+
+	t.OS = parts[0]
+	supported, ok := archs[t.OS]
+	if !ok {
+		err = errs.New(errs.Usage, "UnsupportedOS", "'%s', expect one of %v", t.OS, OSes())
+		return
+	}
+
+	if len(parts) > 1 && parts[1] != "" {
+		t.Arch = parts[1]
+		if alias, ok := archAliases[t.Arch]; ok {
+			t.Arch = alias
+		}
+	} else if t.OS == runtime.GOOS && slices.Contains(supported, runtime.GOARCH) {
+		t.Arch = runtime.GOARCH
+	} else {
+		t.Arch = supported[0]
+	}
+
+	if !slices.Contains(supported, t.Arch) {
+		err = errs.New(errs.Usage, "UnsupportedArch", "'%s' for %s, expect one of %v", t.Arch, t.OS, supported)
+		return
+	}
+
+	if len(parts) > 2 && parts[2] != "" {
+		t.ABI = parts[2]
+		if !slices.Contains(abis[t.OS], t.ABI) {
+			err = errs.New(errs.Usage, "UnsupportedABI", "'%s' for %s, expect one of %v", t.ABI, t.OS, abis[t.OS])
+			return
+		}
+	} else if len(abis[t.OS]) > 0 {
+		t.ABI = abis[t.OS][0]
+	}
+
+	return
+}
+
+func (t Triple) String() string {
+	if t.ABI == "" {
+		return t.OS + "/" + t.Arch
+	}
+	return t.OS + "/" + t.Arch + "/" + t.ABI
+}
+
+func (t Triple) GOOS() string {
+	if t.OS == "web" {
+		return "js"
+	}
+	return t.OS
+}
+
+func (t Triple) GOARCH() string {
+	return t.Arch
+}
+
+// Compiler triple, like 'x86_64-w64-mingw32' or 'aarch64-linux-gnu'
+func (t Triple) Compiler() string {
+	prefix := map[string]string{
+		"amd64": "x86_64",
+		"386":   "i686",
+		"arm64": "aarch64",
+		"arm":   "arm",
+		"wasm":  "wasm32",
+	}[t.Arch]
+
+	switch t.OS {
+	case "linux":
+		abi := t.ABI
+		if t.Arch == "arm" {
+			abi += "eabihf"
+		}
+		return prefix + "-linux-" + abi
+	case "windows":
+		return prefix + "-w64-mingw32"
+	case "darwin":
+		return prefix + "-apple-darwin"
+	case "android":
+		if t.Arch == "arm" {
+			return "armv7a-linux-androideabi"
+		}
+		return prefix + "-linux-android"
+	case "web":
+		return "wasm32-unknown-emscripten"
+	}
+
+	return ""
+}
+
+// Environment for Go and C. Cross compilers are GCC named by compiler
+// triple (like 'x86_64-w64-mingw32-gcc'), other systems have own toolchains
+func (t Triple) Env(host Triple) (env map[string]string) {
+	env = map[string]string{
+		"GOOS":         t.GOOS(),
+		"GOARCH":       t.GOARCH(),
+		"CGO_ENABLED":  "1",
+		"RAYPM_TARGET": t.String(),
+	}
+
+	if t.Arch == "arm" {
+		env["GOARM"] = "7"
+	}
+
+	if t != host && (t.OS == "linux" || t.OS == "windows") {
+		env["CC"] = t.Compiler() + "-gcc"
+		env["CXX"] = t.Compiler() + "-g++"
+	}
+
+	return
+}
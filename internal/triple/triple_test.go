@@ -0,0 +1,91 @@
+package triple
+
+import (
+	"errors"
+	"raypm/internal/errs"
+	"runtime"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	t.Run("full and short forms", func(t *testing.T) {
+		tests := map[string]string{
+			"windows/386":       "windows/386/gnu",
+			"windows/x86_64":    "windows/amd64/gnu",
+			"linux/arm64/musl":  "linux/arm64/musl",
+			"Linux/aarch64/gnu": "linux/arm64/gnu",
+			"darwin/arm64":      "darwin/arm64",
+			"android":           "android/arm64",
+			"web":               "web/wasm",
+		}
+
+		for input, want := range tests {
+			got, err := Parse(input)
+			if err != nil {
+				t.Errorf("'%s': %s", input, err)
+				continue
+			}
+
+			if got.String() != want {
+				t.Errorf("Expect '%s' for '%s', got '%s'", want, input, got)
+			}
+		}
+	})
+
+	t.Run("host arch is default", func(t *testing.T) {
+		got, err := Parse(runtime.GOOS)
+		if err != nil {
+			return // Host system is not a target
+		}
+
+		if got != Host() {
+			t.Errorf("Expect '%s', got '%s'", Host(), got)
+		}
+	})
+
+	t.Run("wrong triples", func(t *testing.T) {
+		for _, item := range []string{"", "haiku", "darwin/386", "windows/amd64/musl", "linux/amd64/gnu/x"} {
+			if _, err := Parse(item); !errors.Is(err, errs.Usage) {
+				t.Errorf("Expect usage error for '%s', got %v", item, err)
+			}
+		}
+	})
+}
+
+func TestCompiler(t *testing.T) {
+	tests := map[string]string{
+		"windows/amd64":    "x86_64-w64-mingw32",
+		"windows/386":      "i686-w64-mingw32",
+		"linux/arm64":      "aarch64-linux-gnu",
+		"linux/arm":        "arm-linux-gnueabihf",
+		"linux/amd64/musl": "x86_64-linux-musl",
+		"android/arm":      "armv7a-linux-androideabi",
+		"darwin/arm64":     "aarch64-apple-darwin",
+	}
+
+	for input, want := range tests {
+		target, _ := Parse(input)
+		if got := target.Compiler(); got != want {
+			t.Errorf("Expect '%s' for '%s', got '%s'", want, input, got)
+		}
+	}
+}
+
+func TestEnv(t *testing.T) {
+	host, _ := Parse("linux/amd64")
+	target, _ := Parse("windows/386")
+
+	env := target.Env(host)
+	if env["GOOS"] != "windows" || env["GOARCH"] != "386" || env["CC"] != "i686-w64-mingw32-gcc" {
+		t.Errorf("Wrong env: %v", env)
+	}
+
+	if _, ok := host.Env(host)["CC"]; ok {
+		t.Error("Expect default compiler for native build")
+	}
+
+	web, _ := Parse("web")
+	if web.Env(host)["GOOS"] != "js" {
+		t.Error("Expect GOOS 'js' for web")
+	}
+}
@@ -1,6 +1,7 @@
 package vars
 
 import (
+	"io"
 	"path"
 	log "raypm/pkg/slog"
 	"strings"
@@ -13,19 +14,34 @@ type Vars struct {
 	Fetch   string
 	Cache   string
 	Package string
-	Dep     []string
+	Dep     string            // Links to directories of dependencies by their names
+	Abi     string            // Android ABI, which is being built now
+	Env     map[string]string // Set by '${setenv}' for next commands
+	Prefix  string            // Prefix of commands' output, packages are built in parallel
+	Log     io.Writer         // Log of the running phase, gets output of commands
 }
 
+// Variables of phases, like '$src', see matchAndReplace
+var Names = []string{"src", "out", "fetch", "cache", "pkg", "dep", "abi"}
+
 // 'base' is a path to '.raypm'
-func NewVars(base, packageName string) (vv *Vars) {
+// 'entry' is the store entry of the build, so builds for other targets or
+// versions don't share '$cache'
+// 'out' overrides $out, if it's empty the package goes to the store
+func NewVars(base, packageName, entry, out string) (vv *Vars) {
 	vv = &Vars{
 		Base: base,
+		Env:  make(map[string]string),
 	}
 
-	vv.Cache = path.Join(vv.Base, "cache", packageName)
+	vv.Cache = path.Join(vv.Base, "cache", entry)
 	vv.Src = path.Join(vv.Cache, "src")
 	vv.Fetch = path.Join(vv.Cache, "fetch")
-	vv.Out = path.Join(vv.Base, "store", packageName)
+	vv.Dep = path.Join(vv.Cache, "deps")
+	vv.Out = out
+	if vv.Out == "" {
+		vv.Out = path.Join(vv.Base, "store", entry)
+	}
 	vv.Package = path.Join(vv.Base, "pkgs", packageName)
 	return
 }
@@ -53,7 +69,8 @@ func (vv *Vars) matchAndReplace(text string) (word string) {
 	word = strings.ReplaceAll(word, "$fetch", vv.Fetch)
 	word = strings.ReplaceAll(word, "$cache", vv.Cache)
 	word = strings.ReplaceAll(word, "$pkg", vv.Package)
-	word = strings.ReplaceAll(word, "$dep", path.Join(".raypm", "store"))
+	word = strings.ReplaceAll(word, "$dep", vv.Dep)
+	word = strings.ReplaceAll(word, "$abi", vv.Abi)
 
 	return
 }
@@ -0,0 +1,77 @@
+package web
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"raypm/internal/errs"
+	log "raypm/pkg/slog"
+	"strings"
+)
+
+const DefaultAddr = "localhost:8080"
+
+// Serves output of emcc. Browsers refuse to run .wasm from file://, also
+// SharedArrayBuffer (needed for -pthread) works only with COOP/COEP headers
+func Handler(dir string) http.Handler {
+	files := http.FileServer(http.Dir(dir))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("Cross-Origin-Opener-Policy", "same-origin")
+		h.Set("Cross-Origin-Embedder-Policy", "require-corp")
+		h.Set("Cache-Control", "no-cache")
+
+		switch path.Ext(r.URL.Path) {
+		case ".wasm":
+			h.Set("Content-Type", "application/wasm")
+		case ".data":
+			h.Set("Content-Type", "application/octet-stream")
+		}
+
+		// emcc is often called with '-o game.html', open it instead of listing
+		if r.URL.Path == "/" {
+			if page := indexPage(dir); page != "" {
+				http.Redirect(w, r, "/"+page, http.StatusFound)
+				return
+			}
+		}
+
+		files.ServeHTTP(w, r)
+	})
+}
+
+// Returns the only .html file in 'dir', if there is no index.html.
+// Shell template is not a page
+func indexPage(dir string) (page string) {
+	if _, err := os.Stat(path.Join(dir, "index.html")); err == nil {
+		return
+	}
+
+	items, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	for _, item := range items {
+		if item.IsDir() || !strings.HasSuffix(item.Name(), ".html") || item.Name() == ShellFile {
+			continue
+		}
+
+		if page != "" {
+			return ""
+		}
+		page = item.Name()
+	}
+
+	return
+}
+
+func Serve(dir, addr string) (err error) {
+	if _, err = os.Stat(dir); err != nil {
+		return errs.Wrap(errs.Usage, "NothingToServe", err, "")
+	}
+
+	log.Info("Serving '%s' on http://%s", dir, addr)
+	return http.ListenAndServe(addr, Handler(dir))
+}
@@ -0,0 +1,199 @@
+// Web target: Emscripten from 'emsdk' package, HTML shell and assets, that
+// are preloaded to virtual file system of the game.
+//
+// Before build phase raypm writes shell.html and assets.json to $out and
+// sets environment for raylib's PLATFORM_WEB, so the phase could be like:
+//
+//	emcc main.c -o $out/index.html --shell-file $RAYPM_SHELL_FILE $RAYPM_PRELOAD ...
+package web
+
+import (
+	_ "embed"
+	"encoding/json"
+	"html"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"raypm/internal/errs"
+	"raypm/internal/pkglua"
+	"runtime"
+	"strings"
+)
+
+const (
+	// Name of the dependency with Emscripten SDK
+	EmsdkPackage = "emsdk"
+
+	ShellFile  = "shell.html"
+	AssetsFile = "assets.json"
+)
+
+//go:embed shell.html
+var defaultShell string
+
+type Target struct {
+	Name      string
+	Emsdk     string   // Root of emsdk (directory of 'emsdk' package in the store)
+	Assets    []string // Files and directories from $src, that are preloaded
+	ShellFile string   // Custom shell from $src, embedded one is used if empty
+	Src       string
+	Out       string
+}
+
+// Creates the target from package's fields:
+//   - assets (files and directories relative to $src)
+//   - shell_file (HTML template for emcc's '--shell-file')
+func NewTarget(name string, spec pkglua.TargetSpec, emsdk, src, out string) (t *Target) {
+	t = &Target{
+		Name:   name,
+		Emsdk:  emsdk,
+		Assets: spec["assets"],
+		Src:    src,
+		Out:    out,
+	}
+
+	t.ShellFile = spec.Value("shell_file")
+	return
+}
+
+func (t *Target) EmscriptenDir() string {
+	return path.Join(t.Emsdk, "upstream", "emscripten")
+}
+
+func (t *Target) tool(name string) string {
+	if runtime.GOOS == "windows" {
+		name += ".bat"
+	}
+
+	return filepath.Join(t.EmscriptenDir(), name)
+}
+
+// Environment for raylib's Makefile with PLATFORM_WEB and for plain emcc
+func (t *Target) Env() (env map[string]string) {
+	env = map[string]string{
+		"PLATFORM":         "PLATFORM_WEB",
+		"GOOS":             "js",
+		"GOARCH":           "wasm",
+		"CC":               t.tool("emcc"),
+		"CXX":              t.tool("em++"),
+		"AR":               t.tool("emar"),
+		"EMSDK":            t.Emsdk,
+		"EMSDK_PATH":       t.Emsdk,
+		"EMSCRIPTEN_PATH":  t.EmscriptenDir(),
+		"PATH":             t.EmscriptenDir() + string(os.PathListSeparator) + os.Getenv("PATH"),
+		"RAYPM_SHELL_FILE": path.Join(t.Out, ShellFile),
+		"RAYPM_PRELOAD":    strings.Join(t.PreloadFlags(), " "),
+	}
+
+	return
+}
+
+// Checks, that emsdk has emcc
+func (t *Target) CheckEmsdk() (err error) {
+	if t.Emsdk == "" {
+		return errs.New(errs.Resolution, "EmsdkNotFound", "add '%s' to dependencies", EmsdkPackage)
+	}
+
+	if _, err = os.Stat(t.tool("emcc")); err != nil {
+		return errs.Wrap(errs.Resolution, "EmccNotFound", err, "'%s'", t.tool("emcc"))
+	}
+
+	return
+}
+
+// emcc's flags, that put assets to the same paths in virtual file system
+func (t *Target) PreloadFlags() (flags []string) {
+	for _, item := range t.Assets {
+		item = strings.Trim(path.Clean(item), "/")
+		flags = append(flags, "--preload-file", path.Join(t.Src, item)+"@/"+item)
+	}
+
+	return
+}
+
+type Asset struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+type AssetsManifest struct {
+	Files []Asset `json:"files"`
+	Total int64   `json:"total"` // Size of all files, to show loading progress
+}
+
+// Lists files of assets, missing assets are errors, because emcc would fail
+// later with less clear message
+func (t *Target) Manifest() (m *AssetsManifest, err error) {
+	m = &AssetsManifest{Files: make([]Asset, 0)}
+
+	for _, item := range t.Assets {
+		root := path.Join(t.Src, item)
+
+		err = filepath.WalkDir(root, func(pth string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+
+			rel, err := filepath.Rel(t.Src, pth)
+			if err != nil {
+				return err
+			}
+
+			m.Files = append(m.Files, Asset{Path: filepath.ToSlash(rel), Size: info.Size()})
+			m.Total += info.Size()
+			return nil
+		})
+
+		if err != nil {
+			err = errs.Wrap(errs.Phase, "AssetNotFound", err, "'%s'", item)
+			return
+		}
+	}
+
+	return
+}
+
+// Writes shell.html and assets.json to $out
+func (t *Target) Prepare() (err error) {
+	shell := defaultShell
+	if t.ShellFile != "" {
+		var data []byte
+		if data, err = os.ReadFile(path.Join(t.Src, t.ShellFile)); err != nil {
+			err = errs.Wrap(errs.Phase, "ShellFileNotFound", err, "'%s'", t.ShellFile)
+			return
+		}
+		shell = string(data)
+	}
+
+	if !strings.Contains(shell, "{{{ SCRIPT }}}") {
+		return errs.New(errs.Phase, "WrongShellFile", "shell file must have '{{{ SCRIPT }}}', emcc puts the game there")
+	}
+
+	shell = strings.ReplaceAll(shell, "{{{ RAYPM_NAME }}}", html.EscapeString(t.Name))
+	if err = os.WriteFile(path.Join(t.Out, ShellFile), []byte(shell), 0644); err != nil {
+		err = errs.Wrap(errs.Phase, "CannotWriteShellFile", err, "")
+		return
+	}
+
+	m, err := t.Manifest()
+	if err != nil {
+		return
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return
+	}
+
+	if err = os.WriteFile(path.Join(t.Out, AssetsFile), data, 0644); err != nil {
+		err = errs.Wrap(errs.Phase, "CannotWriteAssetsManifest", err, "")
+	}
+
+	return
+}
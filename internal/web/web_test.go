@@ -0,0 +1,126 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	log "raypm/pkg/slog"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPrepare(t *testing.T) {
+	log.Init(false)
+
+	src, err := os.MkdirTemp(os.TempDir(), "web_test_*")
+	if err != nil {
+		t.Errorf("Cannot create temp directory: %s\n", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(src)
+
+	out := path.Join(src, "build")
+	os.MkdirAll(path.Join(src, "resources", "sounds"), 0754)
+	os.MkdirAll(out, 0754)
+	os.WriteFile(path.Join(src, "resources", "map.txt"), []byte("####"), 0644)
+	os.WriteFile(path.Join(src, "resources", "sounds", "eat.wav"), []byte("RIFF"), 0644)
+
+	target := NewTarget("<snake>", map[string][]string{"assets": {"resources/"}}, "/store/a-emsdk-4", src, out)
+
+	t.Run("preload flags", func(t *testing.T) {
+		want := []string{"--preload-file", path.Join(src, "resources") + "@/resources"}
+		if got := target.PreloadFlags(); !slices.Equal(got, want) {
+			t.Errorf("Expect %v, got %v", want, got)
+		}
+	})
+
+	t.Run("shell and manifest", func(t *testing.T) {
+		if err := target.Prepare(); err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		shell, _ := os.ReadFile(path.Join(out, ShellFile))
+		if !strings.Contains(string(shell), "<title>&lt;snake&gt;</title>") {
+			t.Error("Expect escaped name in the title")
+		}
+
+		var m AssetsManifest
+		data, _ := os.ReadFile(path.Join(out, AssetsFile))
+		if err := json.Unmarshal(data, &m); err != nil {
+			t.Error(err)
+		}
+
+		want := []Asset{{"resources/map.txt", 4}, {"resources/sounds/eat.wav", 4}}
+		if !slices.Equal(m.Files, want) || m.Total != 8 {
+			t.Errorf("Expect %v, got %v", want, m)
+		}
+	})
+
+	t.Run("missing asset", func(t *testing.T) {
+		missing := NewTarget("snake", map[string][]string{"assets": {"music"}}, "", src, out)
+		if err := missing.Prepare(); err == nil {
+			t.Error("Expect error for missing asset")
+		}
+	})
+
+	t.Run("env", func(t *testing.T) {
+		env := target.Env()
+		if env["PLATFORM"] != "PLATFORM_WEB" || env["EMSDK"] != "/store/a-emsdk-4" {
+			t.Errorf("Wrong env: %v", env)
+		}
+
+		if err := target.CheckEmsdk(); err == nil {
+			t.Error("Expect error for missing emcc")
+		}
+	})
+}
+
+func TestHandler(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "serve_test_*")
+	if err != nil {
+		t.Errorf("Cannot create temp directory: %s\n", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	os.WriteFile(path.Join(dir, "snake.html"), []byte("<html></html>"), 0644)
+	os.WriteFile(path.Join(dir, "snake.wasm"), []byte("\x00asm"), 0644)
+	os.WriteFile(path.Join(dir, ShellFile), []byte("{{{ SCRIPT }}}"), 0644)
+
+	server := httptest.NewServer(Handler(dir))
+	defer server.Close()
+
+	t.Run("wasm", func(t *testing.T) {
+		resp, err := http.Get(server.URL + "/snake.wasm")
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		resp.Body.Close()
+
+		if got := resp.Header.Get("Content-Type"); got != "application/wasm" {
+			t.Errorf("Expect 'application/wasm', got '%s'", got)
+		}
+
+		if got := resp.Header.Get("Cross-Origin-Embedder-Policy"); got != "require-corp" {
+			t.Errorf("Expect COEP header, got '%s'", got)
+		}
+	})
+
+	t.Run("index", func(t *testing.T) {
+		resp, err := http.Get(server.URL + "/")
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		resp.Body.Close()
+
+		if resp.Request.URL.Path != "/snake.html" {
+			t.Errorf("Expect redirect to '/snake.html', got '%s'", resp.Request.URL.Path)
+		}
+	})
+}
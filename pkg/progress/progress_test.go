@@ -5,6 +5,7 @@ import (
 	"io"
 	"os"
 	"path"
+	"strings"
 	"testing"
 	"time"
 )
@@ -56,3 +57,109 @@ func TestCopyingProgress(t *testing.T) {
 		fmt.Println()
 	})
 }
+
+// Renderer with manual clock
+func testRenderer(tty bool) (r *Renderer, out *strings.Builder, logs *[]string, clock *time.Time) {
+	out = &strings.Builder{}
+	logs = &[]string{}
+	clock = &time.Time{}
+	*clock = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	r = NewRenderer(out)
+	r.tty = tty
+	r.now = func() time.Time { return *clock }
+	r.logf = func(format string, params ...any) {
+		*logs = append(*logs, fmt.Sprintf(format, params...))
+	}
+	return
+}
+
+func TestRenderer(t *testing.T) {
+	t.Run("line with rate and ETA", func(t *testing.T) {
+		r, _, _, clock := testRenderer(true)
+
+		bar := r.Start("Downloading", "raylib.zip", 12000)
+		*clock = clock.Add(2 * time.Second)
+		bar.Add(3000)
+
+		want := "Downloading raylib.zip   25.0% 3.00 Kb/12.00 Kb  1.50 Kb/s  ETA 6s"
+		if got := bar.String(); got != want {
+			t.Errorf("Expect '%s', got '%s'", want, got)
+		}
+	})
+
+	t.Run("unknown size", func(t *testing.T) {
+		r, _, _, _ := testRenderer(true)
+
+		bar := r.Start("Downloading", "sdk.tar.xz", -1)
+		bar.Add(100)
+		if got := bar.String(); strings.Contains(got, "%") || strings.Contains(got, "ETA") {
+			t.Errorf("Expect no percents and ETA, got '%s'", got)
+		}
+	})
+
+	t.Run("terminal", func(t *testing.T) {
+		r, out, _, clock := testRenderer(true)
+
+		first := r.Start("Downloading", "first", 100)
+		second := r.Start("Unpacking", "second", 100)
+		*clock = clock.Add(time.Second)
+		first.Add(50)
+		first.Finish(nil)
+		second.Finish(fmt.Errorf("NoSpace"))
+
+		if len(r.bars) != 0 || r.lines != 0 {
+			t.Errorf("Expect no bars, got %d drawn in %d lines", len(r.bars), r.lines)
+		}
+
+		for _, item := range []string{"\033[2A", "Downloading first: 50.00 Bytes in 1s\n", "Unpacking second: failed after 0.00 Bytes: NoSpace\n"} {
+			if !strings.Contains(out.String(), item) {
+				t.Errorf("Expect %q in output", item)
+			}
+		}
+	})
+
+	t.Run("other output", func(t *testing.T) {
+		r, out, _, _ := testRenderer(true)
+		w := r.Wrap(out)
+
+		r.Start("Downloading", "first", 100)
+		out.Reset()
+
+		fmt.Fprint(w, "line\n")
+		if got := out.String(); !strings.HasPrefix(got, "\033[1A\r\033[J"+"line\n") || !strings.HasSuffix(got, "Downloading first    0.0% 0.00 Bytes/100.00 Bytes  0.00 Bytes/s\n") {
+			t.Errorf("Expect cleared bars, line and bars again, got %q", got)
+		}
+
+		out.Reset()
+		fmt.Fprint(w, "Continue? ")
+		if got := out.String(); !strings.HasSuffix(got, "Continue? ") || r.lines != 0 {
+			t.Errorf("Expect no bars after unfinished line, got %q", got)
+		}
+
+		fmt.Fprint(w, "y\n")
+		if r.lines != 1 {
+			t.Errorf("Expect bars after the end of line, got %d lines", r.lines)
+		}
+	})
+
+	t.Run("log lines without terminal", func(t *testing.T) {
+		r, out, logs, clock := testRenderer(false)
+
+		bar := r.Start("Downloading", "go.tar.gz", 1000)
+		for range 12 {
+			*clock = clock.Add(time.Second)
+			bar.Add(50)
+		}
+		bar.Finish(nil)
+
+		if out.Len() != 0 {
+			t.Errorf("Expect nothing in output, got %q", out.String())
+		}
+
+		// Start, two periodic lines (5s and 10s) and result
+		if len(*logs) != 4 || !strings.Contains((*logs)[1], "%") {
+			t.Errorf("Wrong log lines: %q", *logs)
+		}
+	})
+}
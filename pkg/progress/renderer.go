@@ -0,0 +1,273 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	log "raypm/pkg/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Progress of downloads and unpacks, that are running at the same time.
+// On a terminal every transfer has own line, that is redrawn in place.
+// Otherwise (pipe, file, CI) transfers are printed as log lines every
+// LogInterval
+type Renderer struct {
+	mu sync.Mutex
+
+	out         io.Writer
+	tty         bool
+	bars        []*Bar
+	lines       int  // Lines of bars drawn last time
+	partial     bool // Other output left unfinished line, bars wait for its end
+	lastDraw    time.Time
+	lastLog     time.Time
+	RedrawDelay time.Duration
+	LogInterval time.Duration
+
+	now  func() time.Time
+	logf func(string, ...any)
+}
+
+type Bar struct {
+	r *Renderer
+
+	Action string // 'Downloading', 'Unpacking'
+	Name   string
+	Total  int64 // 0, if size is unknown
+	Done   int64
+	start  time.Time
+}
+
+// Renderer for stderr, used by phases. Stdout is left for output of raypm
+// and commands, other writes to the terminal should go through Wrap
+var Default = NewRenderer(os.Stderr)
+
+func NewRenderer(out io.Writer) *Renderer {
+	return &Renderer{
+		out:         out,
+		tty:         IsTerminal(out),
+		RedrawDelay: 100 * time.Millisecond,
+		LogInterval: 5 * time.Second,
+		now:         time.Now,
+		logf:        log.Info,
+	}
+}
+
+// Writer is a terminal, if it's a character device, like *os.File of tty or
+// writer returned by Wrap for it
+func IsTerminal(w io.Writer) bool {
+	f, ok := w.(interface{ Stat() (os.FileInfo, error) })
+	if !ok {
+		return false
+	}
+
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// 'total' is size in bytes, 0 or less if it's unknown (like HTTP response
+// without Content-Length)
+func (r *Renderer) Start(action, name string, total int64) (b *Bar) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b = &Bar{r: r, Action: action, Name: name, Total: max(total, 0), start: r.now()}
+	r.bars = append(r.bars, b)
+
+	if !r.tty {
+		r.logf("%s %s%s", action, name, sizeSuffix(b.Total))
+	}
+	r.draw(true)
+
+	return
+}
+
+func (b *Bar) Add(n int) {
+	r := b.r
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b.Done += int64(n)
+	r.draw(false)
+}
+
+// Removes the bar, result is printed instead of it
+func (b *Bar) Finish(err error) {
+	r := b.r
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, item := range r.bars {
+		if item == b {
+			r.bars = append(r.bars[:i], r.bars[i+1:]...)
+			break
+		}
+	}
+
+	elapsed := r.now().Sub(b.start)
+	result := fmt.Sprintf("%s %s: %s in %s", b.Action, b.Name, FormatBytes(b.Done), elapsed.Round(100*time.Millisecond))
+	if err != nil {
+		result = fmt.Sprintf("%s %s: failed after %s: %s", b.Action, b.Name, FormatBytes(b.Done), err)
+	}
+
+	if r.tty {
+		r.clear()
+		fmt.Fprintln(r.out, result)
+		r.lines = 0
+		r.draw(true)
+	} else {
+		r.logf("%s", result)
+	}
+}
+
+// Writer for other output to the terminal, like logs and output of commands:
+// bars are cleared before every write and drawn again after it
+func (r *Renderer) Wrap(w io.Writer) io.Writer {
+	return &pauseWriter{w, r}
+}
+
+type pauseWriter struct {
+	io.Writer
+	r *Renderer
+}
+
+func (pw *pauseWriter) Write(p []byte) (n int, err error) {
+	r := pw.r
+	// Without terminal nothing is drawn, and logf is called under the lock
+	if !r.tty {
+		return pw.Writer.Write(p)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.clear()
+	n, err = pw.Writer.Write(p)
+
+	r.partial = len(p) > 0 && p[len(p)-1] != '\n'
+	r.draw(true)
+	return
+}
+
+// Keeps IsTerminal working for the wrapped writer
+func (pw *pauseWriter) Stat() (os.FileInfo, error) {
+	if f, ok := pw.Writer.(interface{ Stat() (os.FileInfo, error) }); ok {
+		return f.Stat()
+	}
+	return nil, os.ErrInvalid
+}
+
+// Reader, that counts read bytes
+func (b *Bar) Reader(rd io.Reader) io.Reader {
+	return &barReader{rd, b}
+}
+
+// Writer, that counts written bytes
+func (b *Bar) Writer(w io.Writer) io.Writer {
+	return &barWriter{w, b}
+}
+
+type barReader struct {
+	io.Reader
+	b *Bar
+}
+
+func (br *barReader) Read(p []byte) (n int, err error) {
+	n, err = br.Reader.Read(p)
+	br.b.Add(n)
+	return
+}
+
+type barWriter struct {
+	io.Writer
+	b *Bar
+}
+
+func (bw *barWriter) Write(p []byte) (n int, err error) {
+	n, err = bw.Writer.Write(p)
+	bw.b.Add(n)
+	return
+}
+
+// Line like 'Downloading raylib.zip  45.0% 1.20 Mb/2.67 Mb  600.00 Kb/s  ETA 3s'
+func (b *Bar) String() string {
+	var w strings.Builder
+
+	elapsed := b.r.now().Sub(b.start).Seconds()
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(b.Done) / elapsed
+	}
+
+	fmt.Fprintf(&w, "%s %s ", b.Action, b.Name)
+
+	if b.Total > 0 {
+		fmt.Fprintf(&w, " %5.1f%% %s/%s", 100*float64(b.Done)/float64(b.Total), FormatBytes(b.Done), FormatBytes(b.Total))
+	} else {
+		fmt.Fprintf(&w, " %s", FormatBytes(b.Done))
+	}
+
+	fmt.Fprintf(&w, "  %s/s", FormatBytes(int64(rate)))
+
+	if b.Total > 0 && rate > 0 && b.Done < b.Total {
+		eta := time.Duration(float64(b.Total-b.Done) / rate * float64(time.Second))
+		fmt.Fprintf(&w, "  ETA %s", eta.Round(time.Second))
+	}
+
+	return w.String()
+}
+
+// Redraws bars on a terminal or prints them to log, not more often than
+// RedrawDelay or LogInterval, unless 'force' is set
+func (r *Renderer) draw(force bool) {
+	now := r.now()
+
+	if !r.tty {
+		if now.Sub(r.lastLog) < r.LogInterval {
+			return
+		}
+
+		if !r.lastLog.IsZero() {
+			for _, item := range r.bars {
+				r.logf("%s", item)
+			}
+		}
+		r.lastLog = now
+		return
+	}
+
+	if r.partial || !force && now.Sub(r.lastDraw) < r.RedrawDelay {
+		return
+	}
+	r.lastDraw = now
+
+	r.clear()
+	for _, item := range r.bars {
+		fmt.Fprintf(r.out, "%s%s\n", ClearLine, item)
+	}
+	r.lines = len(r.bars)
+}
+
+// Moves cursor to the first line of bars
+func (r *Renderer) clear() {
+	if r.lines > 0 {
+		fmt.Fprintf(r.out, "\033[%dA\r", r.lines)
+		fmt.Fprint(r.out, "\033[J")
+	}
+	r.lines = 0
+}
+
+func sizeSuffix(total int64) string {
+	if total <= 0 {
+		return ""
+	}
+	return " (" + FormatBytes(total) + ")"
+}
+
+func FormatBytes(n int64) string {
+	total, measure := getFormattedData(int(n))
+	return fmt.Sprintf("%.2f %s", total, measure)
+}
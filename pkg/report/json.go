@@ -0,0 +1,20 @@
+package report
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// Writes every event as a JSON object on its own line, for IDEs and CI
+type JSON struct {
+	enc *json.Encoder
+}
+
+func NewJSON(out io.Writer) *JSON {
+	return &JSON{enc: json.NewEncoder(out)}
+}
+
+func (j *JSON) Report(e Event) {
+	// Errors of output are ignored, there is nowhere to report them
+	j.enc.Encode(e)
+}
@@ -0,0 +1,102 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+type Kind string
+
+const (
+	PlanResolved     Kind = "plan_resolved"
+	FetchStarted     Kind = "fetch_started"
+	FetchProgress    Kind = "fetch_progress"
+	FetchFinished    Kind = "fetch_finished"
+	UnpackStarted    Kind = "unpack_started"
+	UnpackProgress   Kind = "unpack_progress"
+	UnpackFinished   Kind = "unpack_finished"
+	PhaseStarted     Kind = "phase_started"
+	PhaseFinished    Kind = "phase_finished"
+	PackageInstalled Kind = "package_installed"
+	Error            Kind = "error"
+	RunFinished      Kind = "run_finished"
+)
+
+// One step of installation. Only fields, that make sense for the kind, are
+// set: 'Packages' for the plan, 'Id', 'File', 'Done' and 'Total' for
+// transfers, 'Package' and 'Phase' for phases. 'Elapsed' is wall time in
+// seconds of finished transfers, phases and the whole run
+type Event struct {
+	Kind     Kind      `json:"event"`
+	Time     time.Time `json:"time"`
+	Packages []string  `json:"packages,omitempty"`
+	Package  string    `json:"package,omitempty"`
+	Phase    string    `json:"phase,omitempty"`
+	Id       int       `json:"id,omitempty"` // Transfers are running in parallel
+	File     string    `json:"file,omitempty"`
+	Url      string    `json:"url,omitempty"`
+	Done     int64     `json:"done,omitempty"`
+	Total    int64     `json:"total,omitempty"` // 0, if size is unknown
+	Elapsed  float64   `json:"elapsed,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Receives events of installation. Emit calls it under lock, so it doesn't
+// need own synchronization
+type Reporter interface {
+	Report(e Event)
+}
+
+var (
+	mu      sync.Mutex
+	current Reporter = NewTerminal()
+	ids     int
+)
+
+var Names = []string{"terminal", "json", "silent"}
+
+// Reporter by its name, JSON lines are written to 'out'
+func New(name string, out io.Writer) (r Reporter, err error) {
+	switch name {
+	case "", "terminal":
+		r = NewTerminal()
+	case "json":
+		r = NewJSON(out)
+	case "silent":
+		r = Silent{}
+	default:
+		err = fmt.Errorf("UnknownReporter: '%s', expect one of %v", name, Names)
+	}
+	return
+}
+
+// Replaces reporter of the process, returns the previous one
+func Set(r Reporter) (prev Reporter) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	prev, current = current, r
+	return
+}
+
+func Emit(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	current.Report(e)
+}
+
+// Shortcut for Error event
+func Fail(pkg, phase string, err error) {
+	Emit(Event{Kind: Error, Package: pkg, Phase: phase, Error: err.Error()})
+}
+
+type Silent struct{}
+
+func (Silent) Report(Event) {}
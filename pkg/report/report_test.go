@@ -0,0 +1,211 @@
+package report
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"raypm/pkg/progress"
+	log "raypm/pkg/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+type recorder struct {
+	events []Event
+}
+
+func (r *recorder) Report(e Event) {
+	r.events = append(r.events, e)
+}
+
+func TestNew(t *testing.T) {
+	for _, name := range append(Names, "") {
+		if _, err := New(name, io.Discard); err != nil {
+			t.Errorf("Reporter '%s': %s", name, err)
+		}
+	}
+
+	if _, err := New("xml", io.Discard); err == nil {
+		t.Error("Expect error for unknown reporter")
+	}
+}
+
+func TestJSON(t *testing.T) {
+	t.Run("one event per line", func(t *testing.T) {
+		var out strings.Builder
+		r := NewJSON(&out)
+
+		r.Report(Event{Kind: PlanResolved, Packages: []string{"raylib", "game"}})
+		r.Report(Event{Kind: PhaseStarted, Package: "raylib", Phase: "build"})
+		r.Report(Event{Kind: Error, Package: "raylib", Phase: "build", Error: "exit status 2"})
+
+		var got []map[string]any
+		scanner := bufio.NewScanner(strings.NewReader(out.String()))
+		for scanner.Scan() {
+			item := map[string]any{}
+			if err := json.Unmarshal(scanner.Bytes(), &item); err != nil {
+				t.Errorf("Wrong line '%s': %s", scanner.Text(), err)
+				t.FailNow()
+			}
+			got = append(got, item)
+		}
+
+		if len(got) != 3 {
+			t.Errorf("Expect 3 lines, got:\n%s", out.String())
+			t.FailNow()
+		}
+
+		if got[0]["event"] != "plan_resolved" || fmt.Sprint(got[0]["packages"]) != "[raylib game]" {
+			t.Errorf("Wrong plan: %v", got[0])
+		}
+
+		if got[1]["package"] != "raylib" || got[1]["phase"] != "build" {
+			t.Errorf("Wrong phase: %v", got[1])
+		}
+
+		// Empty fields are omitted
+		if _, ok := got[1]["error"]; ok {
+			t.Errorf("Unexpected error field: %v", got[1])
+		}
+
+		if got[2]["event"] != "error" || got[2]["error"] != "exit status 2" {
+			t.Errorf("Wrong error: %v", got[2])
+		}
+	})
+}
+
+func TestTransfer(t *testing.T) {
+	events := &recorder{}
+	defer Set(Set(events))
+
+	interval := ProgressInterval
+	ProgressInterval = time.Hour
+	defer func() { ProgressInterval = interval }()
+
+	t.Run("started, progress and finished", func(t *testing.T) {
+		events.events = nil
+
+		tr := StartFetch("http://localhost/raylib.zip", "raylib.zip", 1000)
+		if _, err := io.Copy(io.Discard, tr.Reader(strings.NewReader(strings.Repeat("x", 1000)))); err != nil {
+			t.Error(err)
+		}
+		tr.Finish(nil)
+
+		// Progress is skipped, it's more often than ProgressInterval
+		if len(events.events) != 2 {
+			t.Errorf("Expect 2 events, got %#v", events.events)
+			t.FailNow()
+		}
+
+		started, finished := events.events[0], events.events[1]
+		if started.Kind != FetchStarted || started.Total != 1000 || started.File != "raylib.zip" {
+			t.Errorf("Wrong start: %#v", started)
+		}
+
+		if finished.Kind != FetchFinished || finished.Done != 1000 || finished.Id != started.Id || finished.Error != "" {
+			t.Errorf("Wrong finish: %#v", finished)
+		}
+	})
+
+	t.Run("progress", func(t *testing.T) {
+		events.events = nil
+		ProgressInterval = 0
+
+		tr := StartUnpack("raylib.tar.gz", -1)
+		tr.Add(10)
+		tr.Add(20)
+		tr.Finish(errors.New("broken"))
+
+		var kinds []Kind
+		for _, item := range events.events {
+			kinds = append(kinds, item.Kind)
+		}
+
+		want := []Kind{UnpackStarted, UnpackProgress, UnpackProgress, UnpackFinished}
+		if fmt.Sprint(kinds) != fmt.Sprint(want) {
+			t.Errorf("Expect %v, got %v", want, kinds)
+			t.FailNow()
+		}
+
+		if events.events[0].Total != 0 || events.events[2].Done != 30 || events.events[3].Error != "broken" {
+			t.Errorf("Wrong events: %#v", events.events)
+		}
+	})
+
+	t.Run("transfers have different ids", func(t *testing.T) {
+		a, b := StartFetch("a", "a", 0), StartFetch("b", "b", 0)
+		if a.event.Id == b.event.Id {
+			t.Errorf("Both ids are %d", a.event.Id)
+		}
+	})
+}
+
+func TestTerminal(t *testing.T) {
+	var out strings.Builder
+	log.Setup(log.Config{Out: &out, Color: "never"})
+	defer log.Init(false)
+
+	term := NewTerminal()
+	term.r = progress.NewRenderer(io.Discard)
+
+	term.Report(Event{Kind: PlanResolved, Packages: []string{"raylib", "game"}})
+	term.Report(Event{Kind: PhaseStarted, Package: "game", Phase: "build"})
+	term.Report(Event{Kind: FetchStarted, Id: 1, File: "raylib.zip", Total: 100})
+	term.Report(Event{Kind: FetchProgress, Id: 1, Done: 40})
+	if bar := term.bars[1]; bar == nil || bar.Done != 40 {
+		t.Errorf("Expect bar at 40 bytes, got %v", bar)
+	}
+	term.Report(Event{Kind: FetchFinished, Id: 1, Done: 100})
+	term.Report(Event{Kind: PackageInstalled, Package: "raylib"})
+	term.Report(Event{Kind: Error, Package: "game", Phase: "build", Error: "exit status 2"})
+
+	if len(term.bars) != 0 {
+		t.Errorf("Finished bars are left: %v", term.bars)
+	}
+
+	want := strings.Join([]string{
+		"[INFO] Installing 2 packages: raylib, game",
+		"[INFO] Running build phase of 'game'",
+		"[INFO] Downloading raylib.zip (100.00 Bytes)", // Not a terminal
+		"[INFO] Downloading raylib.zip: 100.00 Bytes in 0s",
+		"[INFO] Package 'raylib' installed",
+		"[ERROR] build phase of 'game' failed: exit status 2",
+		"",
+	}, "\n")
+	if out.String() != want {
+		t.Errorf("Expect:\n%s\ngot:\n%s", want, out.String())
+	}
+}
+
+func TestSummary(t *testing.T) {
+	var out strings.Builder
+	log.Setup(log.Config{Out: &out, Color: "never"})
+	defer log.Init(false)
+
+	term := NewTerminal()
+	term.Report(Event{Kind: PhaseFinished, Package: "raylib", Phase: "fetch", Elapsed: 1})
+	term.Report(Event{Kind: PhaseFinished, Package: "zlib", Phase: "build", Elapsed: 0.5})
+	term.Report(Event{Kind: PhaseFinished, Package: "raylib", Phase: "build", Elapsed: 2.5})
+	term.Report(Event{Kind: RunFinished, Elapsed: 3.14})
+
+	// The slowest package goes first
+	want := strings.Join([]string{
+		"[INFO] Finished in 3.1s",
+		"[INFO]   raylib    3.5s  (fetch 1.0s, build 2.5s)",
+		"[INFO]   zlib      0.5s  (build 0.5s)",
+		"",
+	}, "\n")
+	if out.String() != want {
+		t.Errorf("Expect:\n%s\ngot:\n%s", want, out.String())
+	}
+
+	// Timings are reset for the next run
+	out.Reset()
+	term.Report(Event{Kind: RunFinished})
+	if out.String() != "" {
+		t.Errorf("Unexpected summary:\n%s", out.String())
+	}
+}
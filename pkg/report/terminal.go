@@ -0,0 +1,136 @@
+package report
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"raypm/pkg/progress"
+	log "raypm/pkg/slog"
+	"slices"
+	"strings"
+)
+
+// Human-readable reporter: messages go to log, transfers are drawn as
+// progress bars
+type Terminal struct {
+	r    *progress.Renderer
+	bars map[int]*progress.Bar
+
+	timings []timing // Phases of the run, for the summary
+}
+
+type timing struct {
+	pkg     string
+	phase   string
+	elapsed float64
+}
+
+func NewTerminal() *Terminal {
+	return &Terminal{
+		r:    progress.Default,
+		bars: map[int]*progress.Bar{},
+	}
+}
+
+func (t *Terminal) Report(e Event) {
+	l := fields(e)
+
+	switch e.Kind {
+	case PlanResolved:
+		if len(e.Packages) > 0 {
+			l.Info("Installing %d packages: %s", len(e.Packages), strings.Join(e.Packages, ", "))
+		}
+	case FetchStarted:
+		t.bars[e.Id] = t.r.Start("Downloading", e.File, e.Total)
+	case UnpackStarted:
+		t.bars[e.Id] = t.r.Start("Unpacking", e.File, e.Total)
+	case FetchProgress, UnpackProgress:
+		t.progress(e)
+	case FetchFinished, UnpackFinished:
+		if bar := t.progress(e); bar != nil {
+			var err error
+			if e.Error != "" {
+				err = errors.New(e.Error)
+			}
+			bar.Finish(err)
+			delete(t.bars, e.Id)
+		}
+	case PhaseStarted:
+		l.Info("Running %s phase of '%s'", e.Phase, e.Package)
+	case PhaseFinished:
+		t.timings = append(t.timings, timing{e.Package, e.Phase, e.Elapsed})
+		l.Debug("%s phase of '%s' finished in %.1fs", e.Phase, e.Package, e.Elapsed)
+	case PackageInstalled:
+		l.Info("Package '%s' installed", e.Package)
+	case Error:
+		switch {
+		case e.Phase != "":
+			l.Error("%s phase of '%s' failed: %s", e.Phase, e.Package, e.Error)
+		case e.Package != "":
+			l.Error("'%s' failed: %s", e.Package, e.Error)
+		default:
+			l.Error("%s", e.Error)
+		}
+	case RunFinished:
+		t.summary(e)
+		t.timings = nil
+	}
+}
+
+// Time of every package and its phases, the slowest packages go first.
+// Packages are built in parallel, so the sum could be more than time of
+// the run
+func (t *Terminal) summary(e Event) {
+	if len(t.timings) == 0 {
+		return
+	}
+
+	var (
+		order  []string
+		totals = map[string]float64{}
+		phases = map[string][]string{}
+	)
+
+	for _, item := range t.timings {
+		if _, ok := totals[item.pkg]; !ok {
+			order = append(order, item.pkg)
+		}
+		totals[item.pkg] += item.elapsed
+		phases[item.pkg] = append(phases[item.pkg], fmt.Sprintf("%s %.1fs", item.phase, item.elapsed))
+	}
+
+	slices.SortStableFunc(order, func(a, b string) int {
+		return cmp.Compare(totals[b], totals[a])
+	})
+
+	width := 0
+	for _, name := range order {
+		width = max(width, len(name))
+	}
+
+	log.Info("Finished in %.1fs", e.Elapsed)
+	for _, name := range order {
+		log.Info("  %-*s %6.1fs  (%s)", width, name, totals[name], strings.Join(phases[name], ", "))
+	}
+}
+
+// Moves bar of the transfer to e.Done
+func (t *Terminal) progress(e Event) *progress.Bar {
+	bar, ok := t.bars[e.Id]
+	if ok && e.Done > bar.Done {
+		bar.Add(int(e.Done - bar.Done))
+	}
+	return bar
+}
+
+// Package and phase of the event are fields of log messages
+func fields(e Event) *log.Logger {
+	var args []any
+	if e.Package != "" {
+		args = append(args, "package", e.Package)
+	}
+	if e.Phase != "" {
+		args = append(args, "phase", e.Phase)
+	}
+	return log.With(args...)
+}
@@ -0,0 +1,108 @@
+package report
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Progress events are not emitted more often, than this
+var ProgressInterval = 100 * time.Millisecond
+
+// Download or unpack, that reports its started, progress and finished events
+type Transfer struct {
+	mu sync.Mutex
+
+	progress Kind
+	finished Kind
+	event    Event
+	start    time.Time
+	last     time.Time
+}
+
+// 'total' is size in bytes, 0 or less if it's unknown (like HTTP response
+// without Content-Length)
+func StartFetch(url, file string, total int64) *Transfer {
+	return start(Event{Kind: FetchStarted, Url: url, File: file, Total: total}, FetchProgress, FetchFinished)
+}
+
+func StartUnpack(file string, total int64) *Transfer {
+	return start(Event{Kind: UnpackStarted, File: file, Total: total}, UnpackProgress, UnpackFinished)
+}
+
+func start(e Event, progress, finished Kind) *Transfer {
+	mu.Lock()
+	ids++
+	e.Id = ids
+	mu.Unlock()
+
+	e.Total = max(e.Total, 0)
+	e.Time = time.Now()
+
+	t := &Transfer{progress: progress, finished: finished, event: e, start: e.Time, last: e.Time}
+	Emit(e)
+	return t
+}
+
+func (t *Transfer) Add(n int) {
+	t.mu.Lock()
+	t.event.Done += int64(n)
+	now := time.Now()
+	if now.Sub(t.last) < ProgressInterval {
+		t.mu.Unlock()
+		return
+	}
+	t.last = now
+
+	e := t.event
+	e.Kind, e.Time = t.progress, now
+	t.mu.Unlock()
+
+	Emit(e)
+}
+
+func (t *Transfer) Finish(err error) {
+	t.mu.Lock()
+	e := t.event
+	t.mu.Unlock()
+
+	e.Kind, e.Time = t.finished, time.Now()
+	e.Elapsed = e.Time.Sub(t.start).Seconds()
+	if err != nil {
+		e.Error = err.Error()
+	}
+
+	Emit(e)
+}
+
+// Reader, that counts read bytes
+func (t *Transfer) Reader(rd io.Reader) io.Reader {
+	return &transferReader{rd, t}
+}
+
+// Writer, that counts written bytes
+func (t *Transfer) Writer(w io.Writer) io.Writer {
+	return &transferWriter{w, t}
+}
+
+type transferReader struct {
+	io.Reader
+	t *Transfer
+}
+
+func (tr *transferReader) Read(p []byte) (n int, err error) {
+	n, err = tr.Reader.Read(p)
+	tr.t.Add(n)
+	return
+}
+
+type transferWriter struct {
+	io.Writer
+	t *Transfer
+}
+
+func (tw *transferWriter) Write(p []byte) (n int, err error) {
+	n, err = tw.Writer.Write(p)
+	tw.t.Add(n)
+	return
+}
@@ -0,0 +1,90 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+)
+
+// Human-readable handler: '[INFO] message'. Fields are printed only with
+// debug messages enabled, they repeat the message usually
+type consoleHandler struct {
+	mu     *sync.Mutex
+	out    io.Writer
+	level  slog.Level
+	fields bool
+	attrs  []slog.Attr
+
+	prefixes map[slog.Level]string
+}
+
+func newConsole(out io.Writer, level slog.Level, colored, fields bool) *consoleHandler {
+	prefix := func(name string, attr color.Attribute) string {
+		c := color.New(attr)
+		if colored {
+			c.EnableColor()
+		} else {
+			c.DisableColor()
+		}
+		return c.Sprint("[" + name + "]")
+	}
+
+	return &consoleHandler{
+		mu:     &sync.Mutex{},
+		out:    out,
+		level:  level,
+		fields: fields,
+		prefixes: map[slog.Level]string{
+			slog.LevelDebug: prefix("DEBUG", color.FgWhite),
+			slog.LevelInfo:  prefix("INFO", color.FgHiBlue),
+			slog.LevelWarn:  prefix("WARN", color.FgYellow),
+			slog.LevelError: prefix("ERROR", color.FgRed),
+			LevelFatal:      prefix("FATAL", color.FgHiRed),
+		},
+	}
+}
+
+func (h *consoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *consoleHandler) Handle(_ context.Context, r slog.Record) error {
+	var line strings.Builder
+
+	line.WriteString(h.prefixes[r.Level])
+	line.WriteString(" ")
+	line.WriteString(r.Message)
+
+	if h.fields {
+		for _, a := range h.attrs {
+			fmt.Fprintf(&line, " %s", a)
+		}
+		r.Attrs(func(a slog.Attr) bool {
+			fmt.Fprintf(&line, " %s", a)
+			return true
+		})
+	}
+	line.WriteString("\n")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	_, err := io.WriteString(h.out, line.String())
+	return err
+}
+
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = append(append([]slog.Attr{}, h.attrs...), attrs...)
+	return &h2
+}
+
+// Groups are not used by raypm
+func (h *consoleHandler) WithGroup(string) slog.Handler {
+	return h
+}
@@ -0,0 +1,78 @@
+package log
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Older log files are removed
+var KeepFiles = 20
+
+// Starts debug log of the run in 'dir': every message with its fields goes
+// there regardless of the level and format of the console, for post-mortems.
+// Returns path of the file
+func OpenFile(dir string) (pth string, err error) {
+	if err = os.MkdirAll(dir, 0754); err != nil {
+		return
+	}
+
+	name := fmt.Sprintf("raypm-%s-%d.log", time.Now().Format("20060102-150405"), os.Getpid())
+	pth = filepath.Join(dir, name)
+
+	f, err := os.OpenFile(pth, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return
+	}
+
+	h := slog.NewTextHandler(f, &slog.HandlerOptions{Level: slog.LevelDebug, ReplaceAttr: replaceLevel})
+
+	mu.Lock()
+	prev := logFile
+	file, logFile = h, f
+	mu.Unlock()
+
+	if prev != nil {
+		prev.Close()
+	}
+
+	prune(dir, KeepFiles)
+	return
+}
+
+// Stops writing to the log file
+func Close() {
+	mu.Lock()
+	f := logFile
+	file, logFile = nil, nil
+	mu.Unlock()
+
+	if f != nil {
+		f.Close()
+	}
+}
+
+// Removes the oldest logs, names are sorted by time
+func prune(dir string, keep int) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	var logs []string
+	for _, item := range entries {
+		if name := item.Name(); strings.HasPrefix(name, "raypm-") && strings.HasSuffix(name, ".log") {
+			logs = append(logs, name)
+		}
+	}
+	slices.Sort(logs)
+
+	for len(logs) > keep {
+		os.Remove(filepath.Join(dir, logs[0]))
+		logs = logs[1:]
+	}
+}
@@ -1,88 +1,185 @@
 package log
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/fatih/color"
 )
 
+// Fatal messages stop the program
+const LevelFatal = slog.LevelError + 4
+
+type Config struct {
+	Debug  bool      // Print debug messages, the log file has them anyway
+	Format string    // 'text' or 'json'
+	Color  string    // 'auto', 'always' or 'never', 'auto' respects NO_COLOR
+	Out    io.Writer // Stderr, if nil
+}
+
 var (
-	debugFlag   bool   = false
-	debugPrefix string = color.WhiteString("[DEBUG]")
-	infoPrefix  string = color.HiBlueString("[INFO]")
-	warnPrefix  string = color.YellowString("[WARN]")
-	errorPrefix string = color.RedString("[ERROR]")
-	fatalPrefix string = color.HiRedString("[FATAL]")
+	Formats = []string{"text", "json"}
+	Colors  = []string{"auto", "always", "never"}
 )
 
-func Init(dbg bool) {
-	log.SetFlags(0)
-	log.SetPrefix("")
+var (
+	mu      sync.Mutex
+	console slog.Handler = newConsole(os.Stderr, slog.LevelInfo, false, false)
+	file    slog.Handler // Debug log of the run, see OpenFile
+	logFile *os.File
 
-	debugFlag = dbg
-}
+	std = &Logger{}
+)
 
-func logPrintf(prefix, fmtString string, params ...any) {
-	strItself := fmt.Sprintf(fmtString, params...)
-	line := fmt.Sprintf("%s %s", prefix, strItself)
+// Logger with fields like 'package', 'phase' and 'target', that are added to
+// every message
+type Logger struct {
+	attrs []slog.Attr
+}
 
-	log.Printf("%s\n", line)
+func Init(dbg bool) {
+	Setup(Config{Debug: dbg})
 }
 
-func logPrintln(prefix string, params ...any) {
-	var strItself string = prefix
+func Setup(cfg Config) (err error) {
+	out := cfg.Out
+	if out == nil {
+		out = os.Stderr
+	}
 
-	for _, item := range params {
-		strItself += fmt.Sprintf(" %v", item)
+	level := slog.LevelInfo
+	if cfg.Debug {
+		level = slog.LevelDebug
 	}
 
-	log.Println(strItself)
+	var colored bool
+	switch cfg.Color {
+	case "", "auto":
+		colored = os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb" && isTerminal(out)
+	case "always":
+		colored = true
+	case "never":
+	default:
+		return fmt.Errorf("WrongColor: '%s', expect one of %v", cfg.Color, Colors)
+	}
+	// Other output, like list of packages, is colored by fatih/color too
+	color.NoColor = !colored
+
+	var h slog.Handler
+	switch cfg.Format {
+	case "", "text":
+		h = newConsole(out, level, colored, cfg.Debug)
+	case "json":
+		h = slog.NewJSONHandler(out, &slog.HandlerOptions{Level: level, ReplaceAttr: replaceLevel})
+	default:
+		return fmt.Errorf("WrongLogFormat: '%s', expect one of %v", cfg.Format, Formats)
+	}
+
+	mu.Lock()
+	console = h
+	mu.Unlock()
+
+	return
 }
 
-func Debug(message string, params ...any) {
-	if debugFlag {
-		logPrintf(debugPrefix, message, params...)
+// Like progress.IsTerminal, works for writers that wrap *os.File too
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(interface{ Stat() (os.FileInfo, error) })
+	if !ok {
+		return false
 	}
+
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
 }
 
-func Debugln(params ...any) {
-	if debugFlag {
-		logPrintln(debugPrefix, params...)
+// Names LevelFatal as 'FATAL' instead of 'ERROR+4'
+func replaceLevel(groups []string, a slog.Attr) slog.Attr {
+	if a.Key == slog.LevelKey && len(groups) == 0 {
+		if level, ok := a.Value.Any().(slog.Level); ok && level == LevelFatal {
+			a.Value = slog.StringValue("FATAL")
+		}
 	}
+	return a
 }
 
-func Info(message string, params ...any) {
-	logPrintf(infoPrefix, message, params...)
+// Logger with additional fields: key/value pairs, like slog.Logger.With
+func With(args ...any) *Logger {
+	return std.With(args...)
 }
 
-func Infoln(params ...any) {
-	logPrintln(infoPrefix, params...)
-}
+func (l *Logger) With(args ...any) *Logger {
+	r := slog.Record{}
+	r.Add(args...)
 
-func Warn(message string, params ...any) {
-	logPrintf(warnPrefix, message, params...)
+	attrs := append([]slog.Attr{}, l.attrs...)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+
+	return &Logger{attrs: attrs}
 }
 
-func Warnln(params ...any) {
-	logPrintln(warnPrefix, params...)
+func (l *Logger) log(level slog.Level, msg string) {
+	mu.Lock()
+	handlers := []slog.Handler{console, file}
+	mu.Unlock()
+
+	ctx := context.Background()
+	r := slog.NewRecord(time.Now(), level, strings.TrimRight(msg, "\n"), 0)
+	r.AddAttrs(l.attrs...)
+
+	for _, h := range handlers {
+		if h != nil && h.Enabled(ctx, level) {
+			h.Handle(ctx, r.Clone())
+		}
+	}
 }
 
-func Error(message string, params ...any) {
-	logPrintf(errorPrefix, message, params...)
+func (l *Logger) logf(level slog.Level, message string, params ...any) {
+	l.log(level, fmt.Sprintf(message, params...))
 }
 
-func Errorln(params ...any) {
-	logPrintln(debugPrefix, params...)
+// Like fmt.Sprintln, but without the newline
+func (l *Logger) logln(level slog.Level, params ...any) {
+	l.log(level, fmt.Sprintln(params...))
 }
 
-func Fatal(message string, params ...any) {
-	logPrintf(fatalPrefix, message, params...)
+func (l *Logger) Debug(message string, params ...any) { l.logf(slog.LevelDebug, message, params...) }
+func (l *Logger) Debugln(params ...any)               { l.logln(slog.LevelDebug, params...) }
+func (l *Logger) Info(message string, params ...any)  { l.logf(slog.LevelInfo, message, params...) }
+func (l *Logger) Infoln(params ...any)                { l.logln(slog.LevelInfo, params...) }
+func (l *Logger) Warn(message string, params ...any)  { l.logf(slog.LevelWarn, message, params...) }
+func (l *Logger) Warnln(params ...any)                { l.logln(slog.LevelWarn, params...) }
+func (l *Logger) Error(message string, params ...any) { l.logf(slog.LevelError, message, params...) }
+func (l *Logger) Errorln(params ...any)               { l.logln(slog.LevelError, params...) }
+
+func (l *Logger) Fatal(message string, params ...any) {
+	l.logf(LevelFatal, message, params...)
+	Close()
 	os.Exit(1)
 }
 
-func Fatalln(params ...any) {
-	logPrintln(fatalPrefix, params...)
+func (l *Logger) Fatalln(params ...any) {
+	l.logln(LevelFatal, params...)
+	Close()
 	os.Exit(1)
 }
+
+func Debug(message string, params ...any) { std.logf(slog.LevelDebug, message, params...) }
+func Debugln(params ...any)               { std.logln(slog.LevelDebug, params...) }
+func Info(message string, params ...any)  { std.logf(slog.LevelInfo, message, params...) }
+func Infoln(params ...any)                { std.logln(slog.LevelInfo, params...) }
+func Warn(message string, params ...any)  { std.logf(slog.LevelWarn, message, params...) }
+func Warnln(params ...any)                { std.logln(slog.LevelWarn, params...) }
+func Error(message string, params ...any) { std.logf(slog.LevelError, message, params...) }
+func Errorln(params ...any)               { std.logln(slog.LevelError, params...) }
+func Fatal(message string, params ...any) { std.Fatal(message, params...) }
+func Fatalln(params ...any)               { std.Fatalln(params...) }
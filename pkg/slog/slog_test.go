@@ -0,0 +1,166 @@
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConsole(t *testing.T) {
+	defer Init(false)
+
+	t.Run("levels and prefixes", func(t *testing.T) {
+		var out strings.Builder
+		Setup(Config{Out: &out, Color: "never"})
+
+		Debug("hidden")
+		Info("Installing '%s'\n", "raylib")
+		Warnln("Package", "is", "old")
+		Errorln("Failed:", "exit status 1")
+
+		want := "[INFO] Installing 'raylib'\n[WARN] Package is old\n[ERROR] Failed: exit status 1\n"
+		if out.String() != want {
+			t.Errorf("Expect:\n%s\ngot:\n%s", want, out.String())
+		}
+	})
+
+	t.Run("fields are printed with debug", func(t *testing.T) {
+		var out strings.Builder
+		l := With("package", "raylib").With("phase", "build")
+
+		Setup(Config{Out: &out, Color: "never"})
+		l.Info("Running")
+
+		Setup(Config{Out: &out, Color: "never", Debug: true})
+		l.Debug("Running")
+
+		want := "[INFO] Running\n[DEBUG] Running package=raylib phase=build\n"
+		if out.String() != want {
+			t.Errorf("Expect:\n%s\ngot:\n%s", want, out.String())
+		}
+	})
+
+	t.Run("colors", func(t *testing.T) {
+		var out strings.Builder
+
+		Setup(Config{Out: &out, Color: "always"})
+		Error("colored")
+		if !strings.Contains(out.String(), "\033[") {
+			t.Errorf("Expect colored prefix: %q", out.String())
+		}
+
+		// Not a terminal
+		out.Reset()
+		Setup(Config{Out: &out})
+		Error("plain")
+		if out.String() != "[ERROR] plain\n" {
+			t.Errorf("Expect plain prefix: %q", out.String())
+		}
+
+		if err := Setup(Config{Out: &out, Color: "sometimes"}); err == nil {
+			t.Error("Expect error for wrong color")
+		}
+	})
+}
+
+func TestJSON(t *testing.T) {
+	defer Init(false)
+
+	var out strings.Builder
+	Setup(Config{Out: &out, Format: "json"})
+
+	With("package", "raylib", "target", "linux/amd64/gnu").Warn("Package '%s' is old", "raylib")
+
+	got := map[string]any{}
+	if err := json.Unmarshal([]byte(out.String()), &got); err != nil {
+		t.Errorf("Wrong JSON '%s': %s", out.String(), err)
+		t.FailNow()
+	}
+
+	want := map[string]any{
+		"level":   "WARN",
+		"msg":     "Package 'raylib' is old",
+		"package": "raylib",
+		"target":  "linux/amd64/gnu",
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("Expect %s=%v, got %v", key, value, got[key])
+		}
+	}
+
+	if err := Setup(Config{Format: "xml"}); err == nil {
+		t.Error("Expect error for wrong format")
+	}
+}
+
+func TestFile(t *testing.T) {
+	defer Init(false)
+
+	dir, err := os.MkdirTemp(os.TempDir(), "log_test_*")
+	if err != nil {
+		t.Errorf("Cannot create temp directory: %s\n", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	t.Run("debug messages with fields", func(t *testing.T) {
+		var out strings.Builder
+		Setup(Config{Out: &out, Color: "never"})
+
+		pth, err := OpenFile(dir)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		With("package", "raylib").Debug("Fetching")
+		Info("Done")
+		Close()
+		Info("After close")
+
+		data, err := os.ReadFile(pth)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		for _, item := range []string{`level=DEBUG msg=Fetching package=raylib`, `level=INFO msg=Done`} {
+			if !strings.Contains(string(data), item) {
+				t.Errorf("Expect '%s' in log:\n%s", item, data)
+			}
+		}
+
+		if strings.Contains(string(data), "After close") {
+			t.Errorf("Message after Close:\n%s", data)
+		}
+
+		if out.String() != "[INFO] Done\n[INFO] After close\n" {
+			t.Errorf("Wrong console: %q", out.String())
+		}
+	})
+
+	t.Run("old files are removed", func(t *testing.T) {
+		for i := range 5 {
+			name := fmt.Sprintf("raypm-20240101-00000%d-1.log", i)
+			if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+				t.Error(err)
+			}
+		}
+
+		prune(dir, 3)
+
+		logs, _ := filepath.Glob(filepath.Join(dir, "raypm-*.log"))
+		if len(logs) != 3 {
+			t.Errorf("Expect 3 files, got %v", logs)
+		}
+
+		// The current run's log is the newest one
+		if len(logs) > 0 && strings.HasPrefix(filepath.Base(logs[len(logs)-1]), "raypm-2024") {
+			t.Errorf("The newest log is removed: %v", logs)
+		}
+	})
+}
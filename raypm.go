@@ -1,89 +1,119 @@
 package main
 
 import (
-	"flag"
+	"errors"
 	"fmt"
+	"maps"
 	"os"
+	"os/exec"
 	"path"
+	"path/filepath"
 	"raypm/internal/app"
 	"raypm/internal/dbpkg"
 	"raypm/internal/deptree"
-	"raypm/internal/fetch"
-	"raypm/internal/pkginfo"
-	"raypm/internal/unpack"
+	"raypm/internal/env"
+	"raypm/internal/errs"
+	"raypm/internal/generations"
+	"raypm/internal/lint"
+	"raypm/internal/phases"
+	"raypm/internal/pkgconfig"
+	"raypm/internal/pkglua"
+	"raypm/internal/task"
+	"raypm/internal/web"
+	"raypm/pkg/progress"
+	"raypm/pkg/report"
 	log "raypm/pkg/slog"
 	"runtime"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/fatih/color"
 )
 
 func main() {
 	var (
-		ProgramTask     app.Operation = 0
+		ProgramTask     app.Operation
 		SelectedPackage string
 		settings        *app.Settings
 
 		err error
-
-		// Flags
-
-		operations int = 0
 	)
 
-	opts, err := app.NewOptions()
-	_ = opts
-	// Handle errors
+	// Error is printed once, if it isn't shown already (like failed phase),
+	// and turned into exit code, see errs.ExitCode
+	defer func() {
+		if err != nil && !errs.IsReported(err) {
+			log.Error("%s", err)
+		}
+		log.Close()
 
-	log.Init(opts.Debug)
+		if err != nil {
+			os.Exit(errs.ExitCode(err))
+		}
+	}()
 
-	if listPkgs {
-		ProgramTask = ListPackages
-		operations++
+	opts, err := app.NewOptions()
+	if err != nil {
+		return
 	}
 
-	if fetchPkgInfo != "" {
-		ProgramTask = FetchPkgInfo
-		SelectedPackage = fetchPkgInfo
-		operations++
+	// Messages are written to the same terminal as progress bars
+	logCfg := log.Config{Debug: opts.Debug, Format: opts.LogFormat, Color: opts.Color, Out: progress.Default.Wrap(os.Stderr)}
+	if err = log.Setup(logCfg); err != nil {
+		err = errs.Wrap(errs.Usage, "WrongFlag", err, "")
+		return
 	}
 
-	if installPkg != "" {
-		ProgramTask = InstallPkg
-		SelectedPackage = installPkg
-		operations++
+	reporter, err := report.New(opts.Report, os.Stdout)
+	if err != nil {
+		err = errs.Wrap(errs.Usage, "WrongFlag", err, "")
+		return
 	}
+	report.Set(reporter)
 
-	if syncPkgs {
-		ProgramTask = SyncPkgs
-		operations++
-	}
+	// Output of commands is always in logs of phases, it's shown on a
+	// terminal, when it doesn't mix with other reports
+	task.Echo = (task.Echo || opts.Debug) && opts.Report == "terminal"
 
-	if removePkg != "" {
-		ProgramTask = RemovePkg
-		SelectedPackage = removePkg
-		operations++
+	// In 'json' mode stdout carries events, and in 'silent' mode nothing,
+	// output of commands, that is echoed anyway, like without log of phase
+	// or from package managers, goes to stderr
+	if opts.Report != "terminal" {
+		task.Stdout = task.Stderr
 	}
 
-	if cleanStorage != "" {
-		ProgramTask = Clean
-		operations++
+	if ProgramTask, SelectedPackage, err = opts.SetProgramTask(); err != nil {
+		return
 	}
 
-	if buildPackage {
-		ProgramTask = BuildPkg
-		operations++
-	}
+	var query *pkgconfig.Query
+	if ProgramTask == app.PkgConfig {
+		if query, err = pkgconfig.ParseArgs(opts.Args); err != nil {
+			return
+		}
 
-	if operations > 1 {
-		log.Fatalln("Choose only one operation. Type 'raypm -h' to see them")
+		opts.PackageTarget = query.Target
+		if opts.PackageTarget == "" {
+			opts.PackageTarget = os.Getenv("RAYPM_TARGET")
+		}
 	}
 
-	if buildPackage {
-		settings, err = app.InitApp(".raypm", packageTarget)
+	if opts.BuildPackage {
+		// Commands of phases run in other directories, so the path must be absolute
+		var localRaypm string
+		if localRaypm, err = filepath.Abs(".raypm"); err != nil {
+			return
+		}
+
+		if err = os.MkdirAll(localRaypm, 0754); err != nil {
+			return
+		}
+
+		settings, err = app.InitApp(opts, localRaypm, opts.PackageTarget)
 	} else {
 		var tmpStr string
 		if tmpStr, err = os.UserHomeDir(); err != nil {
-			log.Errorln(err)
 			return
 		}
 
@@ -93,16 +123,25 @@ func main() {
 			tmpStr = path.Join(tmpStr, ".raypm")
 		}
 
-		settings, err = app.InitApp(tmpStr, packageTarget)
+		settings, err = app.InitApp(opts, tmpStr, opts.PackageTarget)
 	}
 
 	if err != nil {
-		log.Errorln(err)
 		return
 	}
 
+	// pkg-config is called by builds many times, its runs are not logged
+	if ProgramTask != app.PkgConfig {
+		if logPath, err := settings.OpenLog(); err != nil {
+			log.Warn("Failed to open log file: %s", err)
+		} else {
+			log.Debug("Log file: %s", logPath)
+			log.With("args", os.Args[1:], "target", settings.Build.Target).Debug("Running raypm")
+		}
+	}
+
 	switch ProgramTask {
-	case SyncPkgs:
+	case app.SyncPkgs:
 		settings.EnableAccess()
 		defer settings.DisableAccess()
 
@@ -115,7 +154,6 @@ func main() {
 		log.Debugln("Creating .raypm directory")
 		if _, err = os.Stat(settings.PathToPkgs); err != nil {
 			if err = os.MkdirAll(settings.PathToPkgs, 0754); err != nil {
-				log.Error("Failed to create '%s': %s", settings.PathToPkgs, err)
 				return
 			}
 			log.Debugln("Directory created")
@@ -123,8 +161,8 @@ func main() {
 			log.Debugln("Directory already exists")
 		}
 
-		if pathToArchive, version, err = fetch.Sync(settings.RaypmPath); err != nil {
-			log.Errorln("Failed to sync:", err)
+		if pathToArchive, version, err = phases.Sync(settings.RaypmPath); err != nil {
+			err = errs.Wrap(errs.Fetch, "SyncFailed", err, "")
 			return
 		}
 
@@ -134,44 +172,40 @@ func main() {
 		}
 
 		log.Infoln("Unpacking sources")
-		if err = unpack.Unpack("zip", pathToArchive, settings.RaypmPath, nil); err != nil {
-			log.Errorln("Failed to unpack", err)
+		if err = phases.Unpack("zip", pathToArchive, settings.RaypmPath, nil); err != nil {
 			return
 		}
 
 		fInfoPath := path.Join(settings.PathToPkgs, "info.txt")
-		fInfo, err := os.Create(fInfoPath)
-		if err != nil {
-			log.Error("Failed to create info file '%s': '%s'", fInfoPath, err)
+		var fInfo *os.File
+		if fInfo, err = os.Create(fInfoPath); err != nil {
 			return
 		}
 		defer fInfo.Close()
 
 		if _, err = fInfo.WriteString(version); err != nil {
-			log.Errorln("Failed to write a date:", err)
 			return
 		}
 
 		log.Infoln("Package's database is up to date now")
-	case Clean:
+	case app.Clean:
 		settings.EnableAccess()
 		defer settings.DisableAccess()
 
 		dirToDel := settings.RaypmPath
 
-		switch cleanStorage {
+		switch opts.CleanStorage {
 		case "all":
 		case "cache":
 			dirToDel = path.Join(dirToDel, "cache")
 		default:
-			log.Error("Undefined option '%s', run 'raypm -h' for more info",
-				cleanStorage)
+			err = errs.New(errs.Usage, "UndefinedOption", "'%s', run 'raypm -h' for more info",
+				opts.CleanStorage)
 			return
 		}
 
 		if _, err = os.Stat(dirToDel); err == nil {
 			if err = os.RemoveAll(dirToDel); err != nil {
-				log.Error("Failed to remove '%s': %s", dirToDel, err)
 				return
 			}
 			log.Info("Deleted directory '%s'", dirToDel)
@@ -179,7 +213,7 @@ func main() {
 			log.Infoln("Directory already deleted")
 		}
 
-	case InstallPkg, RemovePkg:
+	case app.InstallPkg, app.RemovePkg, app.BuildPkg, app.Rollback, app.CollectGarbage:
 		settings.EnableAccess()
 		defer settings.DisableAccess()
 
@@ -187,14 +221,15 @@ func main() {
 			fileLock *os.File
 			deps     *deptree.Tree
 			db       *dbpkg.PkgDb
+			gens     = generations.Open(settings.GenerationsPath)
 		)
 
 		if _, err = os.Stat(settings.LockPath); err == nil {
-			log.Error("Another process is using '%s', exiting", settings.LockPath)
+			err = errs.New(errs.Lock, "LockIsTaken", "another process is using '%s'", settings.LockPath)
 			return
 		} else {
 			if fileLock, err = os.Create(settings.LockPath); err != nil {
-				log.Error("Cannot create lock file: %s\n", err)
+				err = errs.Wrap(errs.Lock, "CannotCreateLock", err, "")
 				return
 			}
 			log.Debugln("Created lock file")
@@ -204,21 +239,22 @@ func main() {
 			log.Debugln("Closed file")
 		}
 		defer func() {
-			if err = os.Chmod(settings.LockPath, 0754); err != nil {
-				log.Error("Cannot change mod for lock file:%s\n", err)
+			// Error of the operation is kept
+			if err := os.Chmod(settings.LockPath, 0754); err != nil {
+				log.Error("Cannot change mod for lock file: %s", err)
 				return
 			}
 			log.Debugln("Changed mod to 0754. Deleting file...")
 
-			if err = os.Remove(settings.LockPath); err != nil {
-				log.Error("Cannot delete file:%s\n", err)
+			if err := os.Remove(settings.LockPath); err != nil {
+				log.Error("Cannot delete file: %s", err)
 				return
 			}
 
 			log.Debugln("Deleted lock file")
 		}()
 
-		if ProgramTask == InstallPkg {
+		if ProgramTask == app.InstallPkg {
 			if _, err = os.Stat(settings.DbJson); err != nil {
 				db = dbpkg.NewDb(settings.DbJson)
 			} else {
@@ -227,52 +263,383 @@ func main() {
 					return
 				}
 			}
-			defer db.WriteData()
+			defer writeDb(db, &err)
 
-			if deps, err = deptree.NewDepTree(settings.RaypmPath, SelectedPackage, settings.Build.Target, db); err != nil {
-				log.Error("Failed to resolve dependencies:\n%s\n", err)
+			if deps, err = deptree.NewDepTree(
+				settings.RaypmPath, SelectedPackage,
+				settings.Build.Host, settings.Build.Target,
+				opts.OutputPath, db,
+			); err != nil {
 				return
-			} else {
-				deps.Install()
 			}
-		} else if ProgramTask == RemovePkg {
+
+			if opts.Jobs > 0 {
+				deps.Jobs = opts.Jobs
+			}
+
+			if deps.Data.Used, err = gens.Used(); err != nil {
+				return
+			}
+
+			if err = deps.Install(); err != nil {
+				return
+			}
+
+			target := deps.Data.Target.String()
+			newGeneration(gens, "install "+SelectedPackage, func(pkgs map[string]string) {
+				for name, out := range deps.Outputs() {
+					pkgs[generations.Key(target, name)] = out
+				}
+			})
+		} else if ProgramTask == app.RemovePkg {
 			if _, err = os.Stat(settings.DbJson); err != nil {
-				log.Errorln("The local database not found, perhaps no packages were installed")
+				err = errs.Wrap(errs.Database, "DatabaseNotFound", err, "perhaps no packages were installed")
 				return
 			}
 
-			db, err := dbpkg.Open(settings.DbJson)
-			if err != nil {
-				log.Errorln(err)
+			if db, err = dbpkg.Open(settings.DbJson); err != nil {
+				return
+			}
+			defer writeDb(db, &err)
+
+			if deps, err = deptree.NewDepTree(
+				settings.RaypmPath, SelectedPackage,
+				settings.Build.Host, settings.Build.Target,
+				"", db,
+			); err != nil {
+				return
+			}
+
+			if deps.Data.Used, err = gens.Used(); err != nil {
+				return
+			}
+
+			if err = deps.Uninstall(); err != nil {
+				return
+			}
+
+			newGeneration(gens, "remove "+SelectedPackage, func(pkgs map[string]string) {
+				delete(pkgs, generations.Key(deps.Data.Target.String(), SelectedPackage))
+			})
+		} else if ProgramTask == app.BuildPkg {
+			if _, err = os.Stat("package.lua"); err != nil {
+				err = errs.New(errs.Usage, "NoPackageFile", "there is no package.lua in current directory")
 				return
 			}
-			defer db.WriteData()
 
-			if deps, err = deptree.NewDepTree(settings.RaypmPath, SelectedPackage, settings.Build.Target, db); err != nil {
-				log.Error("Failed to resolve dependencies:\n%s\n", err)
+			if _, err = os.Stat(settings.DbJson); err != nil {
+				db = dbpkg.NewDb(settings.DbJson)
+			} else if db, err = dbpkg.Open(settings.DbJson); err != nil {
+				return
+			}
+			defer writeDb(db, &err)
+
+			if deps, err = deptree.NewDepTreeFromFile(
+				settings.RaypmPath, "package.lua",
+				settings.Build.Host, settings.Build.Target, db,
+			); err != nil {
+				return
+			}
+
+			if opts.Jobs > 0 {
+				deps.Jobs = opts.Jobs
+			}
+
+			if err = deps.Build(opts.OutputPath); err != nil {
 				return
+			}
+		} else if ProgramTask == app.Rollback {
+			var number int
+
+			if len(opts.Args) > 0 {
+				if number, err = strconv.Atoi(opts.Args[0]); err != nil {
+					err = errs.New(errs.Usage, "WrongGeneration", "'%s' is not a number", opts.Args[0])
+					return
+				}
+				err = gens.Switch(number)
 			} else {
-				deps.Uninstall()
+				number, err = gens.Rollback()
 			}
+
+			if err != nil {
+				return
+			}
+
+			log.Info("Switched to generation %d", number)
+		} else if ProgramTask == app.CollectGarbage {
+			var (
+				pruned  []int
+				removed []string
+				used    map[string]bool
+			)
+
+			if pruned, err = gens.Prune(); err != nil {
+				return
+			}
+
+			if used, err = gens.Used(); err != nil {
+				return
+			}
+
+			if _, err = os.Stat(settings.DbJson); err != nil {
+				db = dbpkg.NewDb(settings.DbJson)
+			} else if db, err = dbpkg.Open(settings.DbJson); err != nil {
+				return
+			}
+
+			if removed, err = deptree.CollectGarbage(settings.RaypmPath, db, used); err != nil {
+				return
+			}
+
+			for _, item := range removed {
+				log.Info("Removed '%s'", item)
+			}
+			log.Info("Removed %d generations and %d packages", len(pruned), len(removed))
 		}
-	case ListPackages:
+	case app.ListGenerations:
 		var (
-			dirs []os.DirEntry
+			gens    = generations.Open(settings.GenerationsPath)
+			current int
+			list    []*generations.Manifest
 		)
 
-		log.Debug("Going to %s\n", settings.PathToPkgs)
-		if err = os.Chdir(settings.PathToPkgs); err != nil {
-			log.Error("Failed to open '%s':\n%s\n", settings.PathToPkgs, err)
+		if current, err = gens.CurrentNumber(); err != nil {
 			return
 		}
 
-		defer func() {
-			os.Chdir(path.Join("..", "..", ".."))
-			log.Debugln("Change directory back")
-		}()
+		if list, err = gens.List(); err != nil {
+			return
+		}
 
-		if dirs, err = os.ReadDir("."); err != nil {
-			log.Error("Failed to read '%s':\n%s\n", settings.PathToPkgs, err)
+		if len(list) == 0 {
+			log.Infoln("There are no generations yet")
+			return
+		}
+
+		for _, item := range list {
+			printLine := color.MagentaString("%4d", item.Number)
+			printLine += fmt.Sprintf("  %s  %s", item.Created.Format(time.DateTime), item.Action)
+
+			if item.Number == current {
+				printLine += color.GreenString("\t[Current]")
+			}
+			fmt.Println(printLine)
+		}
+	case app.PrintEnv, app.SpawnShell, app.ExecCmd:
+		var (
+			deps *deptree.Tree
+			db   *dbpkg.PkgDb
+		)
+
+		if _, err = os.Stat(settings.DbJson); err != nil {
+			db = dbpkg.NewDb(settings.DbJson)
+		} else if db, err = dbpkg.Open(settings.DbJson); err != nil {
+			return
+		}
+
+		if SelectedPackage == "" {
+			if _, err = os.Stat("package.lua"); err != nil {
+				err = errs.New(errs.Usage, "NoPackageFile", "there is no package.lua in current directory, choose a package")
+				return
+			}
+
+			deps, err = deptree.NewDepTreeFromFile(
+				settings.RaypmPath, "package.lua",
+				settings.Build.Host, settings.Build.Target, db,
+			)
+		} else {
+			deps, err = deptree.NewDepTree(
+				settings.RaypmPath, SelectedPackage,
+				settings.Build.Host, settings.Build.Target,
+				"", db,
+			)
+		}
+
+		if err != nil {
+			return
+		}
+
+		// Packages are taken from the active generation
+		profile := generations.Open(settings.GenerationsPath).Profile(settings.Build.Target)
+		if _, serr := os.Stat(path.Join(profile, SelectedPackage)); SelectedPackage != "" && serr != nil {
+			log.Warn("Package '%s' is not installed for '%s'", SelectedPackage, settings.Build.Target)
+		}
+
+		environment := env.FromTree(deps, profile)
+		if len(environment.Missing) > 0 {
+			log.Warn("Dependencies are not installed: %s", strings.Join(environment.Missing, ", "))
+		}
+
+		if ProgramTask == app.SpawnShell {
+			log.Info("Starting shell with '%s' environment, type 'exit' to leave", deps.Nodes.Name)
+			err = environment.Spawn()
+			return
+		}
+
+		if ProgramTask == app.ExecCmd {
+			if err = environment.Run(opts.ExecArgs); err != nil {
+				// Exit code of the command is kept
+				var exitErr *exec.ExitError
+				if errors.As(err, &exitErr) {
+					log.Close()
+					os.Exit(exitErr.ExitCode())
+				}
+			}
+			return
+		}
+
+		shell := opts.Shell
+		if shell == "" {
+			shell = env.DetectShell()
+		}
+
+		var script string
+		if script, err = environment.Export(shell); err != nil {
+			return
+		}
+
+		fmt.Print(script)
+	case app.PkgConfig:
+		// Every package of the active generation has own directory of .pc files
+		profile := generations.Open(settings.GenerationsPath).Profile(settings.Build.Target)
+
+		var (
+			dirs []string
+			pkgs []os.DirEntry
+		)
+		pkgs, _ = os.ReadDir(profile)
+		for _, item := range pkgs {
+			dirs = append(dirs, pkgconfig.Dir(path.Join(profile, item.Name())))
+		}
+
+		if query.Exists {
+			for _, item := range query.Pkgs {
+				if pkgconfig.Find(dirs, item) == "" {
+					os.Exit(1)
+				}
+			}
+			return
+		}
+
+		err = query.Run(dirs, os.Stdout)
+	case app.ShowLog:
+		var (
+			raypmPath = settings.RaypmPath
+			name      = SelectedPackage
+			deps      *deptree.Tree
+		)
+
+		// Logs are kept per store entry, so the tree is resolved for the
+		// target to find it. The database is not needed for that
+		db := dbpkg.NewDb(settings.DbJson)
+
+		// Logs of '-build' are in local '.raypm'
+		if name == "" {
+			if _, err = os.Stat("package.lua"); err != nil {
+				err = errs.New(errs.Usage, "NoPackageFile", "there is no package.lua in current directory, choose a package")
+				return
+			}
+
+			if raypmPath, err = filepath.Abs(".raypm"); err != nil {
+				return
+			}
+
+			if deps, err = deptree.NewDepTreeFromFile(
+				raypmPath, "package.lua",
+				settings.Build.Host, settings.Build.Target, db,
+			); err != nil {
+				return
+			}
+			name = deps.Nodes.Name
+		} else if deps, err = deptree.NewDepTree(
+			raypmPath, name,
+			settings.Build.Host, settings.Build.Target,
+			"", db,
+		); err != nil {
+			return
+		}
+
+		var logs []string
+		if logs, err = deptree.PhaseLogs(raypmPath, deps.Nodes.Entry); err != nil || len(logs) == 0 {
+			err = errs.New(errs.Resolution, "NoLogs", "there are no logs of '%s' for '%s'", name, settings.Build.Target)
+			return
+		}
+
+		for _, item := range logs {
+			phase := strings.TrimSuffix(path.Base(item), ".log")
+			if len(opts.Args) > 1 && phase != opts.Args[1] && !strings.HasPrefix(phase, opts.Args[1]+"-") {
+				continue
+			}
+
+			fmt.Println(color.CyanString("==> %s", item))
+			data, lerr := os.ReadFile(item)
+			if lerr != nil {
+				log.Errorln(lerr)
+				continue
+			}
+			os.Stdout.Write(data)
+		}
+	case app.Lint:
+		paths := opts.Args
+		if len(paths) == 0 {
+			paths = []string{"package.lua"}
+		}
+
+		var files []string
+		if files, err = lint.Find(paths); err != nil {
+			err = errs.Wrap(errs.Usage, "WrongArguments", err, "lint")
+			return
+		}
+
+		errCount, warnCount := 0, 0
+		for _, item := range files {
+			var diags []lint.Diagnostic
+			if diags, err = lint.File(item, settings.PathToPkgs); err != nil {
+				return
+			}
+
+			for _, d := range diags {
+				if d.Severity == lint.Error {
+					errCount++
+				} else {
+					warnCount++
+				}
+				fmt.Println(d)
+			}
+		}
+
+		if errCount > 0 {
+			err = errs.New(errs.Lua, "LintFailed", "%d errors and %d warnings in %d files", errCount, warnCount, len(files))
+			return
+		}
+
+		log.Info("Checked %d files, %d warnings", len(files), warnCount)
+	case app.Serve:
+		// Without directory the build of package.lua in current directory is served
+		dir := "build"
+		if len(opts.Args) > 0 {
+			dir = opts.Args[0]
+		} else if _, err = os.Stat("package.lua"); err == nil {
+			pkg, err := pkglua.NewPackage("package.lua", settings.Build.Host, "web")
+			if err == nil && pkg.MData["build_path"] != "" {
+				dir = pkg.MData["build_path"]
+			}
+		}
+
+		err = web.Serve(dir, opts.Addr)
+	case app.ListPackages:
+		var (
+			dirs []os.DirEntry
+			db   *dbpkg.PkgDb
+		)
+
+		if _, err = os.Stat(settings.DbJson); err != nil {
+			db = dbpkg.NewDb(settings.DbJson)
+		} else if db, err = dbpkg.Open(settings.DbJson); err != nil {
+			return
+		}
+
+		if dirs, err = os.ReadDir(settings.PathToPkgs); err != nil {
 			return
 		}
 
@@ -280,45 +647,72 @@ func main() {
 
 		for _, item := range dirs {
 			if item.IsDir() {
-				currentPackage, err := pkginfo.NewPackageItem(
-					item.Name(),
+				currentPackage, err := pkglua.NewPackage(
+					path.Join(settings.PathToPkgs, item.Name(), "package.lua"),
+					settings.Build.Host,
 					settings.Build.Target,
 				)
 
 				if err != nil {
-					log.Errorln(err)
-					return
+					log.Debug("Skipping '%s': %s", item.Name(), err)
+					continue
 				}
 
-				printLine := color.MagentaString(currentPackage.Name)
-
-				pth := path.Join(settings.RaypmPath, "store", currentPackage.Name)
-				pth = os.Getenv("PWD") + string(os.PathSeparator) + pth
+				printLine := color.MagentaString(currentPackage.MData["name"])
 
-				if _, err = os.Stat(pth); err == nil {
+				if len(db.LookupTarget(currentPackage.MData["name"], settings.Build.Target)) > 0 {
 					printLine += color.GreenString("\t[Installed]")
 				}
-				err = nil
-				fmt.Print(printLine, "\n\t", currentPackage.Description, "\n")
+				fmt.Print(printLine, "\n\t", currentPackage.MData["description"], "\n")
 			}
 		}
-	case FetchPkgInfo:
-		var currentPackage *pkginfo.Package
+	case app.FetchPkgInfo:
+		var currentPackage *pkglua.Package
 
-		if err = os.Chdir(path.Join(settings.PathToPkgs, SelectedPackage)); err != nil {
-			log.Error("Package '%s' does not exists", SelectedPackage)
+		pkgFile := path.Join(settings.PathToPkgs, SelectedPackage, "package.lua")
+		if _, err = os.Stat(pkgFile); err != nil {
+			err = errs.Wrap(errs.Resolution, "PackageNotFound", err, "'%s'", SelectedPackage)
 			return
 		}
 
-		defer func() {
-			os.Chdir(path.Join("..", "..", "..", ".."))
-		}()
-
-		if currentPackage, err = pkginfo.NewPackageItem(".", settings.Build.Target); err != nil {
-			log.Errorln(err)
+		if currentPackage, err = pkglua.NewPackage(pkgFile, settings.Build.Host, settings.Build.Target); err != nil {
+			return
 		}
 
 		fmt.Println("Package Information:")
 		currentPackage.Info()
 	}
 }
+
+// Database is written after failure too, its error is kept, if the
+// operation succeeded
+func writeDb(db *dbpkg.PkgDb, err *error) {
+	if werr := db.WriteData(); *err == nil {
+		*err = werr
+	}
+}
+
+// Creates new generation from the current one. 'update' changes list of
+// packages, if nothing was changed, the generation is not created
+func newGeneration(gens *generations.Generations, action string, update func(pkgs map[string]string)) {
+	current, err := gens.Current()
+	if err != nil {
+		log.Errorln("Failed to create generation:", err)
+		return
+	}
+
+	pkgs := maps.Clone(current.Pkgs)
+	update(pkgs)
+	if maps.Equal(pkgs, current.Pkgs) {
+		log.Debugln("Packages are not changed, generation is not created")
+		return
+	}
+
+	m, err := gens.Create(action, pkgs)
+	if err != nil {
+		log.Errorln("Failed to create generation:", err)
+		return
+	}
+
+	log.Info("Generation %d created", m.Number)
+}